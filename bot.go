@@ -0,0 +1,677 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// botDefaultRate is the limit of the actions of all the rules when the
+	// rules do not give rate.
+	botDefaultRate = "100/h"
+	// botHandledTTL is how long the posts acted on are remembered, which is
+	// longer than the relays keep the events to replay.
+	botHandledTTL = 7 * 24 * time.Hour
+	// botQueueSize is the number of the posts waiting for the actions. The
+	// posts are skipped when the queue is full.
+	botQueueSize = 100
+)
+
+// botConfig is the rules file of bsky bot.
+//
+//	rate: 30/h
+//	cooldown: 10m
+//	rules:
+//	  - name: thanks
+//	    match:
+//	      mention: true
+//	      pattern: '(?i)thank'
+//	    reply: 'You are welcome, @{{.Handle}}!'
+//	    like: true
+type botConfig struct {
+	// Rate is the limit of the actions of all the rules like 30/h.
+	Rate string `yaml:"rate"`
+	// Cooldown is how long a rule does not act on the same author again,
+	// unless the rule gives its own.
+	Cooldown time.Duration   `yaml:"cooldown"`
+	Rules    []botRuleConfig `yaml:"rules"`
+}
+
+type botRuleConfig struct {
+	Name     string         `yaml:"name"`
+	Match    botMatchConfig `yaml:"match"`
+	Cooldown *time.Duration `yaml:"cooldown"`
+
+	// the actions, done in this order
+	Reply  string `yaml:"reply"`
+	Like   bool   `yaml:"like"`
+	Repost bool   `yaml:"repost"`
+	Follow bool   `yaml:"follow"`
+	List   string `yaml:"list"`
+}
+
+// botMatchConfig is the conditions of a rule. A post matches the rule when
+// it matches all the conditions given, and any of the values of a list.
+type botMatchConfig struct {
+	Pattern string     `yaml:"pattern"`
+	Mention bool       `yaml:"mention"`
+	Hashtag stringList `yaml:"hashtag"`
+	Did     stringList `yaml:"did"`
+}
+
+// stringList is a list in YAML, which can be written as a single value too.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = []string{node.Value}
+		return nil
+	}
+	return node.Decode((*[]string)(l))
+}
+
+// botRule is a rule compiled from botRuleConfig.
+type botRule struct {
+	name     string
+	pattern  *regexp.Regexp
+	mention  bool
+	hashtags []string
+	dids     map[string]bool
+	cooldown time.Duration
+
+	reply  *template.Template
+	like   bool
+	repost bool
+	follow bool
+	list   string
+}
+
+// parseBotConfig parses the rules file.
+func parseBotConfig(b []byte) (*botConfig, error) {
+	var cfg botConfig
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("cannot read rules: %w", err)
+	}
+	if len(cfg.Rules) == 0 {
+		return nil, errors.New("no rules")
+	}
+	return &cfg, nil
+}
+
+// parseRate parses the rate like 30/h or 5/10m to the number of the events
+// per the period.
+func parseRate(s string) (int, time.Duration, error) {
+	n, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid rate: %q (must be like 30/h)", s)
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate: %q (must be like 30/h)", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate: %q (must be like 30/h)", s)
+	}
+	return count, d, nil
+}
+
+func (c *botRuleConfig) compile(i int, cooldown time.Duration) (*botRule, error) {
+	r := &botRule{
+		name:     c.Name,
+		mention:  c.Match.Mention,
+		cooldown: cooldown,
+		like:     c.Like,
+		repost:   c.Repost,
+		follow:   c.Follow,
+		list:     c.List,
+	}
+	if r.name == "" {
+		r.name = fmt.Sprintf("rule %d", i+1)
+	}
+	if c.Cooldown != nil {
+		r.cooldown = *c.Cooldown
+	}
+	if c.Match.Pattern != "" {
+		re, err := regexp.Compile(c.Match.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.name, err)
+		}
+		r.pattern = re
+	}
+	for _, tag := range c.Match.Hashtag {
+		r.hashtags = append(r.hashtags, strings.TrimPrefix(tag, "#"))
+	}
+	if len(c.Match.Did) > 0 {
+		r.dids = map[string]bool{}
+		for _, did := range c.Match.Did {
+			r.dids[did] = true
+		}
+	}
+	// a rule without conditions would act on every post of the network
+	if r.pattern == nil && !r.mention && len(r.hashtags) == 0 && r.dids == nil {
+		return nil, fmt.Errorf("%s: no conditions in match", r.name)
+	}
+	if c.Reply != "" {
+		tmpl, err := template.New(r.name).Funcs(templateFuncs).Parse(c.Reply)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.name, err)
+		}
+		r.reply = tmpl
+	}
+	if r.actions() == 0 {
+		return nil, fmt.Errorf("%s: no actions", r.name)
+	}
+	if r.list != "" && !strings.HasPrefix(r.list, "at://") {
+		return nil, fmt.Errorf("%s: list must be the at:// URI of the list", r.name)
+	}
+	return r, nil
+}
+
+// actions returns the number of the actions of the rule.
+func (r *botRule) actions() int {
+	n := 0
+	for _, ok := range []bool{r.reply != nil, r.like, r.repost, r.follow, r.list != ""} {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+// matches reports whether the post of did matches the rule. me is the DID
+// of the bot.
+func (r *botRule) matches(me, did string, post *bsky.FeedPost) bool {
+	if r.dids != nil && !r.dids[did] {
+		return false
+	}
+	if r.mention && !mentionsDid(post, me) {
+		return false
+	}
+	if len(r.hashtags) > 0 && !slices.ContainsFunc(postTags(post), func(tag string) bool {
+		return slices.ContainsFunc(r.hashtags, func(want string) bool {
+			return strings.EqualFold(tag, want)
+		})
+	}) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(post.Text) {
+		return false
+	}
+	return true
+}
+
+// postTags returns the hashtags of the post, in the facets and in the tags
+// not shown in the text.
+func postTags(post *bsky.FeedPost) []string {
+	tags := slices.Clone(post.Tags)
+	for _, facet := range post.Facets {
+		for _, feature := range facet.Features {
+			if t := feature.RichtextFacet_Tag; t != nil {
+				tags = append(tags, t.Tag)
+			}
+		}
+	}
+	return tags
+}
+
+// botState is what bsky bot remembers between runs. The times are in Unix
+// seconds.
+type botState struct {
+	// Handled are the times the posts were acted on by URI, not to act on
+	// them twice when the events are replayed.
+	Handled map[string]int64 `json:"handled,omitempty"`
+
+	// Cooldowns are the ends of the cooldowns by the rule and the author.
+	Cooldowns map[string]int64 `json:"cooldowns,omitempty"`
+}
+
+// botPost is the post given to the reply templates.
+type botPost struct {
+	Rule string
+	Did  string
+	Uri  string
+	Text string
+	// Match is the match of the pattern and its submatches.
+	Match []string
+
+	handle func() (string, error)
+}
+
+// Handle returns the handle of the author, which is looked up only when the
+// template uses it.
+func (p *botPost) Handle() (string, error) {
+	return p.handle()
+}
+
+// botJob is a post to act on with the rules matched.
+type botJob struct {
+	ev    *streamEvent
+	post  *bsky.FeedPost
+	uri   string
+	rules []*botRule
+}
+
+// bot acts on the posts of the stream with the rules. It never acts on its
+// own posts, acts on a post only once, waits for the cooldown of a rule for
+// the same author, and skips the actions over the rate limit. With dryRun,
+// the actions are only printed.
+//
+// The posts are matched while reading the stream, and the actions are done
+// by run, so that a slow server does not stall the stream.
+type bot struct {
+	me      string
+	rules   []*botRule
+	limiter *rate.Limiter
+	client  *sessionClient
+	dryRun  bool
+	now     func() time.Time
+	queue   chan *botJob
+
+	mu    sync.Mutex
+	state *botState
+	// queued are the seqs of the posts in the queue by URI.
+	queued map[string]int64
+	// save saves the state. It is nil with dryRun.
+	save func(*botState) error
+}
+
+func newBot(cfg *botConfig, me string, client *sessionClient, state *botState) (*bot, error) {
+	count, per, err := parseRate(cmp.Or(cfg.Rate, botDefaultRate))
+	if err != nil {
+		return nil, err
+	}
+	b := &bot{
+		me:      me,
+		limiter: rate.NewLimiter(rate.Every(per/time.Duration(count)), count),
+		client:  client,
+		now:     time.Now,
+		queue:   make(chan *botJob, botQueueSize),
+		state:   &botState{Handled: map[string]int64{}, Cooldowns: map[string]int64{}},
+		queued:  map[string]int64{},
+	}
+	if state != nil {
+		maps.Copy(b.state.Handled, state.Handled)
+		maps.Copy(b.state.Cooldowns, state.Cooldowns)
+	}
+	names := map[string]bool{}
+	for i := range cfg.Rules {
+		r, err := cfg.Rules[i].compile(i, cfg.Cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule: %w", err)
+		}
+		if names[r.name] {
+			return nil, fmt.Errorf("invalid rule: %s: duplicated name", r.name)
+		}
+		names[r.name] = true
+		if r.actions() > count {
+			return nil, fmt.Errorf("invalid rule: %s: more actions than the rate %d allows at once", r.name, count)
+		}
+		b.rules = append(b.rules, r)
+	}
+	return b, nil
+}
+
+// handle queues the new post of ev for the actions of the rules matched.
+func (b *bot) handle(ev *streamEvent) {
+	post, ok := ev.Rec.(*bsky.FeedPost)
+	if !ok || ev.Kind != streamKindCommit || ev.Op != repomgr.EvtKindCreateRecord {
+		return
+	}
+	// the own posts are never acted on, not to reply to the replies forever
+	if ev.Did == b.me {
+		return
+	}
+	uri := "at://" + ev.Did + "/" + ev.Path
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.state.Handled[uri]; ok {
+		return
+	}
+	if _, ok := b.queued[uri]; ok {
+		return
+	}
+
+	now := b.now()
+	var rules []*botRule
+	for _, r := range b.rules {
+		if !r.matches(b.me, ev.Did, post) {
+			continue
+		}
+		if until, ok := b.state.Cooldowns[r.name+" "+ev.Did]; ok && now.Unix() < until {
+			log.Printf("%s: %s is in cooldown, skipping %s", r.name, ev.Did, uri)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return
+	}
+	// handle is the only sender, so the queue does not fill up after this
+	if len(b.queue) == cap(b.queue) {
+		log.Printf("too many posts waiting for the actions, skipping %s", uri)
+		return
+	}
+
+	job := &botJob{ev: ev, post: post, uri: uri}
+	for _, r := range rules {
+		if !b.limiter.AllowN(now, r.actions()) {
+			log.Printf("%s: rate limited, skipping %s", r.name, uri)
+			continue
+		}
+		job.rules = append(job.rules, r)
+		if r.cooldown > 0 {
+			b.state.Cooldowns[r.name+" "+ev.Did] = now.Add(r.cooldown).Unix()
+		}
+	}
+	if len(job.rules) == 0 {
+		return
+	}
+	b.queued[uri] = ev.Seq
+	b.queue <- job
+}
+
+// run does the actions of the posts queued until ctx is done or the queue
+// is closed. The failures of the actions are logged, not to stop the bot at
+// them. A post is remembered as handled after its actions are done.
+func (b *bot) run(ctx context.Context) {
+	for {
+		var job *botJob
+		select {
+		case <-ctx.Done():
+			return
+		case job = <-b.queue:
+			if job == nil {
+				return
+			}
+		}
+		for _, r := range job.rules {
+			b.act(ctx, r, job.ev, job.post, job.uri)
+		}
+
+		b.mu.Lock()
+		now := b.now()
+		delete(b.queued, job.uri)
+		b.state.Handled[job.uri] = now.Unix()
+		b.prune(now)
+		var err error
+		if b.save != nil {
+			err = b.save(b.state)
+		}
+		b.mu.Unlock()
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// cursor returns the cursor to resume the stream from, given the cursor of
+// the events read. It is before the posts whose actions are not done yet,
+// so that they are acted on after a restart.
+func (b *bot) cursor(read int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, seq := range b.queued {
+		if read == 0 || seq-1 < read {
+			read = seq - 1
+		}
+	}
+	return read
+}
+
+// prune drops the posts and the cooldowns which are not needed any more.
+func (b *bot) prune(now time.Time) {
+	maps.DeleteFunc(b.state.Handled, func(_ string, t int64) bool {
+		return now.Sub(time.Unix(t, 0)) > botHandledTTL
+	})
+	maps.DeleteFunc(b.state.Cooldowns, func(_ string, until int64) bool {
+		return now.Unix() >= until
+	})
+}
+
+// act does the actions of the rule for the post.
+func (b *bot) act(ctx context.Context, r *botRule, ev *streamEvent, post *bsky.FeedPost, uri string) {
+	ref, err := postRef(ev)
+	if err != nil {
+		log.Printf("%s: %v", r.name, err)
+		return
+	}
+	if r.reply != nil {
+		data := &botPost{
+			Rule: r.name,
+			Did:  ev.Did,
+			Uri:  uri,
+			Text: post.Text,
+			handle: func() (string, error) {
+				var profile *bsky.ActorDefs_ProfileViewDetailed
+				err := b.client.do(func(xrpcc *xrpc.Client) (err error) {
+					profile, err = bsky.ActorGetProfile(ctx, xrpcc, ev.Did)
+					return err
+				})
+				if err != nil {
+					return "", fmt.Errorf("cannot get profile: %w", err)
+				}
+				return profile.Handle, nil
+			},
+		}
+		if r.pattern != nil {
+			data.Match = r.pattern.FindStringSubmatch(post.Text)
+		}
+		var buf strings.Builder
+		if err := r.reply.Execute(&buf, data); err != nil {
+			log.Printf("%s: cannot make reply: %v", r.name, err)
+		} else {
+			text := buf.String()
+			b.do(r, "reply", uri, text, func(xrpcc *xrpc.Client) error {
+				_, err := createReply(xrpcc, ref, post, text)
+				return err
+			})
+		}
+	}
+	if r.like {
+		b.do(r, "like", uri, "", func(xrpcc *xrpc.Client) error {
+			_, err := createLike(xrpcc, ref)
+			return err
+		})
+	}
+	if r.repost {
+		b.do(r, "repost", uri, "", func(xrpcc *xrpc.Client) error {
+			_, err := createRepost(xrpcc, ref)
+			return err
+		})
+	}
+	if r.follow {
+		b.do(r, "follow", ev.Did, "", func(xrpcc *xrpc.Client) error {
+			profile, err := bsky.ActorGetProfile(ctx, xrpcc, ev.Did)
+			if err != nil {
+				return fmt.Errorf("cannot get profile: %w", err)
+			}
+			if profile.Viewer != nil && profile.Viewer.Following != nil {
+				return nil
+			}
+			_, err = createFollow(xrpcc, ev.Did)
+			return err
+		})
+	}
+	if r.list != "" {
+		b.do(r, "list", ev.Did, r.list, func(xrpcc *xrpc.Client) error {
+			_, err := addListItem(xrpcc, r.list, ev.Did)
+			return err
+		})
+	}
+}
+
+// do does the action with call and prints it, or only prints it with
+// dryRun.
+func (b *bot) do(r *botRule, action, target, detail string, call func(*xrpc.Client) error) {
+	if !b.dryRun {
+		if err := b.client.do(call); err != nil {
+			log.Printf("%s: cannot %s %s: %v", r.name, action, target, err)
+			return
+		}
+	}
+	if b.dryRun {
+		fmt.Print("(dry-run) ")
+	}
+	color.Set(color.FgHiRed)
+	fmt.Print(r.name)
+	color.Set(color.Reset)
+	fmt.Print(" ", action, " ", target)
+	if detail != "" {
+		fmt.Printf(" %q", detail)
+	}
+	fmt.Println()
+}
+
+// postRef returns the strong reference to the record of ev.
+func postRef(ev *streamEvent) (*comatproto.RepoStrongRef, error) {
+	if ev.Rcid == nil {
+		return nil, fmt.Errorf("no CID of %s in %s", ev.Path, ev.Did)
+	}
+	return &comatproto.RepoStrongRef{Uri: "at://" + ev.Did + "/" + ev.Path, Cid: ev.Rcid.String()}, nil
+}
+
+// createReply replies to the post of parent with text, in the thread of the
+// post, and returns the URI of the reply.
+func createReply(xrpcc *xrpc.Client, parent *comatproto.RepoStrongRef, orig *bsky.FeedPost, text string) (string, error) {
+	if err := validatePostText(text); err != nil {
+		return "", err
+	}
+	reply := &bsky.FeedPost_ReplyRef{Root: parent, Parent: parent}
+	if orig.Reply != nil && orig.Reply.Root != nil {
+		reply.Root = &comatproto.RepoStrongRef{Cid: orig.Reply.Root.Cid, Uri: orig.Reply.Root.Uri}
+	}
+	post := &bsky.FeedPost{
+		Text:      text,
+		CreatedAt: time.Now().Local().Format(time.RFC3339),
+		Reply:     reply,
+		Facets:    makeFacets(xrpcc, text),
+	}
+	resp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.feed.post",
+		Repo:       xrpcc.Auth.Did,
+		Record: &lexutil.LexiconTypeDecoder{
+			Val: post,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot create post: %w", err)
+	}
+	return resp.Uri, nil
+}
+
+func doBot(cCtx *cli.Context) error {
+	fn := cCtx.String("config")
+	if fn == "" {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	jetstream := cCtx.Bool("jetstream")
+	if !jetstream && cCtx.Bool("compress") {
+		return fmt.Errorf("--compress is available only with --jetstream")
+	}
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return fmt.Errorf("cannot read rules: %w", err)
+	}
+	cfg, err := parseBotConfig(b)
+	if err != nil {
+		return err
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+	st, err := loadState(cCtx)
+	if err != nil {
+		return err
+	}
+	bt, err := newBot(cfg, xrpcc.Auth.Did, newSessionClient(cCtx, xrpcc), st.Bot)
+	if err != nil {
+		return err
+	}
+	bt.dryRun = cCtx.Bool("dry-run")
+	if !bt.dryRun {
+		bt.save = func(bs *botState) error {
+			return updateState(cCtx, func(st *state) {
+				st.Bot = bs
+			})
+		}
+	}
+
+	host, err := selectStreamHost(cCtx, jetstream, []string{"app.bsky.feed.post"}, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := &streamReader{
+		handle: func(ev *streamEvent) error {
+			bt.handle(ev)
+			return nil
+		},
+	}
+	if !jetstream {
+		s.dir = identity.DefaultDirectory()
+		s.client = cliutil.NewHttpClient()
+	}
+	go bt.run(ctx)
+
+	// the posts acted on are remembered, so the events replayed from the
+	// cursor are not acted on twice
+	if cCtx.Bool("resume") {
+		cp, err := loadStreamCheckpoint(cCtx, "bot:"+host)
+		if err != nil {
+			return err
+		}
+		s.cursor.Store(cp.saved)
+		cursor := func() (int64, error) {
+			return bt.cursor(s.cursor.Load()), nil
+		}
+		go cp.run(ctx, cursor)
+		defer func() {
+			n, _ := cursor()
+			if err := cp.save(n); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	return s.run(ctx, host, jetstream, cCtx.Bool("compress"))
+}
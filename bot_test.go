@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		s     string
+		count int
+		per   time.Duration
+		err   bool
+	}{
+		{s: "30/h", count: 30, per: time.Hour},
+		{s: "5/10m", count: 5, per: 10 * time.Minute},
+		{s: "1/s", count: 1, per: time.Second},
+		{s: "30", err: true},
+		{s: "0/h", err: true},
+		{s: "3/day", err: true},
+	}
+	for _, tt := range tests {
+		count, per, err := parseRate(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseRate(%q) should fail", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRate(%q): %v", tt.s, err)
+			continue
+		}
+		if count != tt.count || per != tt.per {
+			t.Errorf("parseRate(%q) = %d, %v, want %d, %v", tt.s, count, per, tt.count, tt.per)
+		}
+	}
+}
+
+func TestParseBotConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{
+			name: "valid",
+			yaml: "rules:\n  - match: {did: did:plc:alice}\n    like: true\n",
+		},
+		{
+			name: "empty",
+			yaml: "",
+			err:  "no rules",
+		},
+		{
+			name: "unknown field",
+			yaml: "rules:\n  - match: {did: did:plc:alice}\n    lke: true\n",
+			err:  "field lke not found",
+		},
+		{
+			name: "no conditions",
+			yaml: "rules:\n  - like: true\n",
+			err:  "no conditions",
+		},
+		{
+			name: "no actions",
+			yaml: "rules:\n  - match: {mention: true}\n",
+			err:  "no actions",
+		},
+		{
+			name: "invalid pattern",
+			yaml: "rules:\n  - match: {pattern: '('}\n    like: true\n",
+			err:  "missing closing )",
+		},
+		{
+			name: "duplicated name",
+			yaml: "rules:\n  - {name: a, match: {mention: true}, like: true}\n  - {name: a, match: {mention: true}, repost: true}\n",
+			err:  "duplicated name",
+		},
+		{
+			name: "over the rate",
+			yaml: "rate: 1/h\nrules:\n  - {match: {mention: true}, like: true, repost: true}\n",
+			err:  "more actions than the rate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseBotConfig([]byte(tt.yaml))
+			if err == nil {
+				_, err = newBot(cfg, "did:plc:me", nil, nil)
+			}
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("want error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+const testBotRules = `
+rate: 3/h
+cooldown: 1h
+rules:
+  - name: thanks
+    match:
+      mention: true
+      pattern: '(?i)thank (\w+)'
+    reply: 'You are welcome, @{{.Handle}} ({{index .Match 1}})'
+    like: true
+  - name: golang
+    match:
+      hashtag: '#golang'
+    repost: true
+`
+
+func newTestBot(t *testing.T, dryRun bool) (*bot, *mockXRPC, *[]*botState) {
+	t.Helper()
+	m := &mockXRPC{responses: map[string]string{
+		"com.atproto.repo.createRecord": `{"uri":"at://did:plc:me/app.bsky.feed.post/r","cid":"bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"}`,
+		"app.bsky.actor.getProfile":     `{"did":"did:plc:alice","handle":"alice.test"}`,
+	}, calls: map[string][]string{}}
+	ts := httptest.NewServer(m)
+	t.Cleanup(ts.Close)
+	xrpcc := &xrpc.Client{
+		Client: ts.Client(),
+		Host:   ts.URL,
+		Auth:   &xrpc.AuthInfo{Did: "did:plc:me", Handle: "me.test", AccessJwt: "jwt"},
+	}
+
+	cfg, err := parseBotConfig([]byte(testBotRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newBot(cfg, "did:plc:me", newSessionClient(nil, xrpcc), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	b.dryRun = dryRun
+	var saved []*botState
+	if !dryRun {
+		b.save = func(bs *botState) error {
+			saved = append(saved, bs)
+			return nil
+		}
+	}
+	return b, m, &saved
+}
+
+// handleAll handles the events and does the actions queued.
+func handleAll(b *bot, events ...*streamEvent) {
+	for _, ev := range events {
+		b.handle(ev)
+	}
+	close(b.queue)
+	b.run(context.Background())
+}
+
+func botEvent(t *testing.T, did, rkey string, post *bsky.FeedPost) *streamEvent {
+	t.Helper()
+	c, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &streamEvent{
+		Kind: streamKindCommit,
+		Op:   repomgr.EvtKindCreateRecord,
+		Path: "app.bsky.feed.post/" + rkey,
+		Did:  did,
+		Rcid: &c,
+		Rec:  post,
+	}
+}
+
+func mentionPost(text, did string) *bsky.FeedPost {
+	return &bsky.FeedPost{
+		Text: text,
+		Facets: []*bsky.RichtextFacet{{
+			Features: []*bsky.RichtextFacet_Features_Elem{{RichtextFacet_Mention: &bsky.RichtextFacet_Mention{Did: did}}},
+			Index:    &bsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: 8},
+		}},
+	}
+}
+
+func TestBot(t *testing.T) {
+	b, m, saved := newTestBot(t, false)
+	golang := &bsky.FeedPost{Text: "release", Tags: []string{"GoLang"}}
+	events := []*streamEvent{
+		// the own post
+		botEvent(t, "did:plc:me", "1", mentionPost("@me.test thank you", "did:plc:me")),
+		// reply and like
+		botEvent(t, "did:plc:alice", "2", mentionPost("@me.test thank you", "did:plc:me")),
+		// replayed
+		botEvent(t, "did:plc:alice", "2", mentionPost("@me.test thank you", "did:plc:me")),
+		// in the cooldown
+		botEvent(t, "did:plc:alice", "3", mentionPost("@me.test thank you again", "did:plc:me")),
+		// not mentioning
+		botEvent(t, "did:plc:alice", "4", &bsky.FeedPost{Text: "thank you"}),
+		// repost
+		botEvent(t, "did:plc:bob", "5", golang),
+		// over the rate
+		botEvent(t, "did:plc:carol", "6", golang),
+	}
+	handleAll(b, events...)
+
+	records := m.calls["com.atproto.repo.createRecord"]
+	if len(records) != 3 {
+		t.Fatalf("want 3 records, got %d: %v", len(records), records)
+	}
+	for i, want := range []string{
+		`"text":"You are welcome, @alice.test (you)"`,
+		`"$type":"app.bsky.feed.like"`,
+		`"$type":"app.bsky.feed.repost"`,
+	} {
+		if !strings.Contains(records[i], want) {
+			t.Errorf("record %d should contain %s: %s", i, want, records[i])
+		}
+	}
+	if !strings.Contains(records[0], `"parent":{"cid":"bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm","uri":"at://did:plc:alice/app.bsky.feed.post/2"}`) {
+		t.Errorf("the reply should be to the post: %s", records[0])
+	}
+
+	if len(*saved) != 2 {
+		t.Fatalf("want the state saved twice, got %d", len(*saved))
+	}
+	st := (*saved)[1]
+	for _, uri := range []string{"at://did:plc:alice/app.bsky.feed.post/2", "at://did:plc:bob/app.bsky.feed.post/5"} {
+		if _, ok := st.Handled[uri]; !ok {
+			t.Errorf("%s should be handled: %v", uri, st.Handled)
+		}
+	}
+	for _, key := range []string{"thanks did:plc:alice", "golang did:plc:bob"} {
+		if _, ok := st.Cooldowns[key]; !ok {
+			t.Errorf("%s should be in the cooldown: %v", key, st.Cooldowns)
+		}
+	}
+}
+
+func TestBotDryRun(t *testing.T) {
+	b, m, _ := newTestBot(t, true)
+	ev := botEvent(t, "did:plc:alice", "2", mentionPost("@me.test thank you", "did:plc:me"))
+	handleAll(b, ev, ev)
+	if records := m.calls["com.atproto.repo.createRecord"]; len(records) != 0 {
+		t.Fatalf("no records should be created: %v", records)
+	}
+	if _, ok := b.state.Handled["at://did:plc:alice/app.bsky.feed.post/2"]; !ok {
+		t.Error("the post should be remembered while running")
+	}
+}
+
+func TestBotQueue(t *testing.T) {
+	b, m, saved := newTestBot(t, false)
+	b.queue = make(chan *botJob, 1)
+	ev := botEvent(t, "did:plc:alice", "2", mentionPost("@me.test thank you", "did:plc:me"))
+	ev.Seq = 10
+	b.handle(ev)
+	// the queue is full
+	golang := botEvent(t, "did:plc:bob", "5", &bsky.FeedPost{Text: "release", Tags: []string{"golang"}})
+	golang.Seq = 11
+	b.handle(golang)
+
+	if calls := m.calls["com.atproto.repo.createRecord"]; len(calls) != 0 {
+		t.Fatalf("the actions should not be done while reading: %v", calls)
+	}
+	if got := b.cursor(11); got != 9 {
+		t.Fatalf("want the cursor before the post queued, got %d", got)
+	}
+	if len(*saved) != 0 {
+		t.Fatal("the post should not be handled before the actions")
+	}
+
+	close(b.queue)
+	b.run(context.Background())
+	if calls := m.calls["com.atproto.repo.createRecord"]; len(calls) != 2 {
+		t.Fatalf("want the reply and the like, got %v", calls)
+	}
+	if got := b.cursor(11); got != 11 {
+		t.Fatalf("want the cursor read after the actions, got %d", got)
+	}
+	if _, ok := b.state.Handled["at://did:plc:bob/app.bsky.feed.post/5"]; ok {
+		t.Fatal("the post skipped should not be handled")
+	}
+	if _, ok := b.state.Cooldowns["golang did:plc:bob"]; ok {
+		t.Fatal("the post skipped should not start the cooldown")
+	}
+}
+
+func TestBotPrune(t *testing.T) {
+	b, _, _ := newTestBot(t, false)
+	now := b.now()
+	b.state.Handled["at://old"] = now.Add(-botHandledTTL - time.Hour).Unix()
+	b.state.Handled["at://new"] = now.Add(-time.Hour).Unix()
+	b.state.Cooldowns["a did:plc:old"] = now.Add(-time.Minute).Unix()
+	b.state.Cooldowns["a did:plc:new"] = now.Add(time.Minute).Unix()
+	b.prune(now)
+	if _, ok := b.state.Handled["at://old"]; ok {
+		t.Error("the old post should be pruned")
+	}
+	if _, ok := b.state.Handled["at://new"]; !ok {
+		t.Error("the new post should be kept")
+	}
+	if _, ok := b.state.Cooldowns["a did:plc:old"]; ok {
+		t.Error("the cooldown ended should be pruned")
+	}
+	if _, ok := b.state.Cooldowns["a did:plc:new"]; !ok {
+		t.Error("the cooldown should be kept")
+	}
+}
+
+func TestStreamHandlerReply(t *testing.T) {
+	m := &mockXRPC{responses: map[string]string{}, calls: map[string][]string{}}
+	ts := httptest.NewServer(m)
+	t.Cleanup(ts.Close)
+	xrpcc := &xrpc.Client{
+		Client: ts.Client(),
+		Host:   ts.URL,
+		Auth:   &xrpc.AuthInfo{Did: "did:plc:me", Handle: "me.test", AccessJwt: "jwt"},
+	}
+	set := flag.NewFlagSet("stream", flag.ContinueOnError)
+	set.Bool("json", true, "")
+	h := &streamHandler{
+		cCtx:   cli.NewContext(cli.NewApp(), set, nil),
+		reply:  "hello",
+		enc:    json.NewEncoder(io.Discard),
+		client: newSessionClient(nil, xrpcc),
+	}
+
+	if err := h.handle(botEvent(t, "did:plc:me", "1", &bsky.FeedPost{Text: "mine"})); err != nil {
+		t.Fatal(err)
+	}
+	if calls := m.calls["com.atproto.repo.createRecord"]; len(calls) != 0 {
+		t.Fatalf("the own post should not be replied to: %v", calls)
+	}
+	// the failure is returned, not to panic with the response
+	if err := h.handle(botEvent(t, "did:plc:alice", "2", &bsky.FeedPost{Text: "hi"})); err == nil {
+		t.Fatal("the failure of the reply should be returned")
+	}
+	if calls := m.calls["com.atproto.repo.createRecord"]; len(calls) != 1 {
+		t.Fatalf("want a reply, got %v", calls)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/bluesky-social/indigo/api/bsky"
@@ -78,6 +77,11 @@ func doConvos(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeChatXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -90,9 +94,11 @@ func doConvos(cCtx *cli.Context) error {
 			return fmt.Errorf("cannot list conversations: %w", err)
 		}
 
-		if cCtx.Bool("json") {
+		if out != nil {
 			for _, c := range resp.Convos {
-				json.NewEncoder(os.Stdout).Encode(c)
+				if err := out.write(c); err != nil {
+					return err
+				}
 			}
 		} else {
 			for _, c := range resp.Convos {
@@ -125,7 +131,7 @@ func doConvos(cCtx *cli.Context) error {
 		}
 		cursor = *resp.Cursor
 	}
-	return nil
+	return out.close()
 }
 
 func doConvo(cCtx *cli.Context) error {
@@ -133,6 +139,11 @@ func doConvo(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeChatXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -146,11 +157,17 @@ func doConvo(cCtx *cli.Context) error {
 		return fmt.Errorf("cannot get messages: %w", err)
 	}
 
-	if cCtx.Bool("json") {
+	if out != nil {
 		for _, m := range resp.Messages {
-			json.NewEncoder(os.Stdout).Encode(m)
+			var data any = m
+			if m.ConvoDefs_MessageView != nil {
+				data = m.ConvoDefs_MessageView
+			}
+			if err := out.writeAs(m, data); err != nil {
+				return err
+			}
 		}
-		return nil
+		return out.close()
 	}
 
 	// reverse to show oldest first
@@ -210,17 +227,26 @@ func doChat(cCtx *cli.Context) error {
 		return fmt.Errorf("cannot get conversation: %w", err)
 	}
 
-	// send message
-	msgResp, err := chat.ConvoSendMessage(context.TODO(), xrpcc, &chat.ConvoSendMessage_Input{
-		ConvoId: convoResp.Convo.Id,
+	msg, err := sendChatMessage(xrpcc, convoResp.Convo.Id, text, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Message sent (id: %s)\n", msg.Id)
+	return nil
+}
+
+// sendChatMessage sends text with facets to the conversation.
+func sendChatMessage(xrpcc *xrpc.Client, convoId, text string, facets []*bsky.RichtextFacet) (*chat.ConvoDefs_MessageView, error) {
+	resp, err := chat.ConvoSendMessage(context.TODO(), xrpcc, &chat.ConvoSendMessage_Input{
+		ConvoId: convoId,
 		Message: &chat.ConvoDefs_MessageInput{
-			Text: text,
+			Text:   text,
+			Facets: facets,
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("cannot send message: %w", err)
+		return nil, fmt.Errorf("cannot send message: %w", err)
 	}
-
-	fmt.Printf("Message sent (id: %s)\n", msgResp.Id)
-	return nil
+	return resp, nil
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/urfave/cli/v2"
+)
+
+// applyWritesLimit is the maximum number of writes in one applyWrites call.
+const applyWritesLimit = 200
+
+var collectionAliases = map[string]string{
+	"post":   "app.bsky.feed.post",
+	"like":   "app.bsky.feed.like",
+	"repost": "app.bsky.feed.repost",
+	"follow": "app.bsky.graph.follow",
+	"block":  "app.bsky.graph.block",
+}
+
+func collectionName(s string) (string, error) {
+	if c, ok := collectionAliases[s]; ok {
+		return c, nil
+	}
+	if strings.Count(s, ".") < 2 {
+		return "", fmt.Errorf("invalid collection: %q", s)
+	}
+	return s, nil
+}
+
+// parseDate parses s as a date in local time or as a RFC3339 timestamp.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+type deleteFilter struct {
+	before    time.Time
+	after     time.Time
+	match     *regexp.Regexp
+	noReplies bool
+}
+
+// recordCreatedAt returns createdAt of any record.
+func recordCreatedAt(v any) (time.Time, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var rec struct {
+		CreatedAt string `json:"createdAt"`
+	}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return time.Time{}, err
+	}
+	return parseTime(rec.CreatedAt)
+}
+
+func (f *deleteFilter) matches(v any) bool {
+	if !f.before.IsZero() || !f.after.IsZero() {
+		t, err := recordCreatedAt(v)
+		if err != nil {
+			return false
+		}
+		if !f.before.IsZero() && !t.Before(f.before) {
+			return false
+		}
+		if !f.after.IsZero() && t.Before(f.after) {
+			return false
+		}
+	}
+	post, isPost := v.(*bsky.FeedPost)
+	if f.match != nil && (!isPost || !f.match.MatchString(post.Text)) {
+		return false
+	}
+	if f.noReplies && isPost && post.Reply != nil {
+		return false
+	}
+	return true
+}
+
+func recordSummary(v any) string {
+	switch v := v.(type) {
+	case *bsky.FeedPost:
+		text, _, _ := strings.Cut(v.Text, "\n")
+		if rs := []rune(text); len(rs) > 50 {
+			text = string(rs[:50]) + "..."
+		}
+		return text
+	case *bsky.FeedLike:
+		return "liked " + v.Subject.Uri
+	case *bsky.FeedRepost:
+		return "reposted " + v.Subject.Uri
+	case *bsky.GraphFollow:
+		return "followed " + v.Subject
+	case *bsky.GraphBlock:
+		return "blocked " + v.Subject
+	}
+	return ""
+}
+
+// hasDeleteFilter reports whether the records to delete are selected with
+// --before, --after or --match, or all of them with --all. The other options
+// only narrow or preview the selection.
+func hasDeleteFilter(cCtx *cli.Context) bool {
+	for _, name := range []string{"before", "after", "match", "all"} {
+		if cCtx.IsSet(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDeleteOption reports whether any option of the bulk delete is given.
+func hasDeleteOption(cCtx *cli.Context) bool {
+	for _, name := range []string{"collection", "no-replies", "dry-run"} {
+		if cCtx.IsSet(name) {
+			return true
+		}
+	}
+	return hasDeleteFilter(cCtx)
+}
+
+func doBulkDelete(cCtx *cli.Context) error {
+	var filter deleteFilter
+	var err error
+	if s := cCtx.String("before"); s != "" {
+		if filter.before, err = parseDate(s); err != nil {
+			return fmt.Errorf("invalid date: %q", s)
+		}
+	}
+	if s := cCtx.String("after"); s != "" {
+		if filter.after, err = parseDate(s); err != nil {
+			return fmt.Errorf("invalid date: %q", s)
+		}
+	}
+	if s := cCtx.String("match"); s != "" {
+		if filter.match, err = regexp.Compile(s); err != nil {
+			return err
+		}
+	}
+	filter.noReplies = cCtx.Bool("no-replies")
+	if filter.before.IsZero() && filter.after.IsZero() && filter.match == nil && !cCtx.Bool("all") {
+		return fmt.Errorf("specify --before, --after or --match, or --all to delete every record")
+	}
+
+	var collections []string
+	for _, s := range cCtx.StringSlice("collection") {
+		c, err := collectionName(s)
+		if err != nil {
+			return err
+		}
+		collections = append(collections, c)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	dryRun := cCtx.Bool("dry-run")
+
+	var writes []*comatproto.RepoApplyWrites_Input_Writes_Elem
+	for _, collection := range collections {
+		var cursor string
+		for {
+			resp, err := comatproto.RepoListRecords(context.TODO(), xrpcc, collection, cursor, 100, xrpcc.Auth.Did, false)
+			if err != nil {
+				return fmt.Errorf("cannot list records: %w", err)
+			}
+			for _, r := range resp.Records {
+				if r.Value == nil || !filter.matches(r.Value.Val) {
+					continue
+				}
+				parts := strings.Split(r.Uri, "/")
+				writes = append(writes, &comatproto.RepoApplyWrites_Input_Writes_Elem{
+					RepoApplyWrites_Delete: &comatproto.RepoApplyWrites_Delete{
+						Collection: collection,
+						Rkey:       parts[len(parts)-1],
+					},
+				})
+				fmt.Printf("%s %s\n", r.Uri, recordSummary(r.Value.Val))
+			}
+			if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Records) == 0 {
+				break
+			}
+			cursor = *resp.Cursor
+		}
+	}
+
+	if dryRun {
+		fmt.Fprintf(os.Stderr, "%d records would be deleted\n", len(writes))
+		return nil
+	}
+
+	for i := 0; i < len(writes); i += applyWritesLimit {
+		batch := writes[i:min(i+applyWritesLimit, len(writes))]
+		_, err := comatproto.RepoApplyWrites(context.TODO(), xrpcc, &comatproto.RepoApplyWrites_Input{
+			Repo:   xrpcc.Auth.Did,
+			Writes: batch,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot delete records (%d/%d deleted): %w", i, len(writes), err)
+		}
+		fmt.Fprintf(os.Stderr, "deleted %d/%d\n", i+len(batch), len(writes))
+	}
+	return nil
+}
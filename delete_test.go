@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCollectionName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{input: "post", want: "app.bsky.feed.post"},
+		{input: "follow", want: "app.bsky.graph.follow"},
+		{input: "app.bsky.feed.like", want: "app.bsky.feed.like"},
+		{input: "posts", err: true},
+	}
+	for _, test := range tests {
+		got, err := collectionName(test.input)
+		if test.err {
+			if err == nil {
+				t.Fatalf("%q should be an error", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Fatalf("want %q but got %q", test.want, got)
+		}
+	}
+}
+
+func TestDeleteFilter(t *testing.T) {
+	before, err := parseDate("2025-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano)
+	recent := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano)
+
+	filter := deleteFilter{before: before, match: regexp.MustCompile(`^test`), noReplies: true}
+	tests := []struct {
+		name string
+		rec  any
+		want bool
+	}{
+		{name: "old post", rec: &bsky.FeedPost{Text: "test post", CreatedAt: old}, want: true},
+		{name: "recent post", rec: &bsky.FeedPost{Text: "test post", CreatedAt: recent}, want: false},
+		{name: "not matched", rec: &bsky.FeedPost{Text: "hello", CreatedAt: old}, want: false},
+		{name: "reply", rec: &bsky.FeedPost{Text: "test reply", CreatedAt: old, Reply: &bsky.FeedPost_ReplyRef{}}, want: false},
+		{name: "like", rec: &bsky.FeedLike{CreatedAt: old, Subject: &comatproto.RepoStrongRef{}}, want: false},
+	}
+	for _, test := range tests {
+		if got := filter.matches(test.rec); got != test.want {
+			t.Fatalf("%s: want %v but got %v", test.name, test.want, got)
+		}
+	}
+
+	filter = deleteFilter{before: before}
+	if !filter.matches(&bsky.FeedLike{CreatedAt: old, Subject: &comatproto.RepoStrongRef{}}) {
+		t.Fatal("old like should match")
+	}
+	if filter.matches(&bsky.FeedLike{CreatedAt: "broken"}) {
+		t.Fatal("broken createdAt should not match")
+	}
+}
+
+func TestDeleteRequiresFilter(t *testing.T) {
+	tests := [][]string{
+		{"--dry-run"},
+		{"--collection", "like"},
+		{"--no-replies", "--dry-run"},
+	}
+	for _, args := range tests {
+		set := flag.NewFlagSet("delete", flag.ContinueOnError)
+		set.String("collection", "", "")
+		set.String("before", "", "")
+		set.String("after", "", "")
+		set.String("match", "", "")
+		set.Bool("no-replies", false, "")
+		set.Bool("all", false, "")
+		set.Bool("dry-run", false, "")
+		if err := set.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		err := doDelete(cli.NewContext(cli.NewApp(), set, nil))
+		if err == nil || !strings.Contains(err.Error(), "--all") {
+			t.Errorf("%v: want the error asking for --all, got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/fatih/color"
+	"github.com/rivo/uniseg"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// maxPostGraphemes and maxPostBytes are the limits of app.bsky.feed.post text.
+	maxPostGraphemes = 300
+	maxPostBytes     = 3000
+
+	scissors = "# ------------------------ >8 ------------------------"
+)
+
+// draft is a post which is not published yet. doPost uses it to carry the
+// post options, and drafts are stored as JSON files in the config directory.
+type draft struct {
+	Text      string   `json:"text"`
+	Reply     string   `json:"reply,omitempty"`
+	Quote     string   `json:"quote,omitempty"`
+	Images    []string `json:"images,omitempty"`
+	ImageAlts []string `json:"imageAlts,omitempty"`
+	Video     string   `json:"video,omitempty"`
+	VideoAlt  string   `json:"videoAlt,omitempty"`
+	UpdatedAt string   `json:"updatedAt,omitempty"`
+}
+
+// draftData is a draft in draft list, with the name to publish it by.
+type draftData struct {
+	Name string `json:"name"`
+	*draft
+}
+
+func countGraphemes(s string) int {
+	return uniseg.GraphemeClusterCount(s)
+}
+
+func validatePostText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("post is empty")
+	}
+	if n := countGraphemes(text); n > maxPostGraphemes {
+		return fmt.Errorf("post is too long: %d graphemes (max %d)", n, maxPostGraphemes)
+	}
+	if n := len(text); n > maxPostBytes {
+		return fmt.Errorf("post is too long: %d bytes (max %d)", n, maxPostBytes)
+	}
+	return nil
+}
+
+// draftTemplate returns the content of the file opened in the editor. The
+// text goes above the scissors line, and the context below it is ignored.
+func draftTemplate(text string, info []string, errMsg string) string {
+	var buf strings.Builder
+	buf.WriteString(text)
+	if !strings.HasSuffix(text, "\n") {
+		buf.WriteString("\n")
+	}
+	buf.WriteString(scissors + "\n")
+	buf.WriteString("# Do not modify or remove the line above.\n")
+	buf.WriteString("# Everything below it will be ignored. Leave the post empty to abort.\n")
+	buf.WriteString("#\n")
+	fmt.Fprintf(&buf, "# %d/%d graphemes\n", countGraphemes(strings.TrimSpace(text)), maxPostGraphemes)
+	if errMsg != "" {
+		fmt.Fprintf(&buf, "# error: %s\n", errMsg)
+	}
+	for _, line := range info {
+		buf.WriteString("#")
+		if line != "" {
+			buf.WriteString(" " + line)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func parseDraftTemplate(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if pos := strings.Index(s, scissors); pos >= 0 {
+		s = s[:pos]
+	}
+	return strings.TrimSpace(s)
+}
+
+func editorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if args := strings.Fields(os.Getenv(name)); len(args) > 0 {
+			return args
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+func runEditor(content string) (string, error) {
+	f, err := os.CreateTemp("", "bsky-post-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(content)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	args := editorCommand()
+	cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("cannot run editor: %w", err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// draftContext returns the lines describing the posts which d replies to or
+// quotes. xrpcc may be nil, then only the URIs are shown.
+func draftContext(xrpcc *xrpc.Client, d *draft) []string {
+	var lines []string
+	for _, v := range []struct {
+		label string
+		uri   string
+	}{
+		{"Replying to", d.Reply},
+		{"Quoting", d.Quote},
+	} {
+		if v.uri == "" {
+			continue
+		}
+		lines = append(lines, "")
+		var post *bsky.FeedDefs_PostView
+		if xrpcc != nil {
+			resp, err := bsky.FeedGetPosts(context.TODO(), xrpcc, []string{v.uri})
+			if err == nil && len(resp.Posts) > 0 {
+				post = resp.Posts[0]
+			}
+		}
+		if post == nil {
+			lines = append(lines, v.label+" "+v.uri)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s @%s [%s]:", v.label, post.Author.Handle, stringp(post.Author.DisplayName)))
+		if rec, ok := post.Record.Val.(*bsky.FeedPost); ok {
+			for _, line := range strings.Split(rec.Text, "\n") {
+				lines = append(lines, "  "+line)
+			}
+		}
+	}
+	return lines
+}
+
+// editDraft opens the text of d in the editor. When validate is true, the
+// editor is opened again until the text is valid for a post or empty.
+func editDraft(xrpcc *xrpc.Client, d *draft, validate bool) error {
+	info := draftContext(xrpcc, d)
+	var errMsg string
+	for {
+		s, err := runEditor(draftTemplate(d.Text, info, errMsg))
+		if err != nil {
+			return err
+		}
+		d.Text = parseDraftTemplate(s)
+		if !validate || d.Text == "" {
+			return nil
+		}
+		if err := validatePostText(d.Text); err != nil {
+			errMsg = err.Error()
+			continue
+		}
+		return nil
+	}
+}
+
+func draftDir(cCtx *cli.Context) string {
+	cfg := cCtx.App.Metadata["config"].(*config)
+	return filepath.Join(cfg.dir, cfg.prefix+"drafts")
+}
+
+// draftNow returns the time the drafts are saved at.
+var draftNow = time.Now
+
+// saveDraft writes d as the draft name. A new name is made from the time when
+// name is empty, like 20260101-150405, or 20260101-150405-1 when a draft of
+// the same second exists, so that a new draft never overwrites another.
+func saveDraft(cCtx *cli.Context, name string, d *draft) (string, error) {
+	dir := draftDir(cCtx)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("cannot create draft directory: %w", err)
+	}
+	now := draftNow()
+	d.UpdatedAt = now.Local().Format(time.RFC3339)
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if name != "" {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), b, 0600); err != nil {
+			return "", fmt.Errorf("cannot write draft: %w", err)
+		}
+		return name, nil
+	}
+
+	base := now.Format("20060102-150405")
+	name = base
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	f, err := os.OpenFile(filepath.Join(dir, name+".json"), flag, 0600)
+	for n := 1; errors.Is(err, fs.ErrExist); n++ {
+		name = base + "-" + strconv.Itoa(n)
+		f, err = os.OpenFile(filepath.Join(dir, name+".json"), flag, 0600)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot write draft: %w", err)
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot write draft: %w", err)
+	}
+	return name, nil
+}
+
+func loadDraft(cCtx *cli.Context, name string) (*draft, error) {
+	if name != filepath.Base(name) {
+		return nil, fmt.Errorf("invalid draft name: %q", name)
+	}
+	b, err := os.ReadFile(filepath.Join(draftDir(cCtx), name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load draft: %w", err)
+	}
+	var d draft
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, fmt.Errorf("cannot load draft: %w", err)
+	}
+	return &d, nil
+}
+
+func doDraftSave(cCtx *cli.Context) error {
+	stdin := cCtx.Bool("stdin")
+	edit := cCtx.Bool("e")
+	if !stdin && !edit && !cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	text := strings.Join(cCtx.Args().Slice(), " ")
+	if stdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		text = string(b)
+	}
+
+	d := &draft{
+		Text:      text,
+		Reply:     cCtx.String("r"),
+		Quote:     cCtx.String("q"),
+		Images:    cCtx.StringSlice("image"),
+		ImageAlts: cCtx.StringSlice("image-alt"),
+		Video:     cCtx.String("video"),
+		VideoAlt:  cCtx.String("video-alt"),
+	}
+
+	// the draft may be published from another directory
+	for i, fn := range d.Images {
+		if abs, err := filepath.Abs(fn); err == nil {
+			d.Images[i] = abs
+		}
+	}
+	if d.Video != "" {
+		if abs, err := filepath.Abs(d.Video); err == nil {
+			d.Video = abs
+		}
+	}
+
+	if edit {
+		var xrpcc *xrpc.Client
+		if d.Reply != "" || d.Quote != "" {
+			var err error
+			xrpcc, err = makeXRPCC(cCtx)
+			if err != nil {
+				return fmt.Errorf("cannot create client: %w", err)
+			}
+		}
+		if err := editDraft(xrpcc, d, false); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(d.Text) == "" {
+		return fmt.Errorf("empty draft, aborted")
+	}
+
+	name, err := saveDraft(cCtx, "", d)
+	if err != nil {
+		return err
+	}
+	fmt.Println(name)
+	return nil
+}
+
+func doDraftList(cCtx *cli.Context) error {
+	if cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	names, err := filepath.Glob(filepath.Join(draftDir(cCtx), "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range names {
+		name := strings.TrimSuffix(filepath.Base(fn), ".json")
+		d, err := loadDraft(cCtx, name)
+		if err != nil {
+			return err
+		}
+
+		if out != nil {
+			if err := out.write(&draftData{Name: name, draft: d}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		color.Set(color.FgHiRed)
+		fmt.Print(name)
+		color.Set(color.Reset)
+		fmt.Printf(" (%s) %d/%d\n", d.UpdatedAt, countGraphemes(d.Text), maxPostGraphemes)
+		text, _, _ := strings.Cut(d.Text, "\n")
+		if rs := []rune(text); len(rs) > 50 {
+			text = string(rs[:50]) + "..."
+		}
+		fmt.Printf(" %s\n", text)
+		if d.Reply != "" {
+			fmt.Print(" > ")
+			color.Set(color.FgBlue)
+			fmt.Println(d.Reply)
+			color.Set(color.Reset)
+		}
+		if d.Quote != "" {
+			fmt.Print(" \" ")
+			color.Set(color.FgBlue)
+			fmt.Println(d.Quote)
+			color.Set(color.Reset)
+		}
+	}
+	return out.close()
+}
+
+func doDraftEdit(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	name := cCtx.Args().First()
+	d, err := loadDraft(cCtx, name)
+	if err != nil {
+		return err
+	}
+
+	var xrpcc *xrpc.Client
+	if d.Reply != "" || d.Quote != "" {
+		xrpcc, err = makeXRPCC(cCtx)
+		if err != nil {
+			return fmt.Errorf("cannot create client: %w", err)
+		}
+	}
+	if err := editDraft(xrpcc, d, false); err != nil {
+		return err
+	}
+	if d.Text == "" {
+		return fmt.Errorf("empty draft, not saved")
+	}
+	_, err = saveDraft(cCtx, name, d)
+	return err
+}
+
+func doDraftPublish(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	name := cCtx.Args().First()
+	d, err := loadDraft(cCtx, name)
+	if err != nil {
+		return err
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	if cCtx.Bool("e") {
+		if err := editDraft(xrpcc, d, true); err != nil {
+			return err
+		}
+		if d.Text == "" {
+			return fmt.Errorf("empty post, aborted")
+		}
+		if _, err := saveDraft(cCtx, name, d); err != nil {
+			return err
+		}
+	}
+
+	resp, err := createPost(xrpcc, d)
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Uri)
+
+	return os.Remove(filepath.Join(draftDir(cCtx), name+".json"))
+}
+
+func doDraftDelete(cCtx *cli.Context) error {
+	if !cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	for _, name := range cCtx.Args().Slice() {
+		if name != filepath.Base(name) {
+			return fmt.Errorf("invalid draft name: %q", name)
+		}
+		if err := os.Remove(filepath.Join(draftDir(cCtx), name+".json")); err != nil {
+			return fmt.Errorf("cannot delete draft: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestCountGraphemes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{input: "hello", want: 5},
+		{input: "こんにちは", want: 5},
+		{input: "👍🏽", want: 1},
+		{input: "🇯🇵🇺🇸", want: 2},
+	}
+	for _, test := range tests {
+		got := countGraphemes(test.input)
+		if got != test.want {
+			t.Fatalf("want %d but got %d for %q", test.want, got, test.input)
+		}
+	}
+}
+
+func TestValidatePostText(t *testing.T) {
+	if err := validatePostText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePostText(" \n"); err == nil {
+		t.Fatal("empty text should be an error")
+	}
+	if err := validatePostText(strings.Repeat("👍🏽", maxPostGraphemes)); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePostText(strings.Repeat("a", maxPostGraphemes+1)); err == nil {
+		t.Fatal("too many graphemes should be an error")
+	}
+}
+
+func TestDraftTemplate(t *testing.T) {
+	s := draftTemplate("#bluesky\nhello", []string{"", "Replying to @mattn.jp:", "  hi"}, "post is empty")
+	if !strings.Contains(s, "# 14/300 graphemes\n") {
+		t.Fatalf("template should contain grapheme count: %q", s)
+	}
+	if !strings.Contains(s, "# error: post is empty\n") {
+		t.Fatalf("template should contain error: %q", s)
+	}
+	if !strings.Contains(s, "#\n# Replying to @mattn.jp:\n#   hi\n") {
+		t.Fatalf("template should contain context: %q", s)
+	}
+
+	got := parseDraftTemplate(strings.ReplaceAll(s, "\n", "\r\n"))
+	if got != "#bluesky\nhello" {
+		t.Fatalf("want %q but got %q", "#bluesky\nhello", got)
+	}
+}
+
+func TestDraftListFormat(t *testing.T) {
+	app := cli.NewApp()
+	app.Metadata = map[string]any{"config": &config{dir: t.TempDir()}}
+	set := flag.NewFlagSet("list", flag.ContinueOnError)
+	set.String("format", "csv", "")
+	cCtx := cli.NewContext(app, set, nil)
+
+	if _, err := saveDraft(cCtx, "first", &draft{Text: "hello, world"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saveDraft(cCtx, "second", &draft{Text: "bye", Reply: "at://did:plc:xxx/app.bsky.feed.post/1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := captureStdout(t, func() {
+		if err := doDraftList(cCtx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	rows, err := csv.NewReader(strings.NewReader(string(b))).ReadAll()
+	if err != nil {
+		t.Fatalf("%v: %q", err, b)
+	}
+	if len(rows) != 3 || rows[0][0] != "NAME" || rows[1][0] != "first" || rows[1][2] != "hello, world" || rows[2][3] != "at://did:plc:xxx/app.bsky.feed.post/1" {
+		t.Fatalf("unexpected rows: %q", rows)
+	}
+}
+
+func TestSaveDraftSameSecond(t *testing.T) {
+	now := time.Date(2026, 1, 1, 15, 4, 5, 0, time.Local)
+	draftNow = func() time.Time { return now }
+	defer func() { draftNow = time.Now }()
+
+	app := cli.NewApp()
+	app.Metadata = map[string]any{"config": &config{dir: t.TempDir()}}
+	cCtx := cli.NewContext(app, flag.NewFlagSet("save", flag.ContinueOnError), nil)
+
+	var names []string
+	for _, text := range []string{"first", "second", "third"} {
+		name, err := saveDraft(cCtx, "", &draft{Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	want := []string{"20260101-150405", "20260101-150405-1", "20260101-150405-2"}
+	for i, name := range names {
+		if name != want[i] {
+			t.Fatalf("want %v but got %v", want, names)
+		}
+		d, err := loadDraft(cCtx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Text != []string{"first", "second", "third"}[i] {
+			t.Fatalf("%s: the draft is overwritten with %q", name, d.Text)
+		}
+	}
+}
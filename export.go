@@ -0,0 +1,488 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// exportPost is a post, or a quoted post, in an exported document. Note is
+// set instead of the contents when the post cannot be shown.
+type exportPost struct {
+	Handle      string
+	DisplayName string
+	Uri         string
+	Text        string
+	Facets      []*bsky.RichtextFacet
+	CreatedAt   time.Time
+	Images      []*bsky.EmbedImages_ViewImage
+	Video       *bsky.EmbedVideo_View
+	External    *bsky.EmbedExternal_ViewExternal
+	Quote       *exportPost
+	Note        string
+}
+
+func newExportPost(n *threadNode) *exportPost {
+	switch {
+	case n.Blocked:
+		return &exportPost{Uri: n.Uri, Note: "[blocked post]"}
+	case n.Deleted:
+		return &exportPost{Uri: n.Uri, Note: "[deleted post]"}
+	case n.Post == nil:
+		return &exportPost{Uri: n.Uri, Note: "[post not found]"}
+	}
+
+	p := n.Post
+	e := &exportPost{
+		Handle:      p.Author.Handle,
+		DisplayName: stringp(p.Author.DisplayName),
+		Uri:         p.Uri,
+		CreatedAt:   threadNodeTime(n),
+	}
+	if rec, ok := p.Record.Val.(*bsky.FeedPost); ok {
+		e.Text = rec.Text
+		e.Facets = rec.Facets
+	}
+	if p.Embed != nil {
+		e.addEmbed(p.Embed.EmbedImages_View, p.Embed.EmbedVideo_View, p.Embed.EmbedExternal_View, p.Embed.EmbedRecord_View, p.Embed.EmbedRecordWithMedia_View)
+	}
+	return e
+}
+
+func newExportQuote(r *bsky.EmbedRecord_View) *exportPost {
+	if r == nil || r.Record == nil {
+		return nil
+	}
+	switch {
+	case r.Record.EmbedRecord_ViewBlocked != nil:
+		return &exportPost{Uri: r.Record.EmbedRecord_ViewBlocked.Uri, Note: "[blocked post]"}
+	case r.Record.EmbedRecord_ViewNotFound != nil:
+		return &exportPost{Uri: r.Record.EmbedRecord_ViewNotFound.Uri, Note: "[deleted post]"}
+	case r.Record.EmbedRecord_ViewDetached != nil:
+		return &exportPost{Uri: r.Record.EmbedRecord_ViewDetached.Uri, Note: "[detached post]"}
+	case r.Record.EmbedRecord_ViewRecord == nil:
+		return nil
+	}
+
+	v := r.Record.EmbedRecord_ViewRecord
+	e := &exportPost{
+		Handle:      v.Author.Handle,
+		DisplayName: stringp(v.Author.DisplayName),
+		Uri:         v.Uri,
+	}
+	if v.Value != nil {
+		if rec, ok := v.Value.Val.(*bsky.FeedPost); ok {
+			e.Text = rec.Text
+			e.Facets = rec.Facets
+			e.CreatedAt, _ = parseTime(rec.CreatedAt)
+		}
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt, _ = parseTime(v.IndexedAt)
+	}
+	for _, em := range v.Embeds {
+		e.addEmbed(em.EmbedImages_View, em.EmbedVideo_View, em.EmbedExternal_View, em.EmbedRecord_View, em.EmbedRecordWithMedia_View)
+	}
+	return e
+}
+
+func (e *exportPost) addEmbed(images *bsky.EmbedImages_View, video *bsky.EmbedVideo_View, external *bsky.EmbedExternal_View, record *bsky.EmbedRecord_View, recordWithMedia *bsky.EmbedRecordWithMedia_View) {
+	if images != nil {
+		e.Images = append(e.Images, images.Images...)
+	}
+	if video != nil {
+		e.Video = video
+	}
+	if external != nil {
+		e.External = external.External
+	}
+	if record != nil {
+		e.Quote = newExportQuote(record)
+	}
+	if recordWithMedia != nil {
+		if m := recordWithMedia.Media; m != nil {
+			e.addEmbed(m.EmbedImages_View, m.EmbedVideo_View, m.EmbedExternal_View, nil, nil)
+		}
+		e.Quote = newExportQuote(recordWithMedia.Record)
+	}
+}
+
+// textSegment is a part of a post text. URL is set when the part is a link,
+// a mention or a hashtag.
+type textSegment struct {
+	Text string
+	URL  string
+}
+
+// linkURL returns the URL to link to for u. Only http and https URLs are
+// linked, and at:// URIs are linked to bsky.app. Anyone can post a link
+// with any scheme, and javascript: or data: URLs would run in the document,
+// so ok is false for them, and they are shown as text.
+func linkURL(u string) (string, bool) {
+	// the DIDs of at:// URIs are not valid hosts for net/url
+	if strings.HasPrefix(u, "at://") {
+		if strings.ContainsAny(u, "\"'<> ") {
+			return "", false
+		}
+		return webURL(u, ""), true
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(pu.Scheme) {
+	case "http", "https":
+		return u, true
+	}
+	return "", false
+}
+
+func facetURL(f *bsky.RichtextFacet) string {
+	for _, feature := range f.Features {
+		switch {
+		case feature.RichtextFacet_Link != nil:
+			return feature.RichtextFacet_Link.Uri
+		case feature.RichtextFacet_Mention != nil:
+			return "https://bsky.app/profile/" + feature.RichtextFacet_Mention.Did
+		case feature.RichtextFacet_Tag != nil:
+			return "https://bsky.app/hashtag/" + url.PathEscape(feature.RichtextFacet_Tag.Tag)
+		}
+	}
+	return ""
+}
+
+// facetSegments splits text with the facets. Facets are byte ranges of the
+// UTF-8 text. Broken or overlapping facets are ignored.
+func facetSegments(text string, facets []*bsky.RichtextFacet) []textSegment {
+	sorted := make([]*bsky.RichtextFacet, 0, len(facets))
+	for _, f := range facets {
+		if f != nil && f.Index != nil {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Index.ByteStart < sorted[j].Index.ByteStart
+	})
+
+	var segs []textSegment
+	off := int64(0)
+	for _, f := range sorted {
+		start, end := f.Index.ByteStart, f.Index.ByteEnd
+		if start < off || end > int64(len(text)) || start >= end {
+			continue
+		}
+		link := facetURL(f)
+		if link == "" {
+			continue
+		}
+		if start > off {
+			segs = append(segs, textSegment{Text: text[off:start]})
+		}
+		segs = append(segs, textSegment{Text: text[start:end], URL: link})
+		off = end
+	}
+	if off < int64(len(text)) {
+		segs = append(segs, textSegment{Text: text[off:]})
+	}
+	return segs
+}
+
+// threadExporter writes a thread as a Markdown or HTML document. When
+// imageDir is not empty, images are downloaded into it and referred as
+// imageRef/name from the document.
+type threadExporter struct {
+	format   string
+	imageDir string
+	imageRef string
+	images   map[string]string
+}
+
+// image returns the source of the image in the document.
+func (x *threadExporter) image(src string) string {
+	if x.imageDir == "" {
+		return src
+	}
+	if s, ok := x.images[src]; ok {
+		return s
+	}
+	// https://cdn.bsky.app/img/feed_fullsize/plain/did/cid@jpeg
+	name := strings.Replace(path.Base(src), "@", ".", 1)
+	if err := downloadFile(src, filepath.Join(x.imageDir, name)); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot download image: %v\n", err)
+		x.images[src] = src
+		return src
+	}
+	x.images[src] = x.imageRef + "/" + name
+	return x.images[src]
+}
+
+// downloadClient downloads the images of the exports. The timeout keeps a
+// stalled image from hanging the export.
+var downloadClient = &http.Client{Timeout: 30 * time.Second}
+
+func downloadFile(src, fn string) error {
+	resp, err := downloadClient.Get(src)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", src, resp.Status)
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// threadTitle returns the title of the document for the thread.
+func threadTitle(root *threadNode) (string, string) {
+	n := root
+	for !n.Focus && len(n.Replies) == 1 {
+		n = n.Replies[0]
+	}
+	if n.Post == nil {
+		return "Thread", n.Uri
+	}
+	return "Thread by @" + n.Post.Author.Handle, webURL(n.Post.Uri, n.Post.Author.Handle)
+}
+
+// threadLevels calls fn for each post with the level of indentation. Like
+// printThread, parents are not indented.
+func threadLevels(root *threadNode, fn func(n *threadNode, level int)) {
+	n := root
+	for !n.Focus && len(n.Replies) == 1 {
+		fn(n, 0)
+		n = n.Replies[0]
+	}
+	var walk func(n *threadNode, level int)
+	walk = func(n *threadNode, level int) {
+		fn(n, level)
+		for _, r := range n.Replies {
+			walk(r, level+1)
+		}
+	}
+	walk(n, 0)
+}
+
+func (x *threadExporter) export(w io.Writer, root *threadNode) error {
+	var s string
+	if x.format == "html" {
+		s = x.html(root)
+	} else {
+		s = x.markdown(root)
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+// mdURLEscaper percent-encodes the characters which end the destination of
+// a Markdown link, so that a URL cannot break out of its link.
+var mdURLEscaper = strings.NewReplacer(
+	" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E",
+)
+
+func (x *threadExporter) markdown(root *threadNode) string {
+	var b strings.Builder
+	title, link := threadTitle(root)
+	fmt.Fprintf(&b, "# %s\n\n", mdEscaper.Replace(title))
+	fmt.Fprintf(&b, "Exported from <%s> at %s\n\n", link, time.Now().Format(time.RFC3339))
+	threadLevels(root, func(n *threadNode, level int) {
+		prefix := strings.Repeat("> ", level)
+		for _, line := range x.mdPost(newExportPost(n)) {
+			if line == "" {
+				b.WriteString(strings.TrimRight(prefix, " ") + "\n")
+			} else {
+				b.WriteString(prefix + line + "\n")
+			}
+		}
+		b.WriteString("\n")
+	})
+	return b.String()
+}
+
+func (x *threadExporter) mdPost(e *exportPost) []string {
+	if e.Note != "" {
+		return []string{"*" + mdEscaper.Replace(e.Note) + "* <" + e.Uri + ">"}
+	}
+
+	var lines []string
+	header := fmt.Sprintf("[@%s](https://bsky.app/profile/%s)", mdEscaper.Replace(e.Handle), e.Handle)
+	if e.DisplayName != "" {
+		header = "**" + mdEscaper.Replace(e.DisplayName) + "** " + header
+	}
+	lines = append(lines, header+fmt.Sprintf(" · [%s](%s)", e.CreatedAt.Format(time.RFC3339), mdURLEscaper.Replace(webURL(e.Uri, e.Handle))), "")
+
+	var text strings.Builder
+	for _, seg := range facetSegments(e.Text, e.Facets) {
+		if u, ok := linkURL(seg.URL); ok {
+			fmt.Fprintf(&text, "[%s](%s)", mdEscaper.Replace(seg.Text), mdURLEscaper.Replace(u))
+		} else {
+			text.WriteString(mdEscaper.Replace(seg.Text))
+		}
+	}
+	for _, line := range strings.Split(text.String(), "\n") {
+		// two spaces make a hard line break
+		lines = append(lines, line+"  ")
+	}
+
+	for _, i := range e.Images {
+		if _, ok := linkURL(i.Fullsize); !ok {
+			continue
+		}
+		lines = append(lines, "", fmt.Sprintf("![%s](%s)", mdEscaper.Replace(i.Alt), mdURLEscaper.Replace(x.image(i.Fullsize))))
+		if i.Alt != "" {
+			lines = append(lines, "", "*Alt: "+mdEscaper.Replace(i.Alt)+"*")
+		}
+	}
+	if e.Video != nil {
+		if u, ok := linkURL(e.Video.Playlist); ok {
+			lines = append(lines, "", fmt.Sprintf("[Video](%s)", mdURLEscaper.Replace(u)))
+		} else {
+			lines = append(lines, "", "Video "+mdEscaper.Replace(e.Video.Playlist))
+		}
+		if alt := stringp(e.Video.Alt); alt != "" {
+			lines = append(lines, "", "*Alt: "+mdEscaper.Replace(alt)+"*")
+		}
+	}
+	if e.External != nil {
+		if u, ok := linkURL(e.External.Uri); ok {
+			lines = append(lines, "", fmt.Sprintf("🔗 [%s](%s)", mdEscaper.Replace(e.External.Title), mdURLEscaper.Replace(u)))
+		} else {
+			lines = append(lines, "", "🔗 "+mdEscaper.Replace(e.External.Title)+" "+mdEscaper.Replace(e.External.Uri))
+		}
+	}
+	if e.Quote != nil {
+		lines = append(lines, "")
+		for _, line := range x.mdPost(e.Quote) {
+			lines = append(lines, "> "+line)
+		}
+	}
+	return lines
+}
+
+const exportStyle = `body { font-family: sans-serif; max-width: 48em; margin: 2em auto; padding: 0 1em; line-height: 1.5; }
+.post { border-left: 2px solid #ccc; padding: 0.2em 0 0.2em 0.8em; margin: 0.8em 0; }
+.focus { border-left-color: #0085ff; }
+.note { color: #888; font-style: italic; }
+.meta { color: #555; font-size: 0.9em; }
+.quote { border: 1px solid #ccc; border-radius: 6px; padding: 0.5em; margin: 0.5em 0; }
+figure { margin: 0.5em 0; }
+figure img { max-width: 100%; }
+figcaption { color: #555; font-size: 0.9em; }
+`
+
+func (x *threadExporter) html(root *threadNode) string {
+	var b strings.Builder
+	title, link := threadTitle(root)
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n<style>\n%s</style>\n</head>\n<body>\n", html.EscapeString(title), exportStyle)
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(title))
+	fmt.Fprintf(&b, "<p class=\"meta\">Exported from <a href=\"%s\">%s</a> at %s</p>\n",
+		html.EscapeString(link), html.EscapeString(link), time.Now().Format(time.RFC3339))
+
+	n := root
+	for !n.Focus && len(n.Replies) == 1 {
+		x.htmlNode(&b, n, false)
+		n = n.Replies[0]
+	}
+	x.htmlNode(&b, n, true)
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+func (x *threadExporter) htmlNode(b *strings.Builder, n *threadNode, replies bool) {
+	class := "post"
+	if n.Focus {
+		class += " focus"
+	}
+	fmt.Fprintf(b, "<article class=\"%s\">\n", class)
+	x.htmlPost(b, newExportPost(n))
+	if replies {
+		for _, r := range n.Replies {
+			x.htmlNode(b, r, true)
+		}
+	}
+	b.WriteString("</article>\n")
+}
+
+func (x *threadExporter) htmlPost(b *strings.Builder, e *exportPost) {
+	if e.Note != "" {
+		fmt.Fprintf(b, "<p class=\"note\">%s</p>\n", html.EscapeString(e.Note))
+		return
+	}
+
+	b.WriteString("<p class=\"meta\">")
+	if e.DisplayName != "" {
+		fmt.Fprintf(b, "<strong>%s</strong> ", html.EscapeString(e.DisplayName))
+	}
+	fmt.Fprintf(b, "<a href=\"https://bsky.app/profile/%s\">@%s</a> · <a href=\"%s\"><time datetime=\"%s\">%s</time></a></p>\n",
+		html.EscapeString(e.Handle), html.EscapeString(e.Handle),
+		html.EscapeString(webURL(e.Uri, e.Handle)),
+		e.CreatedAt.Format(time.RFC3339), e.CreatedAt.Format(time.RFC3339))
+
+	b.WriteString("<p>")
+	for _, seg := range facetSegments(e.Text, e.Facets) {
+		text := strings.ReplaceAll(html.EscapeString(seg.Text), "\n", "<br>\n")
+		if u, ok := linkURL(seg.URL); ok {
+			fmt.Fprintf(b, "<a href=\"%s\">%s</a>", html.EscapeString(u), text)
+		} else {
+			b.WriteString(text)
+		}
+	}
+	b.WriteString("</p>\n")
+
+	for _, i := range e.Images {
+		if _, ok := linkURL(i.Fullsize); !ok {
+			continue
+		}
+		fmt.Fprintf(b, "<figure><img src=\"%s\" alt=\"%s\">", html.EscapeString(x.image(i.Fullsize)), html.EscapeString(i.Alt))
+		if i.Alt != "" {
+			fmt.Fprintf(b, "<figcaption>%s</figcaption>", html.EscapeString(i.Alt))
+		}
+		b.WriteString("</figure>\n")
+	}
+	if e.Video != nil {
+		if u, ok := linkURL(e.Video.Playlist); ok {
+			fmt.Fprintf(b, "<p><a href=\"%s\">Video</a>", html.EscapeString(u))
+		} else {
+			fmt.Fprintf(b, "<p>Video %s", html.EscapeString(e.Video.Playlist))
+		}
+		if alt := stringp(e.Video.Alt); alt != "" {
+			fmt.Fprintf(b, " <span class=\"meta\">%s</span>", html.EscapeString(alt))
+		}
+		b.WriteString("</p>\n")
+	}
+	if e.External != nil {
+		if u, ok := linkURL(e.External.Uri); ok {
+			fmt.Fprintf(b, "<p>🔗 <a href=\"%s\">%s</a></p>\n", html.EscapeString(u), html.EscapeString(e.External.Title))
+		} else {
+			fmt.Fprintf(b, "<p>🔗 %s %s</p>\n", html.EscapeString(e.External.Title), html.EscapeString(e.External.Uri))
+		}
+	}
+	if e.Quote != nil {
+		b.WriteString("<blockquote class=\"quote\">\n")
+		x.htmlPost(b, e.Quote)
+		b.WriteString("</blockquote>\n")
+	}
+}
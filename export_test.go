@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+func TestFacetSegments(t *testing.T) {
+	text := "こんにちは @mattn.jp see https://example.com"
+	facets := []*bsky.RichtextFacet{
+		{
+			Index:    &bsky.RichtextFacet_ByteSlice{ByteStart: int64(strings.Index(text, "https")), ByteEnd: int64(len(text))},
+			Features: []*bsky.RichtextFacet_Features_Elem{{RichtextFacet_Link: &bsky.RichtextFacet_Link{Uri: "https://example.com"}}},
+		},
+		{
+			Index:    &bsky.RichtextFacet_ByteSlice{ByteStart: int64(strings.Index(text, "@")), ByteEnd: int64(strings.Index(text, " see"))},
+			Features: []*bsky.RichtextFacet_Features_Elem{{RichtextFacet_Mention: &bsky.RichtextFacet_Mention{Did: "did:plc:xxx"}}},
+		},
+		{
+			// broken facet
+			Index:    &bsky.RichtextFacet_ByteSlice{ByteStart: 3, ByteEnd: 1000},
+			Features: []*bsky.RichtextFacet_Features_Elem{{RichtextFacet_Tag: &bsky.RichtextFacet_Tag{Tag: "x"}}},
+		},
+	}
+	want := []textSegment{
+		{Text: "こんにちは "},
+		{Text: "@mattn.jp", URL: "https://bsky.app/profile/did:plc:xxx"},
+		{Text: " see "},
+		{Text: "https://example.com", URL: "https://example.com"},
+	}
+	got := facetSegments(text, facets)
+	if len(got) != len(want) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v but got %v", want[i], got[i])
+		}
+	}
+}
+
+func TestThreadExporter(t *testing.T) {
+	root := &threadNode{
+		Uri: "at://did:plc:xxx/app.bsky.feed.post/1",
+		Post: &bsky.FeedDefs_PostView{
+			Uri:    "at://did:plc:xxx/app.bsky.feed.post/1",
+			Author: &bsky.ActorDefs_ProfileViewBasic{Handle: "mattn.jp"},
+			Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "<b>*hi*</b>", CreatedAt: "2025-01-01T00:00:00Z"}},
+			Embed: &bsky.FeedDefs_PostView_Embed{EmbedImages_View: &bsky.EmbedImages_View{
+				Images: []*bsky.EmbedImages_ViewImage{{Alt: "a pizza", Fullsize: "https://cdn.bsky.app/img/x@jpeg"}},
+			}},
+		},
+		Focus:   true,
+		Replies: []*threadNode{{Uri: "at://did:plc:yyy/app.bsky.feed.post/2", Blocked: true}},
+	}
+
+	x := &threadExporter{format: "md"}
+	md := x.markdown(root)
+	for _, s := range []string{
+		"# Thread by @mattn.jp\n",
+		"[@mattn.jp](https://bsky.app/profile/mattn.jp) · [2025-01-01T00:00:00Z](https://bsky.app/profile/mattn.jp/post/1)",
+		`\<b\>\*hi\*\</b\>`,
+		"![a pizza](https://cdn.bsky.app/img/x@jpeg)",
+		"> *\\[blocked post\\]*",
+	} {
+		if !strings.Contains(md, s) {
+			t.Fatalf("markdown should contain %q:\n%s", s, md)
+		}
+	}
+
+	x = &threadExporter{format: "html"}
+	h := x.html(root)
+	for _, s := range []string{
+		"&lt;b&gt;*hi*&lt;/b&gt;",
+		`<img src="https://cdn.bsky.app/img/x@jpeg" alt="a pizza"><figcaption>a pizza</figcaption>`,
+		`<p class="note">[blocked post]</p>`,
+	} {
+		if !strings.Contains(h, s) {
+			t.Fatalf("html should contain %q:\n%s", s, h)
+		}
+	}
+}
+
+func TestThreadExporterUnsafeLinks(t *testing.T) {
+	text := "click me"
+	root := &threadNode{
+		Uri: "at://did:plc:xxx/app.bsky.feed.post/1",
+		Post: &bsky.FeedDefs_PostView{
+			Uri:    "at://did:plc:xxx/app.bsky.feed.post/1",
+			Author: &bsky.ActorDefs_ProfileViewBasic{Handle: "mattn.jp"},
+			Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+				Text:      text,
+				CreatedAt: "2025-01-01T00:00:00Z",
+				Facets: []*bsky.RichtextFacet{{
+					Index:    &bsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: int64(len(text))},
+					Features: []*bsky.RichtextFacet_Features_Elem{{RichtextFacet_Link: &bsky.RichtextFacet_Link{Uri: "javascript:alert(1)"}}},
+				}},
+			}},
+			Embed: &bsky.FeedDefs_PostView_Embed{EmbedExternal_View: &bsky.EmbedExternal_View{
+				External: &bsky.EmbedExternal_ViewExternal{Uri: "data:text/html,<script>alert(1)</script>", Title: "card"},
+			}},
+		},
+		Focus: true,
+	}
+
+	x := &threadExporter{format: "html"}
+	h := x.html(root)
+	for _, s := range []string{`href="javascript:`, `href="data:`, "<script>"} {
+		if strings.Contains(h, s) {
+			t.Fatalf("html should not contain %q:\n%s", s, h)
+		}
+	}
+	for _, s := range []string{
+		"<p>click me</p>",
+		"<p>🔗 card data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+	} {
+		if !strings.Contains(h, s) {
+			t.Fatalf("html should contain %q:\n%s", s, h)
+		}
+	}
+
+	x = &threadExporter{format: "md"}
+	if md := x.markdown(root); strings.Contains(md, "](javascript:") {
+		t.Fatalf("markdown should not link javascript:\n%s", md)
+	}
+}
+
+func TestLinkURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{in: "https://example.com/a?b=c", want: "https://example.com/a?b=c", ok: true},
+		{in: "HTTP://example.com", want: "HTTP://example.com", ok: true},
+		{in: "at://did:plc:xxx/app.bsky.feed.post/1", want: "https://bsky.app/profile/did:plc:xxx/post/1", ok: true},
+		{in: "javascript:alert(1)"},
+		{in: "JavaScript:alert(1)"},
+		{in: "data:text/html,x"},
+		{in: "//example.com"},
+	}
+	for _, tt := range tests {
+		got, ok := linkURL(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("linkURL(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestThreadExporterMarkdownURL(t *testing.T) {
+	text := "click me"
+	root := &threadNode{
+		Uri: "at://did:plc:xxx/app.bsky.feed.post/1",
+		Post: &bsky.FeedDefs_PostView{
+			Uri:    "at://did:plc:xxx/app.bsky.feed.post/1",
+			Author: &bsky.ActorDefs_ProfileViewBasic{Handle: "mattn.jp"},
+			Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+				Text:      text,
+				CreatedAt: "2025-01-01T00:00:00Z",
+				Facets: []*bsky.RichtextFacet{{
+					Index:    &bsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: int64(len(text))},
+					Features: []*bsky.RichtextFacet_Features_Elem{{RichtextFacet_Link: &bsky.RichtextFacet_Link{Uri: "https://example.com/a) [x](https://evil.example"}}},
+				}},
+			}},
+		},
+		Focus: true,
+	}
+	x := &threadExporter{format: "md"}
+	md := x.markdown(root)
+	if want := "[click me](https://example.com/a%29%20[x]%28https://evil.example)"; !strings.Contains(md, want) {
+		t.Fatalf("markdown should contain %q:\n%s", want, md)
+	}
+}
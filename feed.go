@@ -0,0 +1,662 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// maxFeedPages is the maximum number of pages fetched to fill a filtered feed.
+const maxFeedPages = 50
+
+// feedPage fetches a page of a feed starting at cursor.
+type feedPage func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error)
+
+type feedFilter struct {
+	noReposts bool
+	noReplies bool
+	onlyMedia bool
+	langs     []string
+	authors   []string
+	grep      *regexp.Regexp
+}
+
+func newFeedFilter(cCtx *cli.Context) (*feedFilter, error) {
+	filter := &feedFilter{
+		noReposts: cCtx.Bool("no-reposts"),
+		noReplies: cCtx.Bool("no-replies"),
+		onlyMedia: cCtx.Bool("only-media"),
+		langs:     cCtx.StringSlice("lang"),
+		authors:   cCtx.StringSlice("author"),
+	}
+	if s := cCtx.String("grep"); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		filter.grep = re
+	}
+	return filter, nil
+}
+
+func hasMedia(embed *bsky.FeedDefs_PostView_Embed) bool {
+	if embed == nil {
+		return false
+	}
+	if embed.EmbedImages_View != nil || embed.EmbedVideo_View != nil || embed.EmbedGallery_View != nil {
+		return true
+	}
+	if m := embed.EmbedRecordWithMedia_View; m != nil && m.Media != nil {
+		return m.Media.EmbedImages_View != nil || m.Media.EmbedVideo_View != nil || m.Media.EmbedGallery_View != nil
+	}
+	return false
+}
+
+func (f *feedFilter) matches(p *bsky.FeedDefs_FeedViewPost) bool {
+	rec, ok := p.Post.Record.Val.(*bsky.FeedPost)
+	if !ok {
+		return false
+	}
+	if f.noReposts && p.Reason != nil && p.Reason.FeedDefs_ReasonRepost != nil {
+		return false
+	}
+	if f.noReplies && rec.Reply != nil {
+		return false
+	}
+	if f.onlyMedia && !hasMedia(p.Post.Embed) {
+		return false
+	}
+	if len(f.langs) > 0 {
+		found := false
+		for _, want := range f.langs {
+			for _, lang := range rec.Langs {
+				if strings.EqualFold(lang, want) || strings.HasPrefix(strings.ToLower(lang), strings.ToLower(want)+"-") {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.authors) > 0 {
+		found := false
+		for _, author := range f.authors {
+			author = strings.TrimPrefix(author, "@")
+			if author == p.Post.Author.Handle || author == p.Post.Author.Did {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.grep != nil && !f.grep.MatchString(rec.Text) {
+		return false
+	}
+	return true
+}
+
+// collectFeed pages through a feed until more than n posts accepted by filter
+// are found. Posts which appear twice are dropped.
+func collectFeed(n int64, filter *feedFilter, fetch feedPage) ([]*bsky.FeedDefs_FeedViewPost, error) {
+	var feed []*bsky.FeedDefs_FeedViewPost
+	seen := map[string]bool{}
+
+	var cursor string
+	for range maxFeedPages {
+		items, next, err := fetch(cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range items {
+			if seen[p.Post.Uri] {
+				continue
+			}
+			seen[p.Post.Uri] = true
+			if filter != nil && !filter.matches(p) {
+				continue
+			}
+			feed = append(feed, p)
+		}
+		if next == nil || *next == "" || int64(len(feed)) > n {
+			break
+		}
+		cursor = *next
+	}
+	return feed, nil
+}
+
+// collectNewFeed fetches the posts newer than marker. It pages only until
+// it reaches the marker, and returns the new marker with the posts. When
+// marker is nil, it works like collectFeed. Pinned posts are not new, so they
+// are skipped. The posts are compared by the time they were indexed, not by
+// createdAt which the authors can set freely. Feeds which are not
+// chronological, like custom feeds, are read until the post of the marker,
+// which is the top post of the last read. The post of the marker may be
+// deleted or dropped from the feed, so the posts indexed before the marker
+// are not new either, and the paging stops at a page without new posts.
+func collectNewFeed(n int64, filter *feedFilter, marker *feedMarker, chronological bool, fetch feedPage) ([]*bsky.FeedDefs_FeedViewPost, *feedMarker, error) {
+	var markerTime time.Time
+	if marker != nil {
+		markerTime, _ = parseTime(marker.IndexedAt)
+	}
+
+	var feed []*bsky.FeedDefs_FeedViewPost
+	var newest *bsky.FeedDefs_FeedViewPost
+	var newestTime time.Time
+	seen := map[string]bool{}
+
+	var cursor string
+	for range maxFeedPages {
+		items, next, err := fetch(cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		reached, found := false, false
+		for _, p := range items {
+			if isPinned(p) {
+				continue
+			}
+			t := feedTime(p)
+			if marker != nil && (p.Post.Uri == marker.Uri || (chronological && !t.After(markerTime))) {
+				reached = true
+				break
+			}
+			if newest == nil || (chronological && t.After(newestTime)) {
+				newest, newestTime = p, t
+			}
+			if marker != nil && !t.After(markerTime) {
+				continue
+			}
+			found = true
+			if seen[p.Post.Uri] {
+				continue
+			}
+			seen[p.Post.Uri] = true
+			if filter != nil && !filter.matches(p) {
+				continue
+			}
+			feed = append(feed, p)
+		}
+		if reached || (marker != nil && !found) || next == nil || *next == "" || (marker == nil && int64(len(feed)) > n) {
+			break
+		}
+		cursor = *next
+	}
+
+	if newest == nil {
+		return feed, marker, nil
+	}
+	return feed, &feedMarker{
+		Uri:       newest.Post.Uri,
+		IndexedAt: newestTime.UTC().Format(time.RFC3339Nano),
+	}, nil
+}
+
+// feedWatcher prints the posts which are not shown yet in a feed. When key
+// is not empty, the newest post shown is remembered in the state file.
+type feedWatcher struct {
+	cCtx   *cli.Context
+	key    string
+	filter *feedFilter
+	fetch  feedPage
+	marker *feedMarker
+	polled bool
+
+	// chronological is false for the feeds not sorted by time.
+	chronological bool
+
+	// print handles the new posts. It is printFeed by default.
+	print func(feed []*bsky.FeedDefs_FeedViewPost) error
+}
+
+// newFeedWatcher returns the watcher printing the posts to out. out is
+// shared by all the polls, so that it is closed once at the end.
+func newFeedWatcher(cCtx *cli.Context, out *output, key string, filter *feedFilter, chronological bool, fetch feedPage) (*feedWatcher, error) {
+	w := &feedWatcher{cCtx: cCtx, key: key, filter: filter, fetch: fetch, chronological: chronological}
+	w.print = func(feed []*bsky.FeedDefs_FeedViewPost) error {
+		return printFeed(cCtx, out, feed)
+	}
+	if key != "" {
+		marker, err := loadFeedMarker(cCtx, key)
+		if err != nil {
+			return nil, err
+		}
+		w.marker = marker
+	}
+	return w, nil
+}
+
+// poll prints the new posts oldest first. At the first poll, all unseen
+// posts are printed when there is a marker unless -n is given. Otherwise,
+// the newest n posts are printed. After that, all new posts are printed.
+func (w *feedWatcher) poll() (int, error) {
+	n := w.cCtx.Int64("n")
+	feed, marker, err := collectNewFeed(n, w.filter, w.marker, w.chronological, w.fetch)
+	if err != nil {
+		return 0, err
+	}
+	if w.polled || (w.marker != nil && !w.cCtx.IsSet("n")) {
+		n = int64(len(feed))
+	}
+	w.polled = true
+	feed = sortFeed(feed, n)
+	if err := w.print(feed); err != nil {
+		return 0, err
+	}
+
+	if marker == nil || marker == w.marker {
+		return len(feed), nil
+	}
+	w.marker = marker
+	if w.key != "" {
+		if err := saveFeedMarker(w.cCtx, w.key, marker); err != nil {
+			return len(feed), err
+		}
+	}
+	return len(feed), nil
+}
+
+// showFeed prints the feed as specified with --new and -f.
+func showFeed(cCtx *cli.Context, xrpcc *xrpc.Client, key string, filter *feedFilter, chronological bool, fetch feedPage) error {
+	if !cCtx.Bool("new") {
+		key = ""
+	}
+	newOut := newOutput
+	if cCtx.Bool("f") {
+		newOut = newFollowOutput
+	}
+	out, err := newOut(cCtx)
+	if err != nil {
+		return err
+	}
+	w, err := newFeedWatcher(cCtx, out, key, filter, chronological, fetch)
+	if err != nil {
+		return err
+	}
+	if cCtx.Bool("f") {
+		err = follow(cCtx, xrpcc, w.poll)
+	} else {
+		_, err = w.poll()
+	}
+	if err != nil {
+		return err
+	}
+	return out.close()
+}
+
+// feedTime returns the time when p appeared in the feed, which is when the
+// post or the repost was indexed.
+func feedTime(p *bsky.FeedDefs_FeedViewPost) time.Time {
+	if p.Reason != nil && p.Reason.FeedDefs_ReasonRepost != nil {
+		if t, err := parseTime(p.Reason.FeedDefs_ReasonRepost.IndexedAt); err == nil {
+			return t
+		}
+	}
+	t, _ := parseTime(p.Post.IndexedAt)
+	return t
+}
+
+func isPinned(p *bsky.FeedDefs_FeedViewPost) bool {
+	return p.Reason != nil && p.Reason.FeedDefs_ReasonPin != nil
+}
+
+// sortFeed sorts feed oldest first and keeps the newest n posts. Pinned
+// posts are kept at the top.
+func sortFeed(feed []*bsky.FeedDefs_FeedViewPost, n int64) []*bsky.FeedDefs_FeedViewPost {
+	var pinned, posts []*bsky.FeedDefs_FeedViewPost
+	for _, p := range feed {
+		if isPinned(p) {
+			pinned = append(pinned, p)
+		} else {
+			posts = append(posts, p)
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return feedTime(posts[i]).Before(feedTime(posts[j]))
+	})
+	if int64(len(posts)) > n {
+		posts = posts[len(posts)-int(n):]
+	}
+	return append(pinned, posts...)
+}
+
+// printFeed prints feed to out, or as usual when out is nil. The caller
+// closes out.
+func printFeed(cCtx *cli.Context, out *output, feed []*bsky.FeedDefs_FeedViewPost) error {
+	if out != nil {
+		for _, p := range feed {
+			if err := out.writeAs(p, newFeedPostData(p)); err != nil {
+				return err
+			}
+		}
+		return out.flush()
+	}
+	r := newRenderer(cCtx)
+	for _, p := range feed {
+		printFeedPost(r, p)
+	}
+	return nil
+}
+
+// printFeedOnce prints feed with the output of the command.
+func printFeedOnce(cCtx *cli.Context, feed []*bsky.FeedDefs_FeedViewPost) error {
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+	if err := printFeed(cCtx, out, feed); err != nil {
+		return err
+	}
+	return out.close()
+}
+
+// printFeedPost prints p with the reason why it is in the feed.
+func printFeedPost(r *renderer, p *bsky.FeedDefs_FeedViewPost) {
+	if p.Reason != nil && p.Reason.FeedDefs_ReasonRepost != nil && p.Reason.FeedDefs_ReasonRepost.By != nil {
+		by := p.Reason.FeedDefs_ReasonRepost.By
+		r.println("⚡ reposted by " + r.user(by.Handle, by.DisplayName))
+	}
+	if isPinned(p) {
+		r.println("📌 pinned")
+	}
+	if p.Reply != nil && p.Reply.Parent != nil {
+		switch {
+		case p.Reply.Parent.FeedDefs_PostView != nil:
+			author := p.Reply.Parent.FeedDefs_PostView.Author
+			r.println("↩️ reply to " + r.user(author.Handle, author.DisplayName))
+		case p.Reply.Parent.FeedDefs_BlockedPost != nil:
+			r.println("↩️ reply to [blocked post]")
+		default:
+			r.println("↩️ reply to [deleted post]")
+		}
+	}
+	r.println(r.post(p.Post, r.width)...)
+	r.println("")
+}
+
+// savedFeed is a feed or a list saved in the preferences.
+type savedFeed struct {
+	Type   string `json:"type"`
+	Uri    string `json:"uri"`
+	Name   string `json:"name"`
+	Pinned bool   `json:"pinned"`
+}
+
+func getSavedFeeds(xrpcc *xrpc.Client) ([]*savedFeed, error) {
+	prefs, err := bsky.ActorGetPreferences(context.TODO(), xrpcc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get preferences: %w", err)
+	}
+
+	var feeds []*savedFeed
+	for _, p := range prefs.Preferences {
+		if p.ActorDefs_SavedFeedsPrefV2 != nil {
+			feeds = feeds[:0]
+			for _, item := range p.ActorDefs_SavedFeedsPrefV2.Items {
+				if item.Type == "timeline" {
+					continue
+				}
+				feeds = append(feeds, &savedFeed{Type: item.Type, Uri: item.Value, Pinned: item.Pinned})
+			}
+			break
+		}
+		if p.ActorDefs_SavedFeedsPref != nil {
+			pinned := map[string]bool{}
+			for _, uri := range p.ActorDefs_SavedFeedsPref.Pinned {
+				pinned[uri] = true
+			}
+			for _, uri := range p.ActorDefs_SavedFeedsPref.Saved {
+				typ := "feed"
+				if strings.Contains(uri, "/app.bsky.graph.list/") {
+					typ = "list"
+				}
+				feeds = append(feeds, &savedFeed{Type: typ, Uri: uri, Pinned: pinned[uri]})
+			}
+		}
+	}
+
+	var uris []string
+	for _, f := range feeds {
+		if f.Type == "feed" {
+			uris = append(uris, f.Uri)
+		}
+	}
+	if len(uris) > 0 {
+		resp, err := bsky.FeedGetFeedGenerators(context.TODO(), xrpcc, uris)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get feed generators: %w", err)
+		}
+		names := map[string]string{}
+		for _, g := range resp.Feeds {
+			names[g.Uri] = g.DisplayName
+		}
+		for _, f := range feeds {
+			if f.Type == "feed" {
+				f.Name = names[f.Uri]
+			}
+		}
+	}
+	for _, f := range feeds {
+		if f.Type == "list" {
+			if resp, err := bsky.GraphGetList(context.TODO(), xrpcc, "", 1, f.Uri); err == nil {
+				f.Name = resp.List.Name
+			}
+		}
+	}
+	return feeds, nil
+}
+
+// parseFeedURL converts a URL of a feed or a list on bsky.app to the actor,
+// the collection and the record key.
+func parseFeedURL(s string) (string, string, string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host != "bsky.app" {
+		return "", "", "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "profile" {
+		return "", "", "", false
+	}
+	switch parts[2] {
+	case "feed":
+		return parts[1], "app.bsky.feed.generator", parts[3], true
+	case "lists":
+		return parts[1], "app.bsky.graph.list", parts[3], true
+	}
+	return "", "", "", false
+}
+
+// resolveFeed returns the type ("feed" or "list") and the AT URI of arg, which
+// is an AT URI, a URL on bsky.app, or a name of a saved feed.
+func resolveFeed(xrpcc *xrpc.Client, arg string) (string, string, error) {
+	if actor, collection, rkey, ok := parseFeedURL(arg); ok {
+		if !strings.HasPrefix(actor, "did:") {
+			resp, err := comatproto.IdentityResolveHandle(context.TODO(), xrpcc, actor)
+			if err != nil {
+				return "", "", fmt.Errorf("cannot resolve handle: %w", err)
+			}
+			actor = resp.Did
+		}
+		arg = "at://" + actor + "/" + collection + "/" + rkey
+	}
+	if strings.HasPrefix(arg, "at://") {
+		switch {
+		case strings.Contains(arg, "/app.bsky.feed.generator/"):
+			return "feed", arg, nil
+		case strings.Contains(arg, "/app.bsky.graph.list/"):
+			return "list", arg, nil
+		}
+		return "", "", fmt.Errorf("not a feed or a list: %q", arg)
+	}
+
+	feeds, err := getSavedFeeds(xrpcc)
+	if err != nil {
+		return "", "", err
+	}
+	for _, f := range feeds {
+		if strings.EqualFold(f.Name, arg) || strings.HasSuffix(f.Uri, "/"+arg) {
+			return f.Type, f.Uri, nil
+		}
+	}
+	return "", "", fmt.Errorf("feed not found: %q", arg)
+}
+
+func doFeed(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	typ, uri, err := resolveFeed(xrpcc, cCtx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	n := cCtx.Int64("n")
+	fetch := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		if typ == "list" {
+			resp, err := bsky.FeedGetListFeed(context.TODO(), xrpcc, cursor, n, uri)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot get list feed: %w", err)
+			}
+			return resp.Feed, resp.Cursor, nil
+		}
+		resp, err := bsky.FeedGetFeed(context.TODO(), xrpcc, cursor, uri, n)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get feed: %w", err)
+		}
+		return resp.Feed, resp.Cursor, nil
+	}
+
+	if cCtx.Bool("new") || cCtx.Bool("f") {
+		return showFeed(cCtx, xrpcc, typ+":"+uri, nil, typ == "list", fetch)
+	}
+
+	feed, err := collectFeed(n, nil, fetch)
+	if err != nil {
+		return err
+	}
+
+	// custom feeds have their own order, so keep the first n posts as it is.
+	if int64(len(feed)) > n {
+		feed = feed[:n]
+	}
+	return printFeedOnce(cCtx, feed)
+}
+
+func doFeeds(cCtx *cli.Context) error {
+	if cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
+	feeds, err := getSavedFeeds(xrpcc)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		for _, f := range feeds {
+			if err := out.write(f); err != nil {
+				return err
+			}
+		}
+		return out.close()
+	}
+
+	for _, f := range feeds {
+		if f.Pinned {
+			fmt.Print("📌 ")
+		}
+		color.Set(color.FgHiRed)
+		fmt.Print(f.Name)
+		color.Set(color.Reset)
+		fmt.Printf(" (%s)\n", f.Type)
+		fmt.Print(" - ")
+		color.Set(color.FgBlue)
+		fmt.Println(f.Uri)
+		color.Set(color.Reset)
+	}
+	return nil
+}
+
+func doFeedDescribe(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	typ, uri, err := resolveFeed(xrpcc, cCtx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if typ == "list" {
+		resp, err := bsky.GraphGetList(context.TODO(), xrpcc, "", 1, uri)
+		if err != nil {
+			return fmt.Errorf("cannot get list: %w", err)
+		}
+		if cCtx.Bool("json") {
+			json.NewEncoder(os.Stdout).Encode(resp.List)
+			return nil
+		}
+		l := resp.List
+		fmt.Printf("Uri: %s\n", l.Uri)
+		fmt.Printf("Name: %s\n", l.Name)
+		fmt.Printf("Purpose: %s\n", stringp(l.Purpose))
+		fmt.Printf("Creator: %s\n", l.Creator.Handle)
+		fmt.Printf("Description: %s\n", stringp(l.Description))
+		fmt.Printf("Items: %d\n", int64p(l.ListItemCount))
+		return nil
+	}
+
+	resp, err := bsky.FeedGetFeedGenerator(context.TODO(), xrpcc, uri)
+	if err != nil {
+		return fmt.Errorf("cannot get feed generator: %w", err)
+	}
+	if cCtx.Bool("json") {
+		json.NewEncoder(os.Stdout).Encode(resp)
+		return nil
+	}
+	g := resp.View
+	fmt.Printf("Uri: %s\n", g.Uri)
+	fmt.Printf("DisplayName: %s\n", g.DisplayName)
+	fmt.Printf("Creator: %s\n", g.Creator.Handle)
+	fmt.Printf("Description: %s\n", stringp(g.Description))
+	fmt.Printf("Did: %s\n", g.Did)
+	fmt.Printf("Likes: %d\n", int64p(g.LikeCount))
+	fmt.Printf("Online: %v\n", resp.IsOnline)
+	fmt.Printf("Valid: %v\n", resp.IsValid)
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+func testFeedPost(uri, handle, text, createdAt string) *bsky.FeedDefs_FeedViewPost {
+	return &bsky.FeedDefs_FeedViewPost{
+		Post: &bsky.FeedDefs_PostView{
+			Uri:       uri,
+			Author:    &bsky.ActorDefs_ProfileViewBasic{Did: "did:plc:" + handle, Handle: handle},
+			IndexedAt: createdAt,
+			Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+				Text:      text,
+				CreatedAt: createdAt,
+				Langs:     []string{"ja"},
+			}},
+		},
+	}
+}
+
+func TestFeedFilter(t *testing.T) {
+	post := testFeedPost("at://1", "mattn.jp", "hello", "2025-01-01T00:00:00Z")
+	repost := testFeedPost("at://2", "example.com", "world", "2025-01-01T00:00:00Z")
+	repost.Reason = &bsky.FeedDefs_FeedViewPost_Reason{
+		FeedDefs_ReasonRepost: &bsky.FeedDefs_ReasonRepost{IndexedAt: "2025-01-02T00:00:00Z"},
+	}
+	reply := testFeedPost("at://3", "mattn.jp", "hi", "2025-01-01T00:00:00Z")
+	reply.Post.Record.Val.(*bsky.FeedPost).Reply = &bsky.FeedPost_ReplyRef{}
+
+	tests := []struct {
+		name   string
+		filter feedFilter
+		want   []bool
+	}{
+		{name: "none", filter: feedFilter{}, want: []bool{true, true, true}},
+		{name: "no-reposts", filter: feedFilter{noReposts: true}, want: []bool{true, false, true}},
+		{name: "no-replies", filter: feedFilter{noReplies: true}, want: []bool{true, true, false}},
+		{name: "only-media", filter: feedFilter{onlyMedia: true}, want: []bool{false, false, false}},
+		{name: "lang", filter: feedFilter{langs: []string{"en"}}, want: []bool{false, false, false}},
+		{name: "author", filter: feedFilter{authors: []string{"@mattn.jp"}}, want: []bool{true, false, true}},
+		{name: "grep", filter: feedFilter{grep: regexp.MustCompile(`^h`)}, want: []bool{true, false, true}},
+	}
+	for _, test := range tests {
+		for i, p := range []*bsky.FeedDefs_FeedViewPost{post, repost, reply} {
+			if got := test.filter.matches(p); got != test.want[i] {
+				t.Fatalf("%s: want %v but got %v for %s", test.name, test.want[i], got, p.Post.Uri)
+			}
+		}
+	}
+}
+
+func TestCollectFeed(t *testing.T) {
+	pages := [][]*bsky.FeedDefs_FeedViewPost{
+		{
+			testFeedPost("at://3", "mattn.jp", "3", "2025-01-03T00:00:00Z"),
+			testFeedPost("at://2", "mattn.jp", "2", "2025-01-02T00:00:00Z"),
+		},
+		{
+			testFeedPost("at://2", "mattn.jp", "2", "2025-01-02T00:00:00Z"),
+			testFeedPost("at://1", "mattn.jp", "1", "2025-01-01T00:00:00Z"),
+		},
+	}
+	feed, err := collectFeed(10, nil, func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		if cursor == "" {
+			next := "next"
+			return pages[0], &next, nil
+		}
+		return pages[1], nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed = sortFeed(feed, 2)
+	if len(feed) != 2 {
+		t.Fatalf("want 2 posts but got %d", len(feed))
+	}
+	if feed[0].Post.Uri != "at://2" || feed[1].Post.Uri != "at://3" {
+		t.Fatalf("want oldest first but got %s, %s", feed[0].Post.Uri, feed[1].Post.Uri)
+	}
+}
+
+func TestCollectNewFeed(t *testing.T) {
+	pages := [][]*bsky.FeedDefs_FeedViewPost{
+		{
+			testFeedPost("at://4", "mattn.jp", "4", "2025-01-04T00:00:00Z"),
+			testFeedPost("at://3", "mattn.jp", "3", "2025-01-03T00:00:00Z"),
+		},
+		{
+			testFeedPost("at://2", "mattn.jp", "2", "2025-01-02T00:00:00Z"),
+			testFeedPost("at://1", "mattn.jp", "1", "2025-01-01T00:00:00Z"),
+		},
+	}
+	fetched := 0
+	fetch := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		fetched++
+		if cursor == "" {
+			next := "next"
+			return pages[0], &next, nil
+		}
+		return pages[1], nil, nil
+	}
+
+	marker := &feedMarker{Uri: "at://3", IndexedAt: "2025-01-03T00:00:00Z"}
+	feed, marker, err := collectNewFeed(30, nil, marker, true, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 1 {
+		t.Fatalf("want 1 page fetched but got %d", fetched)
+	}
+	if len(feed) != 1 || feed[0].Post.Uri != "at://4" {
+		t.Fatalf("want only at://4 but got %d posts", len(feed))
+	}
+	if marker.Uri != "at://4" || marker.IndexedAt != "2025-01-04T00:00:00Z" {
+		t.Fatalf("unexpected marker: %+v", marker)
+	}
+
+	feed, marker, err = collectNewFeed(30, nil, marker, true, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed) != 0 || marker.Uri != "at://4" {
+		t.Fatalf("want no posts and the same marker but got %d posts, %+v", len(feed), marker)
+	}
+}
+
+func TestCollectNewFeedCreatedAt(t *testing.T) {
+	// createdAt is set by the authors, and only indexedAt orders the feed
+	future := testFeedPost("at://3", "mattn.jp", "3", "2025-01-03T00:00:00Z")
+	future.Post.Record.Val.(*bsky.FeedPost).CreatedAt = "2099-01-01T00:00:00Z"
+	backdated := testFeedPost("at://5", "mattn.jp", "5", "2025-01-05T00:00:00Z")
+	backdated.Post.Record.Val.(*bsky.FeedPost).CreatedAt = "2000-01-01T00:00:00Z"
+
+	pages := [][]*bsky.FeedDefs_FeedViewPost{
+		{future, testFeedPost("at://2", "mattn.jp", "2", "2025-01-02T00:00:00Z")},
+		{backdated, testFeedPost("at://4", "mattn.jp", "4", "2025-01-04T00:00:00Z"), future},
+	}
+	page := 0
+	fetch := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		return pages[page], nil, nil
+	}
+
+	marker := &feedMarker{Uri: "at://2", IndexedAt: "2025-01-02T00:00:00Z"}
+	feed, marker, err := collectNewFeed(30, nil, marker, true, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed) != 1 || feed[0].Post.Uri != "at://3" {
+		t.Fatalf("want only at://3 but got %d posts", len(feed))
+	}
+	if marker.Uri != "at://3" || marker.IndexedAt != "2025-01-03T00:00:00Z" {
+		t.Fatalf("the marker should be at the time indexed: %+v", marker)
+	}
+
+	page = 1
+	feed, marker, err = collectNewFeed(30, nil, marker, true, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed) != 2 || feed[0].Post.Uri != "at://5" || feed[1].Post.Uri != "at://4" {
+		t.Fatalf("want the backdated post and the next one but got %d posts", len(feed))
+	}
+	if marker.Uri != "at://5" {
+		t.Fatalf("unexpected marker: %+v", marker)
+	}
+}
+
+func TestCollectNewFeedNotChronological(t *testing.T) {
+	feed := []*bsky.FeedDefs_FeedViewPost{
+		testFeedPost("at://5", "mattn.jp", "5", "2025-01-05T00:00:00Z"),
+		testFeedPost("at://1", "mattn.jp", "1", "2025-01-01T00:00:00Z"),
+		testFeedPost("at://4", "mattn.jp", "4", "2025-01-04T00:00:00Z"),
+		testFeedPost("at://3", "mattn.jp", "3", "2025-01-03T00:00:00Z"),
+	}
+	fetch := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		return feed, nil, nil
+	}
+	marker := &feedMarker{Uri: "at://3", IndexedAt: "2025-01-03T00:00:00Z"}
+	got, marker, err := collectNewFeed(30, nil, marker, false, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// at://1 is above the marker, but indexed before it
+	if len(got) != 2 || got[0].Post.Uri != "at://5" || got[1].Post.Uri != "at://4" {
+		t.Fatalf("want the posts above the marker indexed after it but got %d posts", len(got))
+	}
+	if marker.Uri != "at://5" {
+		t.Fatalf("want the top post as the marker: %+v", marker)
+	}
+}
+
+func TestCollectNewFeedMarkerMissing(t *testing.T) {
+	pages := [][]*bsky.FeedDefs_FeedViewPost{
+		{
+			testFeedPost("at://6", "mattn.jp", "6", "2025-01-06T00:00:00Z"),
+			testFeedPost("at://2", "mattn.jp", "2", "2025-01-02T00:00:00Z"),
+		},
+		{
+			testFeedPost("at://5", "mattn.jp", "5", "2025-01-05T00:00:00Z"),
+		},
+		{
+			testFeedPost("at://1", "mattn.jp", "1", "2025-01-01T00:00:00Z"),
+			testFeedPost("at://0", "mattn.jp", "0", "2024-12-31T00:00:00Z"),
+		},
+	}
+	fetched := 0
+	fetch := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		// the feed never ends
+		next := "next"
+		fetched++
+		return pages[min(fetched, len(pages))-1], &next, nil
+	}
+
+	// the post of the marker is not in the feed any more
+	marker := &feedMarker{Uri: "at://3", IndexedAt: "2025-01-03T00:00:00Z"}
+	got, marker, err := collectNewFeed(30, nil, marker, false, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 3 {
+		t.Fatalf("want the paging stopped at the page without new posts but fetched %d pages", fetched)
+	}
+	if len(got) != 2 || got[0].Post.Uri != "at://6" || got[1].Post.Uri != "at://5" {
+		t.Fatalf("want at://6 and at://5 but got %d posts", len(got))
+	}
+	if marker.Uri != "at://6" {
+		t.Fatalf("want the top post as the marker: %+v", marker)
+	}
+}
+
+func TestSortFeedPinned(t *testing.T) {
+	pinned := testFeedPost("at://1", "mattn.jp", "1", "2025-01-01T00:00:00Z")
+	pinned.Reason = &bsky.FeedDefs_FeedViewPost_Reason{FeedDefs_ReasonPin: &bsky.FeedDefs_ReasonPin{}}
+	feed := sortFeed([]*bsky.FeedDefs_FeedViewPost{
+		pinned,
+		testFeedPost("at://3", "mattn.jp", "3", "2025-01-03T00:00:00Z"),
+		testFeedPost("at://2", "mattn.jp", "2", "2025-01-02T00:00:00Z"),
+	}, 1)
+	if len(feed) != 2 {
+		t.Fatalf("want 2 posts but got %d", len(feed))
+	}
+	if feed[0].Post.Uri != "at://1" || feed[1].Post.Uri != "at://3" {
+		t.Fatalf("want pinned post first but got %s, %s", feed[0].Post.Uri, feed[1].Post.Uri)
+	}
+}
+
+func TestParseFeedURL(t *testing.T) {
+	tests := []struct {
+		input      string
+		actor      string
+		collection string
+		rkey       string
+		ok         bool
+	}{
+		{input: "https://bsky.app/profile/bsky.app/feed/whats-hot", actor: "bsky.app", collection: "app.bsky.feed.generator", rkey: "whats-hot", ok: true},
+		{input: "https://bsky.app/profile/did:plc:xxx/lists/yyy", actor: "did:plc:xxx", collection: "app.bsky.graph.list", rkey: "yyy", ok: true},
+		{input: "https://bsky.app/profile/bsky.app/post/zzz"},
+		{input: "https://example.com/profile/bsky.app/feed/whats-hot"},
+		{input: "whats-hot"},
+	}
+	for _, test := range tests {
+		actor, collection, rkey, ok := parseFeedURL(test.input)
+		if ok != test.ok || actor != test.actor || collection != test.collection || rkey != test.rkey {
+			t.Fatalf("unexpected result for %q: %q %q %q %v", test.input, actor, collection, rkey, ok)
+		}
+	}
+}
+
+// captureStdout returns what f writes to stdout, including the output of
+// the renderers.
+func captureStdout(t *testing.T, f func()) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, output := os.Stdout, color.Output
+	os.Stdout, color.Output = w, w
+	defer func() { os.Stdout, color.Output = stdout, output }()
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func TestFeedWatcherOutput(t *testing.T) {
+	set := flag.NewFlagSet("timeline", flag.ContinueOnError)
+	set.String("format", "json", "")
+	set.Int64("n", 30, "")
+	cCtx := cli.NewContext(cli.NewApp(), set, nil)
+
+	feed := []*bsky.FeedDefs_FeedViewPost{
+		testFeedPost("at://1", "mattn.jp", "1", "2025-01-01T00:00:00Z"),
+	}
+	fetch := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		return feed, nil, nil
+	}
+
+	b := captureStdout(t, func() {
+		out, err := newOutput(cCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := newFeedWatcher(cCtx, out, "", nil, true, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.poll(); err != nil {
+			t.Fatal(err)
+		}
+		feed = append([]*bsky.FeedDefs_FeedViewPost{
+			testFeedPost("at://2", "mattn.jp", "2", "2025-01-02T00:00:00Z"),
+		}, feed...)
+		if _, err := w.poll(); err != nil {
+			t.Fatal(err)
+		}
+		if err := out.close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// the posts of all the polls are in an array
+	var posts []*bsky.FeedDefs_FeedViewPost
+	if err := json.Unmarshal(b, &posts); err != nil {
+		t.Fatalf("want a JSON array: %v\n%s", err, b)
+	}
+	if len(posts) != 2 || posts[0].Post.Uri != "at://1" || posts[1].Post.Uri != "at://2" {
+		t.Fatalf("want at://1 and at://2 but got %s", b)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	minFollowInterval = 10 * time.Second
+	maxFollowInterval = 2 * time.Minute
+	maxErrorInterval  = 10 * time.Minute
+)
+
+// poller decides how long to wait until the next poll. It polls often while
+// new items come, and slows down while nothing happens.
+type poller struct {
+	interval time.Duration
+}
+
+// next returns the interval after a poll which found n new items.
+func (p *poller) next(n int) time.Duration {
+	if n > 0 || p.interval < minFollowInterval {
+		p.interval = minFollowInterval
+	} else {
+		p.interval = min(p.interval*3/2, maxFollowInterval)
+	}
+	return p.interval
+}
+
+// backoff returns the interval after a failed poll. When the server tells
+// when the rate limit is reset, it waits until then.
+func (p *poller) backoff(err error) time.Duration {
+	var xrpcErr *xrpc.Error
+	if errors.As(err, &xrpcErr) && xrpcErr.IsThrottled() && xrpcErr.Ratelimit != nil {
+		if d := time.Until(xrpcErr.Ratelimit.Reset); d > 0 {
+			p.interval = min(d+time.Second, maxErrorInterval)
+			return p.interval
+		}
+	}
+	p.interval = min(max(p.interval*2, minFollowInterval), maxErrorInterval)
+	return p.interval
+}
+
+func isExpiredToken(err error) bool {
+	var xrpcErr *xrpc.Error
+	if !errors.As(err, &xrpcErr) {
+		return false
+	}
+	var xe *xrpc.XRPCError
+	return errors.As(xrpcErr.Wrapped, &xe) && xe.ErrStr == "ExpiredToken"
+}
+
+// follow calls poll until interrupted, like tail -f. poll returns the
+// number of new items it printed.
+func follow(cCtx *cli.Context, xrpcc *xrpc.Client, poll func() (int, error)) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var p poller
+	for {
+		n, err := poll()
+		if err != nil && isExpiredToken(err) {
+			if err = refreshSession(cCtx, xrpcc); err == nil {
+				n, err = poll()
+			}
+		}
+
+		var d time.Duration
+		if err != nil {
+			d = p.backoff(err)
+			fmt.Fprintf(os.Stderr, "%v (retrying in %v)\n", err, d.Round(time.Second))
+		} else {
+			d = p.next(n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(d):
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestPoller(t *testing.T) {
+	var p poller
+	if got := p.next(0); got != minFollowInterval {
+		t.Fatalf("want %v but got %v", minFollowInterval, got)
+	}
+	for range 20 {
+		p.next(0)
+	}
+	if got := p.next(0); got != maxFollowInterval {
+		t.Fatalf("want %v but got %v", maxFollowInterval, got)
+	}
+	if got := p.next(1); got != minFollowInterval {
+		t.Fatalf("want %v after new items but got %v", minFollowInterval, got)
+	}
+
+	if got := p.backoff(fmt.Errorf("network error")); got != 2*minFollowInterval {
+		t.Fatalf("want %v but got %v", 2*minFollowInterval, got)
+	}
+	throttled := &xrpc.Error{
+		StatusCode: 429,
+		Ratelimit:  &xrpc.RatelimitInfo{Reset: time.Now().Add(time.Minute)},
+	}
+	if got := p.backoff(throttled); got < 50*time.Second || got > 62*time.Second {
+		t.Fatalf("want about a minute but got %v", got)
+	}
+}
+
+func TestIsExpiredToken(t *testing.T) {
+	err := fmt.Errorf("cannot get timeline: %w", &xrpc.Error{
+		StatusCode: 400,
+		Wrapped:    &xrpc.XRPCError{ErrStr: "ExpiredToken", Message: "Token has expired"},
+	})
+	if !isExpiredToken(err) {
+		t.Fatal("should be ExpiredToken")
+	}
+	if isExpiredToken(fmt.Errorf("other")) {
+		t.Fatal("should not be ExpiredToken")
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// maxImageCols and maxImageRows are the maximum size of an image
+	// preview in cells.
+	maxImageCols = 40
+	maxImageRows = 16
+
+	// sixelCellWidth and sixelCellHeight are the size of a cell in pixels
+	// assumed for sixel, which cannot scale images to cells.
+	sixelCellWidth  = 8
+	sixelCellHeight = 16
+)
+
+// imageProtocols are the protocols to show images in the terminal.
+var imageProtocols = []string{"kitty", "iterm", "sixel", "blocks"}
+
+// detectImageProtocol returns the protocol which the terminal supports.
+// $BSKY_IMAGE_PROTOCOL overrides it for terminals which cannot be detected.
+func detectImageProtocol() string {
+	if p := os.Getenv("BSKY_IMAGE_PROTOCOL"); p != "" {
+		for _, v := range imageProtocols {
+			if p == v {
+				return p
+			}
+		}
+	}
+	termName := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || termName == "xterm-kitty" || termProgram == "ghostty":
+		return "kitty"
+	case termProgram == "iTerm.app" || termProgram == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return "iterm"
+	case strings.Contains(termName, "sixel") || strings.HasPrefix(termName, "foot") || termName == "mlterm" ||
+		termProgram == "contour" || os.Getenv("WT_SESSION") != "":
+		return "sixel"
+	}
+	return "blocks"
+}
+
+// imagePreviewer renders thumbnails of images in the terminal. Thumbnails are
+// cached in dir.
+type imagePreviewer struct {
+	protocol string
+	dir      string
+}
+
+func newImagePreviewer() *imagePreviewer {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return &imagePreviewer{
+		protocol: detectImageProtocol(),
+		dir:      filepath.Join(dir, "bsky", "images"),
+	}
+}
+
+// fetch returns the image of src from the cache, or downloads it.
+func (p *imagePreviewer) fetch(src string) (image.Image, []byte, error) {
+	sum := sha256.Sum256([]byte(src))
+	fn := filepath.Join(p.dir, hex.EncodeToString(sum[:]))
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		if err := os.MkdirAll(p.dir, 0700); err != nil {
+			return nil, nil, err
+		}
+		tmp := fn + ".tmp"
+		if err := downloadFile(src, tmp); err != nil {
+			os.Remove(tmp)
+			return nil, nil, err
+		}
+		if err := os.Rename(tmp, fn); err != nil {
+			return nil, nil, err
+		}
+		if b, err = os.ReadFile(fn); err != nil {
+			return nil, nil, err
+		}
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, b, nil
+}
+
+// imageCells returns the size in cells to show an image of size in width.
+// A cell is assumed twice as tall as wide.
+func imageCells(size image.Point, width int) (int, int) {
+	if size.X <= 0 || size.Y <= 0 {
+		return 0, 0
+	}
+	cols := max(min(width, maxImageCols), 1)
+	rows := max((cols*size.Y+size.X)/(2*size.X), 1)
+	if rows > maxImageRows {
+		rows = maxImageRows
+		cols = max(rows*2*size.X/size.Y, 1)
+	}
+	return cols, rows
+}
+
+func scaleImage(img image.Image, w, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// lines renders the image of src in width. Nothing is rendered when the
+// image cannot be fetched.
+func (p *imagePreviewer) lines(src string, width int) []string {
+	if src == "" {
+		return nil
+	}
+	img, b, err := p.fetch(src)
+	if err != nil {
+		return nil
+	}
+	cols, rows := imageCells(img.Bounds().Size(), width)
+	if cols == 0 {
+		return nil
+	}
+
+	var seq string
+	switch p.protocol {
+	case "kitty":
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaleImage(img, cols*sixelCellWidth, rows*sixelCellHeight)); err != nil {
+			return nil
+		}
+		seq = kittyImage(buf.Bytes(), cols, rows)
+	case "iterm":
+		seq = fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:%s\a",
+			len(b), cols, rows, base64.StdEncoding.EncodeToString(b))
+	case "sixel":
+		size := img.Bounds().Size()
+		w := cols * sixelCellWidth
+		h := min(w*size.Y/size.X, rows*sixelCellHeight)
+		seq = sixelImage(scaleImage(img, w, max(h, 1)))
+	default:
+		return blockImage(scaleImage(img, cols, rows*2))
+	}
+
+	// The image is drawn after the cells for it are printed, so that the
+	// terminal does not scroll while drawing it. The cursor goes back to the
+	// top left of the cells and is restored to the end of them.
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = strings.Repeat(" ", cols)
+	}
+	move := fmt.Sprintf("\x1b[%dD", cols)
+	if rows > 1 {
+		move = fmt.Sprintf("\x1b[%dA", rows-1) + move
+	}
+	lines[rows-1] += "\x1b7" + move + seq + "\x1b8"
+	return lines
+}
+
+// kittyImage returns the escape sequences of the kitty graphics protocol to
+// show a PNG image in cols x rows cells.
+func kittyImage(b []byte, cols, rows int) string {
+	const chunk = 4096
+	data := base64.StdEncoding.EncodeToString(b)
+	var sb strings.Builder
+	for i := 0; i < len(data); i += chunk {
+		end := min(i+chunk, len(data))
+		more := 0
+		if end < len(data) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(&sb, "\x1b_Ga=T,f=100,q=2,C=1,c=%d,r=%d,m=%d;%s\x1b\\", cols, rows, more, data[i:end])
+		} else {
+			fmt.Fprintf(&sb, "\x1b_Gm=%d;%s\x1b\\", more, data[i:end])
+		}
+	}
+	return sb.String()
+}
+
+// sixelImage encodes img as sixel with the 6x6x6 color cube.
+func sixelImage(img image.Image) string {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	idx := make([]int, w*h)
+	var used [216]bool
+	for y := range h {
+		for x := range w {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			if a < 0x8000 {
+				idx[y*w+x] = -1
+				continue
+			}
+			c := int((r*5+0x7fff)/0xffff)*36 + int((g*5+0x7fff)/0xffff)*6 + int((b*5+0x7fff)/0xffff)
+			idx[y*w+x] = c
+			used[c] = true
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\x1bP0;1;0q\"1;1;%d;%d", w, h)
+	for c := range used {
+		if used[c] {
+			fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", c, c/36*20, c/6%6*20, c%6*20)
+		}
+	}
+	for y0 := 0; y0 < h; y0 += 6 {
+		var band [216]bool
+		for y := y0; y < min(y0+6, h); y++ {
+			for _, c := range idx[y*w : (y+1)*w] {
+				if c >= 0 {
+					band[c] = true
+				}
+			}
+		}
+		for c := range band {
+			if !band[c] {
+				continue
+			}
+			fmt.Fprintf(&sb, "#%d", c)
+			var last byte
+			n := 0
+			for x := range w {
+				bits := 0
+				for dy := 0; dy < 6 && y0+dy < h; dy++ {
+					if idx[(y0+dy)*w+x] == c {
+						bits |= 1 << dy
+					}
+				}
+				ch := byte(63 + bits)
+				if ch != last && n > 0 {
+					writeSixelRun(&sb, last, n)
+					n = 0
+				}
+				last = ch
+				n++
+			}
+			writeSixelRun(&sb, last, n)
+			sb.WriteByte('$')
+		}
+		sb.WriteByte('-')
+	}
+	sb.WriteString("\x1b\\")
+	return sb.String()
+}
+
+func writeSixelRun(sb *strings.Builder, ch byte, n int) {
+	if n > 3 {
+		fmt.Fprintf(sb, "!%d%c", n, ch)
+		return
+	}
+	for range n {
+		sb.WriteByte(ch)
+	}
+}
+
+// blockImage renders img with half blocks in 24-bit colors. A cell shows two
+// pixels, the upper one as the foreground and the lower one as the
+// background.
+func blockImage(img image.Image) []string {
+	bounds := img.Bounds()
+	var lines []string
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		var sb strings.Builder
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := img.At(x, y).RGBA()
+			r2, g2, b2, _ := img.At(x, y+1).RGBA()
+			if y+1 >= bounds.Max.Y {
+				r2, g2, b2 = r1, g1, b1
+			}
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", r1>>8, g1>>8, b1>>8, r2>>8, g2>>8, b2>>8)
+		}
+		sb.WriteString("\x1b[0m")
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDetectImageProtocol(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"TERM": "xterm-kitty"}, "kitty"},
+		{map[string]string{"TERM_PROGRAM": "iTerm.app"}, "iterm"},
+		{map[string]string{"TERM": "foot"}, "sixel"},
+		{map[string]string{"TERM": "xterm-256color"}, "blocks"},
+		{map[string]string{"TERM": "xterm-256color", "BSKY_IMAGE_PROTOCOL": "sixel"}, "sixel"},
+		{map[string]string{"TERM": "xterm-kitty", "BSKY_IMAGE_PROTOCOL": "unknown"}, "kitty"},
+	}
+	for _, test := range tests {
+		for _, name := range []string{"TERM", "TERM_PROGRAM", "LC_TERMINAL", "KITTY_WINDOW_ID", "WT_SESSION", "BSKY_IMAGE_PROTOCOL"} {
+			t.Setenv(name, test.env[name])
+		}
+		if got := detectImageProtocol(); got != test.want {
+			t.Errorf("want %q but got %q for %v", test.want, got, test.env)
+		}
+	}
+}
+
+func TestImageCells(t *testing.T) {
+	tests := []struct {
+		size       image.Point
+		width      int
+		cols, rows int
+	}{
+		{image.Pt(400, 200), 80, 40, 10},
+		{image.Pt(400, 200), 20, 20, 5},
+		{image.Pt(200, 800), 80, 8, 16},
+		{image.Pt(0, 0), 80, 0, 0},
+	}
+	for _, test := range tests {
+		cols, rows := imageCells(test.size, test.width)
+		if cols != test.cols || rows != test.rows {
+			t.Errorf("want %dx%d but got %dx%d for %v in %d", test.cols, test.rows, cols, rows, test.size, test.width)
+		}
+	}
+}
+
+func TestSixelImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 7))
+	for y := range 7 {
+		for x := range 8 {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	got := sixelImage(img)
+	want := "\x1bP0;1;0q\"1;1;8;7#180;2;100;0;0#180!8~$-#180!8@$-\x1b\\"
+	if got != want {
+		t.Fatalf("want %q but got %q", want, got)
+	}
+}
+
+func TestImagePreviewer(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	p := &imagePreviewer{protocol: "blocks", dir: t.TempDir()}
+	lines := p.lines(ts.URL, 10)
+	if len(lines) != 3 {
+		t.Fatalf("want 3 lines but got %d", len(lines))
+	}
+	for _, l := range lines {
+		if w := visibleWidth(l); w != 10 {
+			t.Fatalf("want width 10 but got %d", w)
+		}
+	}
+
+	p.protocol = "kitty"
+	lines = p.lines(ts.URL, 10)
+	if len(lines) != 3 {
+		t.Fatalf("want 3 lines but got %d", len(lines))
+	}
+	if !strings.Contains(lines[2], "\x1b_Ga=T,f=100") {
+		t.Fatalf("want kitty graphics but got %q", lines[2])
+	}
+	for _, l := range lines {
+		if w := visibleWidth(l); w != 10 {
+			t.Fatalf("want width 10 but got %d", w)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("want 1 request with the cache but got %d", requests)
+	}
+}
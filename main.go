@@ -33,6 +33,9 @@ func main() {
 		Flags: []cli.Flag{
 			&cli.StringFlag{Name: "a", Usage: "profile name"},
 			&cli.BoolFlag{Name: "V", Usage: "verbose"},
+			&cli.StringFlag{Name: "template", Usage: "format each item of listings with Go template"},
+			&cli.StringFlag{Name: "format", Usage: "output format of listings (table, tsv, csv, jsonl, json)"},
+			&cli.BoolFlag{Name: "images", Usage: "show images inline in the terminal"},
 		},
 		DisableSliceFlagSeparator: true,
 		Commands: []*cli.Command{
@@ -78,31 +81,113 @@ func main() {
 					&cli.StringFlag{Name: "handle", Aliases: []string{"H"}, Value: "", Usage: "user handle"},
 					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
 					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.BoolFlag{Name: "no-reposts", Usage: "hide reposts"},
+					&cli.BoolFlag{Name: "no-replies", Usage: "hide replies"},
+					&cli.BoolFlag{Name: "only-media", Usage: "show only posts with images or videos"},
+					&cli.StringSliceFlag{Name: "lang", Usage: "show only posts in the language"},
+					&cli.StringSliceFlag{Name: "author", Usage: "show only posts by the handle or DID"},
+					&cli.StringFlag{Name: "grep", Usage: "show only posts matching the regexp"},
+					&cli.StringFlag{Name: "filter", Usage: "author feed filter (posts_with_replies, posts_no_replies, posts_with_media, posts_and_author_threads, posts_with_video)"},
+					&cli.BoolFlag{Name: "pins", Usage: "include pinned post of the author"},
+					&cli.BoolFlag{Name: "new", Usage: "show only posts not shown yet (all of them unless -n is given)"},
+					&cli.BoolFlag{Name: "f", Usage: "follow new posts"},
 				},
 				Action: doTimeline,
 			},
+			{
+				Name:        "feed",
+				Description: "Show custom feed or list feed",
+				Usage:       "Show custom feed or list feed",
+				UsageText:   "bsky feed [at-uri|url|name]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.BoolFlag{Name: "new", Usage: "show only posts not shown yet (all of them unless -n is given)"},
+					&cli.BoolFlag{Name: "f", Usage: "follow new posts"},
+				},
+				Action: doFeed,
+				Subcommands: []*cli.Command{
+					{
+						Name:        "describe",
+						Description: "Show feed generator",
+						Usage:       "Show feed generator",
+						UsageText:   "bsky feed describe [at-uri|url|name]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+						},
+						Action: doFeedDescribe,
+					},
+				},
+			},
+			{
+				Name:        "feeds",
+				Description: "Show saved feeds",
+				Usage:       "Show saved feeds",
+				UsageText:   "bsky feeds",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				},
+				Action: doFeeds,
+			},
 			{
 				Name:        "stream",
 				Description: "Show timeline as stream",
 				Usage:       "Show timeline as stream",
-				UsageText:   "bsky stream",
+				UsageText:   "bsky stream [host]",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "cursor", Value: "", Usage: "cursor"},
 					&cli.StringFlag{Name: "handle", Aliases: []string{"H"}, Value: "", Usage: "user handle"},
-					&cli.StringFlag{Name: "pattern", Usage: "pattern"},
+					&cli.StringFlag{Name: "pattern", Usage: "only posts matching the regexp"},
 					&cli.StringFlag{Name: "reply", Usage: "reply"},
 					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.BoolFlag{Name: "jetstream", Usage: "consume Jetstream instead of the firehose"},
+					&cli.StringSliceFlag{Name: "collection", Usage: "only records of the collection like app.bsky.feed.*"},
+					&cli.StringSliceFlag{Name: "did", Usage: "only records of the repository"},
+					&cli.StringFlag{Name: "did-file", Usage: "only records of the repositories listed in the file"},
+					&cli.StringSliceFlag{Name: "op", Usage: "only the operation: create, update or delete"},
+					&cli.StringSliceFlag{Name: "lang", Usage: "only posts in the language"},
+					&cli.BoolFlag{Name: "has-media", Usage: "only posts with images or videos"},
+					&cli.StringSliceFlag{Name: "mentions", Usage: "only posts mentioning the DID"},
+					&cli.StringFlag{Name: "where", Usage: "only events for which the template expression like 'contains .rec.text \"go\"' is true"},
+					&cli.BoolFlag{Name: "compress", Usage: "compress the events with zstd (with --jetstream)"},
+					&cli.BoolFlag{Name: "resume", Usage: "resume from the cursor saved at the last run, and save the cursor"},
+					&cli.BoolFlag{Name: "verify", Usage: "verify the signatures and the ops of the commits, and skip the invalid ones"},
+					&cli.StringFlag{Name: "quarantine", Usage: "append the invalid commits to the file as JSON lines (with --verify)"},
+					&cli.StringSliceFlag{Name: "out", Usage: "write the events to the JSONL file, or to the SQLite database as sqlite:FILE"},
+					&cli.StringFlag{Name: "rotate-size", Usage: "rotate the JSONL files at the size like 100M"},
+					&cli.DurationFlag{Name: "rotate-interval", Usage: "rotate the JSONL files at the interval like 1h"},
+					&cli.BoolFlag{Name: "gzip", Usage: "compress the JSONL files with gzip"},
+					&cli.StringFlag{Name: "exec", Usage: "pipe the events to the command as JSON lines"},
 				},
 				Action: doStream,
 			},
+			{
+				Name:        "bot",
+				Description: "Act on posts of the stream with rules",
+				Usage:       "Act on posts of the stream with rules",
+				UsageText:   "bsky bot -c rules.yaml [host]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Usage: "rules file in YAML", TakesFile: true},
+					&cli.BoolFlag{Name: "dry-run", Usage: "print the actions without doing them"},
+					&cli.BoolFlag{Name: "jetstream", Usage: "consume Jetstream instead of the firehose"},
+					&cli.BoolFlag{Name: "compress", Usage: "compress the events with zstd (with --jetstream)"},
+					&cli.BoolFlag{Name: "resume", Usage: "resume from the cursor saved at the last run, and save the cursor"},
+				},
+				Action: doBot,
+			},
 			{
 				Name:        "thread",
 				Description: "Show thread",
 				Usage:       "Show thread",
 				UsageText:   "bsky thread [uri]",
 				Flags: []cli.Flag{
-					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
-					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.IntFlag{Name: "n", Value: 30, Usage: "number of parent posts"},
+					&cli.IntFlag{Name: "depth", Value: 6, Usage: "depth of replies"},
+					&cli.StringFlag{Name: "sort", Value: "time", Usage: "sort replies by likes or time"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON as a nested tree"},
+					&cli.StringFlag{Name: "export", Usage: "export the thread as md or html"},
+					&cli.StringFlag{Name: "o", Usage: "output file of the export"},
+					&cli.BoolFlag{Name: "download-images", Usage: "download images next to the output file"},
 				},
 				Action: doThread,
 			},
@@ -115,6 +200,7 @@ func main() {
 					&cli.StringFlag{Name: "r"},
 					&cli.StringFlag{Name: "q"},
 					&cli.BoolFlag{Name: "stdin"},
+					&cli.BoolFlag{Name: "e", Usage: "compose the post with $EDITOR"},
 					&cli.StringSliceFlag{Name: "image", Aliases: []string{"i"}},
 					&cli.StringSliceFlag{Name: "image-alt", Aliases: []string{"ia"}},
 					&cli.StringFlag{Name: "video", Aliases: []string{"v"}},
@@ -124,6 +210,95 @@ func main() {
 				ArgsUsage: "[text]",
 				Action:    doPost,
 			},
+			{
+				Name:        "edit",
+				Description: "Edit the post",
+				Usage:       "Edit the post",
+				UsageText:   "bsky edit [uri] [text]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "stdin"},
+					&cli.BoolFlag{Name: "keep-created-at", Usage: "keep createdAt of the post"},
+				},
+				HelpName:  "edit",
+				ArgsUsage: "[uri] [text]",
+				Action:    doEdit,
+			},
+			{
+				Name:        "draft",
+				Description: "Manage drafts",
+				Usage:       "Manage drafts",
+				UsageText:   "bsky draft <command>",
+				HelpName:    "draft",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "save",
+						Description: "Save a new draft",
+						Usage:       "Save a new draft",
+						UsageText:   "bsky draft save [text]",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "r"},
+							&cli.StringFlag{Name: "q"},
+							&cli.BoolFlag{Name: "stdin"},
+							&cli.BoolFlag{Name: "e", Usage: "compose the draft with $EDITOR"},
+							&cli.StringSliceFlag{Name: "image", Aliases: []string{"i"}},
+							&cli.StringSliceFlag{Name: "image-alt", Aliases: []string{"ia"}},
+							&cli.StringFlag{Name: "video", Aliases: []string{"v"}},
+							&cli.StringFlag{Name: "video-alt", Aliases: []string{"va"}},
+						},
+						Action: doDraftSave,
+					},
+					{
+						Name:        "list",
+						Description: "Show drafts",
+						Usage:       "Show drafts",
+						UsageText:   "bsky draft list",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+						},
+						Action: doDraftList,
+					},
+					{
+						Name:        "edit",
+						Description: "Edit the draft with $EDITOR",
+						Usage:       "Edit the draft with $EDITOR",
+						UsageText:   "bsky draft edit [name]",
+						Action:      doDraftEdit,
+					},
+					{
+						Name:        "publish",
+						Description: "Post the draft",
+						Usage:       "Post the draft",
+						UsageText:   "bsky draft publish [name]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "e", Usage: "edit the draft with $EDITOR before posting"},
+						},
+						Action: doDraftPublish,
+					},
+					{
+						Name:        "delete",
+						Description: "Delete the draft",
+						Usage:       "Delete the draft",
+						UsageText:   "bsky draft delete [name]...",
+						Action:      doDraftDelete,
+					},
+				},
+			},
+			{
+				Name:        "pin",
+				Description: "Pin the post to the profile",
+				Usage:       "Pin the post to the profile",
+				UsageText:   "bsky pin [uri]",
+				HelpName:    "pin",
+				Action:      doPin,
+			},
+			{
+				Name:        "unpin",
+				Description: "Unpin the post from the profile",
+				Usage:       "Unpin the post from the profile",
+				UsageText:   "bsky unpin",
+				HelpName:    "unpin",
+				Action:      doUnpin,
+			},
 			{
 				Name:        "vote",
 				Description: "Vote the post",
@@ -348,9 +523,18 @@ func main() {
 				Name:        "delete",
 				Description: "Delete the note",
 				Usage:       "Delete the note",
-				UsageText:   "bsky delete [cid]",
+				UsageText:   "bsky delete [uri]...\n   bsky delete [--before date] [--after date] [--match regexp] [--collection name]... [--dry-run]",
 				HelpName:    "delete",
 				Action:      doDelete,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "collection", Value: cli.NewStringSlice("app.bsky.feed.post"), Usage: "collection to delete (post, like, repost, follow, block or NSID)"},
+					&cli.StringFlag{Name: "before", Usage: "delete records created before the date"},
+					&cli.StringFlag{Name: "after", Usage: "delete records created after the date"},
+					&cli.StringFlag{Name: "match", Usage: "delete posts matching the regexp"},
+					&cli.BoolFlag{Name: "no-replies", Usage: "keep replies"},
+					&cli.BoolFlag{Name: "all", Usage: "delete all records in the collection"},
+					&cli.BoolFlag{Name: "dry-run", Usage: "show records to be deleted"},
+				},
 			},
 			{
 				Name:        "search",
@@ -359,9 +543,61 @@ func main() {
 				UsageText:   "bsky search [terms]",
 				HelpName:    "search",
 				Action:      doSearch,
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
 					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+				}, searchFlags()...),
+				Subcommands: []*cli.Command{
+					{
+						Name:        "save",
+						Description: "Save the search",
+						Usage:       "Save the search",
+						UsageText:   "bsky search save [name] [terms]",
+						Flags:       searchFlags(),
+						Action:      doSearchSave,
+					},
+					{
+						Name:        "list",
+						Description: "Show saved searches",
+						Usage:       "Show saved searches",
+						UsageText:   "bsky search list",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+						},
+						Action: doSearchList,
+					},
+					{
+						Name:        "delete",
+						Description: "Delete the saved search",
+						Usage:       "Delete the saved search",
+						UsageText:   "bsky search delete [name]",
+						Action:      doSearchDelete,
+					},
+					{
+						Name:        "run",
+						Description: "Run the saved search",
+						Usage:       "Run the saved search",
+						UsageText:   "bsky search run [name]",
+						Flags: []cli.Flag{
+							&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
+							&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+							&cli.BoolFlag{Name: "new", Usage: "show only matches not shown yet (all of them unless -n is given)"},
+							&cli.StringFlag{Name: "exec", Usage: "run the command for each match instead of printing it"},
+						},
+						Action: doSearchRun,
+					},
+					{
+						Name:        "watch",
+						Description: "Watch new matches of the saved searches",
+						Usage:       "Watch new matches of the saved searches",
+						UsageText:   "bsky search watch [names...]",
+						Flags: []cli.Flag{
+							&cli.IntFlag{Name: "n", Value: 30, Usage: "number of items"},
+							&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+							&cli.StringFlag{Name: "exec", Usage: "run the command for each new match instead of printing it"},
+						},
+						Action: doSearchWatch,
+					},
 				},
 			},
 			{
@@ -373,6 +609,8 @@ func main() {
 				Action:      doSearchActors,
 				Flags: []cli.Flag{
 					&cli.IntFlag{Name: "n", Value: 100, Usage: "number of items"},
+					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.BoolFlag{Name: "typeahead", Usage: "match the prefixes of handles and names as you type"},
 				},
 			},
 			{
@@ -396,6 +634,7 @@ func main() {
 				Flags: []cli.Flag{
 					&cli.BoolFlag{Name: "a", Usage: "show all"},
 					&cli.BoolFlag{Name: "json", Usage: "output JSON"},
+					&cli.BoolFlag{Name: "f", Usage: "follow new notifications"},
 				},
 				HelpName: "notification",
 				Action:   doNotification,
@@ -439,6 +678,14 @@ func main() {
 				HelpName:    "revoke-app-password",
 				Action:      doRevokeAppPassword,
 			},
+			{
+				Name:        "tui",
+				Description: "Start interactive client",
+				Usage:       "Start interactive client",
+				UsageText:   "bsky tui",
+				HelpName:    "tui",
+				Action:      doTUI,
+			},
 			{
 				Name:        "mcp",
 				Description: "Start MCP server",
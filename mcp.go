@@ -293,8 +293,36 @@ func doMcp(cCtx *cli.Context) error {
 	s.AddTool(mcp.NewTool("bluesky_search",
 		mcp.WithDescription("Search posts on Bluesky"),
 		mcp.WithString("query",
-			mcp.Description("Search terms"),
-			mcp.Required(),
+			mcp.Description("Search terms. Inline operators like from:, mentions:, lang:, domain:, url:, tag:, since:, until: and sort: are accepted"),
+		),
+		mcp.WithString("author",
+			mcp.Description("Only posts by this handle or DID"),
+		),
+		mcp.WithString("mentions",
+			mcp.Description("Only posts mentioning this handle or DID"),
+		),
+		mcp.WithString("lang",
+			mcp.Description("Only posts in this language (e.g. en, ja)"),
+		),
+		mcp.WithString("domain",
+			mcp.Description("Only posts linking to this domain"),
+		),
+		mcp.WithString("url",
+			mcp.Description("Only posts linking to this URL"),
+		),
+		mcp.WithArray("tag",
+			mcp.Description("Only posts with all of these hashtags (without #)"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithString("since",
+			mcp.Description("Only posts after this date, RFC3339 time, or duration ago like 24h or 7d"),
+		),
+		mcp.WithString("until",
+			mcp.Description("Only posts before this date, RFC3339 time, or duration ago like 24h or 7d"),
+		),
+		mcp.WithString("sort",
+			mcp.Description("Sort order of results"),
+			mcp.Enum("top", "latest"),
 		),
 		mcp.WithNumber("n",
 			mcp.Description("Maximum number of results"),
@@ -307,26 +335,31 @@ func doMcp(cCtx *cli.Context) error {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		query := mcp.ParseString(request, "query", "")
 		n := mcp.ParseInt64(request, "n", 30)
 
-		var results []*bsky.FeedDefs_PostView
-		var cursor string
-		for {
-			resp, err := bsky.FeedSearchPosts(ctx, xrpcc, "", cursor, "", "", 100, "", query, "", "", nil, "", "")
-			if err != nil {
-				return mcp.NewToolResultError(fmt.Sprintf("cannot search: %v", err)), nil
-			}
-			if resp.Cursor != nil {
-				cursor = *resp.Cursor
-			} else {
-				cursor = ""
-			}
-			results = append(results, resp.Posts...)
-			if cursor == "" || int64(len(results)) > n {
-				break
+		now := time.Now()
+		q, err := parseSearchQuery(mcp.ParseString(request, "query", ""), now)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		for _, name := range []string{"author", "mentions", "lang", "domain", "url", "since", "until", "sort"} {
+			if v := mcp.ParseString(request, name, ""); v != "" {
+				if _, err := q.set(name, v, now); err != nil {
+					return mcp.NewToolResultError(err.Error()), nil
+				}
 			}
 		}
+		for _, tag := range request.GetStringSlice("tag", nil) {
+			q.set("tag", tag, now)
+		}
+		if err := q.normalize(); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		results, err := searchPosts(ctx, xrpcc, q, n)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
 		if int64(len(results)) > n {
 			results = results[:n]
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/api/chat"
+	"github.com/urfave/cli/v2"
+)
+
+// outputFormats are the formats for --format.
+var outputFormats = []string{"table", "tsv", "csv", "jsonl", "json"}
+
+// maxTableText is the maximum number of characters of a text in a table.
+const maxTableText = 60
+
+// postData is a post passed to templates and tables. Record is the decoded
+// post record, so that templates can refer .Record.Text. Reason and Reply are
+// set for posts in feeds.
+type postData struct {
+	*bsky.FeedDefs_PostView
+	Record *bsky.FeedPost
+	Reason *bsky.FeedDefs_FeedViewPost_Reason
+	Reply  *bsky.FeedDefs_ReplyRef
+}
+
+func newPostData(p *bsky.FeedDefs_PostView) *postData {
+	d := &postData{FeedDefs_PostView: p}
+	if p.Record != nil {
+		d.Record, _ = p.Record.Val.(*bsky.FeedPost)
+	}
+	if d.Record == nil {
+		d.Record = &bsky.FeedPost{}
+	}
+	return d
+}
+
+func newFeedPostData(p *bsky.FeedDefs_FeedViewPost) *postData {
+	d := newPostData(p.Post)
+	d.Reason = p.Reason
+	d.Reply = p.Reply
+	return d
+}
+
+// notificationData is a notification passed to templates and tables. Record
+// is the decoded record like *bsky.FeedPost or *bsky.FeedLike.
+type notificationData struct {
+	*bsky.NotificationListNotifications_Notification
+	Record any
+}
+
+func newNotificationData(n *bsky.NotificationListNotifications_Notification) *notificationData {
+	d := &notificationData{NotificationListNotifications_Notification: n}
+	if n.Record != nil {
+		d.Record = n.Record.Val
+	}
+	return d
+}
+
+// actorData is an actor passed to templates and tables. Relationship is the
+// relationship of the viewer to the actor like "following,followed-by".
+type actorData struct {
+	*bsky.ActorDefs_ProfileViewDetailed
+	Relationship string
+}
+
+func newActorData(p *bsky.ActorDefs_ProfileViewDetailed) *actorData {
+	return &actorData{ActorDefs_ProfileViewDetailed: p, Relationship: viewerRelationship(p.Viewer)}
+}
+
+// viewerRelationship returns the relationship of the viewer to the actor as
+// a comma separated list of following, followed-by, muted, blocked and
+// blocked-by.
+func viewerRelationship(v *bsky.ActorDefs_ViewerState) string {
+	if v == nil {
+		return ""
+	}
+	var rel []string
+	if v.Following != nil {
+		rel = append(rel, "following")
+	}
+	if v.FollowedBy != nil {
+		rel = append(rel, "followed-by")
+	}
+	if boolp(v.Muted) || v.MutedByList != nil {
+		rel = append(rel, "muted")
+	}
+	if v.Blocking != nil || v.BlockingByList != nil {
+		rel = append(rel, "blocked")
+	}
+	if boolp(v.BlockedBy) {
+		rel = append(rel, "blocked-by")
+	}
+	return strings.Join(rel, ",")
+}
+
+// templateTime formats v in local time. v may be a string, a *string or a
+// time.Time.
+func templateTime(v any, layout ...string) string {
+	var t time.Time
+	switch v := v.(type) {
+	case string:
+		t, _ = parseTime(v)
+	case *string:
+		t, _ = parseTime(stringp(v))
+	case time.Time:
+		t = v
+	}
+	if t.IsZero() {
+		return ""
+	}
+	l := "2006-01-02 15:04:05"
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+	return t.Local().Format(l)
+}
+
+// templateTrunc truncates v to n characters. It is written as
+// {{.Record.Text | trunc 20}}.
+func templateTrunc(n int, v any) string {
+	s := fmt.Sprint(v)
+	if p, ok := v.(*string); ok {
+		s = stringp(p)
+	}
+	if rs := []rune(s); len(rs) > n {
+		return string(rs[:n]) + "..."
+	}
+	return s
+}
+
+// templateWebURL returns the URL on bsky.app of v.
+func templateWebURL(v any) string {
+	switch v := v.(type) {
+	case *postData:
+		return webURL(v.Uri, v.Author.Handle)
+	case *bsky.FeedDefs_PostView:
+		return webURL(v.Uri, v.Author.Handle)
+	case *bsky.FeedDefs_FeedViewPost:
+		return webURL(v.Post.Uri, v.Post.Author.Handle)
+	case *notificationData:
+		return webURL(v.Uri, v.Author.Handle)
+	case *bsky.ActorDefs_ProfileView:
+		return "https://bsky.app/profile/" + v.Handle
+	case *bsky.ActorDefs_ProfileViewBasic:
+		return "https://bsky.app/profile/" + v.Handle
+	case *bsky.ActorDefs_ProfileViewDetailed:
+		return "https://bsky.app/profile/" + v.Handle
+	case *actorData:
+		return "https://bsky.app/profile/" + v.Handle
+	case *bsky.GraphDefs_ListView:
+		return webURL(v.Uri, v.Creator.Handle)
+	case string:
+		return webURL(atURI(v), "")
+	}
+	return ""
+}
+
+var templateFuncs = template.FuncMap{
+	"time":   templateTime,
+	"trunc":  templateTrunc,
+	"weburl": templateWebURL,
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// output writes items as specified with the global --template and --format
+// flags. --json of each command is same as --format jsonl.
+type output struct {
+	format string
+	tmpl   *template.Template
+	items  []any
+	tw     *tabwriter.Writer
+	cw     *csv.Writer
+	header bool
+}
+
+// newOutput returns nil when the items should be printed as usual.
+func newOutput(cCtx *cli.Context) (*output, error) {
+	format := cCtx.String("format")
+	text := cCtx.String("template")
+	if text != "" && format != "" {
+		return nil, fmt.Errorf("--template and --format cannot be used together")
+	}
+	if text != "" {
+		tmpl, err := template.New("").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return &output{tmpl: tmpl}, nil
+	}
+	if format == "" {
+		if !cCtx.Bool("json") {
+			return nil, nil
+		}
+		format = "jsonl"
+	}
+
+	o := &output{format: format}
+	switch format {
+	case "table":
+		o.tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	case "tsv":
+	case "csv":
+		o.cw = csv.NewWriter(os.Stdout)
+	case "jsonl", "json":
+	default:
+		return nil, fmt.Errorf("invalid format: %q (must be one of %s)", format, strings.Join(outputFormats, ", "))
+	}
+	return o, nil
+}
+
+// newFollowOutput returns the output of the commands which print the new
+// items until interrupted. --format json is refused, because the array would
+// be written only at the exit.
+func newFollowOutput(cCtx *cli.Context) (*output, error) {
+	if cCtx.String("format") == "json" {
+		return nil, fmt.Errorf("--format json is not available when following, use --format jsonl")
+	}
+	return newOutput(cCtx)
+}
+
+// write writes v.
+func (o *output) write(v any) error {
+	return o.writeAs(v, v)
+}
+
+// writeAs writes v as JSON, or data with the template or as a row.
+func (o *output) writeAs(v, data any) error {
+	if o.tmpl != nil {
+		if err := o.tmpl.Execute(os.Stdout, data); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	}
+
+	switch o.format {
+	case "jsonl":
+		return json.NewEncoder(os.Stdout).Encode(v)
+	case "json":
+		o.items = append(o.items, v)
+		return nil
+	}
+
+	header, row := outputRow(data)
+	if !o.header {
+		o.header = true
+		if err := o.writeRow(header); err != nil {
+			return err
+		}
+	}
+	return o.writeRow(row)
+}
+
+func (o *output) writeRow(row []string) error {
+	switch o.format {
+	case "csv":
+		return o.cw.Write(row)
+	case "table":
+		for i := range row {
+			row[i] = templateTrunc(maxTableText, tableCell(row[i]))
+		}
+		_, err := fmt.Fprintln(o.tw, strings.Join(row, "\t"))
+		return err
+	default:
+		for i := range row {
+			row[i] = tableCell(row[i])
+		}
+		_, err := fmt.Fprintln(os.Stdout, strings.Join(row, "\t"))
+		return err
+	}
+}
+
+// tableCell makes s one line without tabs.
+func tableCell(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// flush writes the rows written so far.
+func (o *output) flush() error {
+	if o.tw != nil {
+		return o.tw.Flush()
+	}
+	if o.cw != nil {
+		o.cw.Flush()
+		return o.cw.Error()
+	}
+	return nil
+}
+
+// close finishes the output. It does nothing for nil.
+func (o *output) close() error {
+	if o == nil {
+		return nil
+	}
+	if o.format == "json" {
+		if o.items == nil {
+			o.items = []any{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o.items)
+	}
+	return o.flush()
+}
+
+// outputRow returns the header and the row of v for tables.
+func outputRow(v any) ([]string, []string) {
+	switch v := v.(type) {
+	case *postData:
+		return []string{"TIME", "HANDLE", "TEXT", "LIKES", "REPOSTS", "REPLIES", "URI"}, []string{
+			templateTime(v.Record.CreatedAt),
+			v.Author.Handle,
+			v.Record.Text,
+			strconv.FormatInt(int64p(v.LikeCount), 10),
+			strconv.FormatInt(int64p(v.RepostCount), 10),
+			strconv.FormatInt(int64p(v.ReplyCount), 10),
+			v.Uri,
+		}
+	case *bsky.ActorDefs_ProfileView:
+		return []string{"HANDLE", "NAME", "DID"}, []string{v.Handle, stringp(v.DisplayName), v.Did}
+	case *bsky.ActorDefs_ProfileViewBasic:
+		return []string{"HANDLE", "NAME", "DID"}, []string{v.Handle, stringp(v.DisplayName), v.Did}
+	case *actorData:
+		return []string{"HANDLE", "NAME", "FOLLOWERS", "RELATIONSHIP", "DID"}, []string{
+			v.Handle,
+			stringp(v.DisplayName),
+			strconv.FormatInt(int64p(v.FollowersCount), 10),
+			v.Relationship,
+			v.Did,
+		}
+	case *bsky.GraphDefs_ListView:
+		return []string{"NAME", "PURPOSE", "ITEMS", "URI"}, []string{
+			v.Name,
+			stringp(v.Purpose),
+			strconv.FormatInt(int64p(v.ListItemCount), 10),
+			v.Uri,
+		}
+	case *notificationData:
+		return []string{"TIME", "HANDLE", "REASON", "URI"}, []string{
+			templateTime(v.IndexedAt),
+			v.Author.Handle,
+			v.Reason,
+			v.Uri,
+		}
+	case *chat.ConvoDefs_ConvoView:
+		var members []string
+		for _, m := range v.Members {
+			members = append(members, m.Handle)
+		}
+		return []string{"ID", "MEMBERS", "UNREAD"}, []string{
+			v.Id,
+			strings.Join(members, ","),
+			strconv.FormatInt(v.UnreadCount, 10),
+		}
+	case *chat.ConvoDefs_MessageView:
+		return []string{"TIME", "SENDER", "TEXT"}, []string{templateTime(v.SentAt), v.Sender.Did, v.Text}
+	case *savedFeed:
+		return []string{"NAME", "TYPE", "PINNED", "URI"}, []string{v.Name, v.Type, strconv.FormatBool(v.Pinned), v.Uri}
+	case *draftData:
+		return []string{"NAME", "UPDATED", "TEXT", "REPLY", "QUOTE"}, []string{v.Name, templateTime(v.UpdatedAt), v.Text, v.Reply, v.Quote}
+	case *savedSearchData:
+		return []string{"NAME", "TERMS"}, []string{v.Name, v.Terms}
+	case *comatproto.ServerListAppPasswords_AppPassword:
+		return []string{"NAME", "CREATED", "PRIVILEGED"}, []string{v.Name, templateTime(v.CreatedAt), strconv.FormatBool(boolp(v.Privileged))}
+	}
+	b, _ := json.Marshal(v)
+	return []string{"VALUE"}, []string{string(b)}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/urfave/cli/v2"
+)
+
+func TestOutputTemplate(t *testing.T) {
+	likes := int64(3)
+	post := newPostData(&bsky.FeedDefs_PostView{
+		Uri:       "at://did:plc:xxx/app.bsky.feed.post/abc",
+		Author:    &bsky.ActorDefs_ProfileViewBasic{Did: "did:plc:xxx", Handle: "mattn.jp"},
+		Record:    &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "hello world", CreatedAt: "2025-01-01T00:00:00Z"}},
+		LikeCount: &likes,
+	})
+
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{tmpl: "{{.Author.Handle}}\t{{.Record.Text}}", want: "mattn.jp\thello world"},
+		{tmpl: "{{.Record.Text | trunc 5}}", want: "hello..."},
+		{tmpl: "{{weburl .}}", want: "https://bsky.app/profile/mattn.jp/post/abc"},
+		{tmpl: "{{time .Record.CreatedAt \"2006\"}}", want: "2025"},
+		{tmpl: "{{.LikeCount}}", want: "3"},
+	}
+	for _, test := range tests {
+		tmpl, err := template.New("").Funcs(templateFuncs).Parse(test.tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, post); err != nil {
+			t.Fatal(err)
+		}
+		if got := sb.String(); got != test.want {
+			t.Fatalf("want %q but got %q for %q", test.want, got, test.tmpl)
+		}
+	}
+}
+
+func TestOutputRow(t *testing.T) {
+	header, row := outputRow(&bsky.ActorDefs_ProfileView{Did: "did:plc:xxx", Handle: "mattn.jp"})
+	if strings.Join(header, ",") != "HANDLE,NAME,DID" || strings.Join(row, ",") != "mattn.jp,,did:plc:xxx" {
+		t.Fatalf("unexpected row: %v %v", header, row)
+	}
+	if got := tableCell("a\tb\nc  d"); got != "a b c d" {
+		t.Fatalf("want %q but got %q", "a b c d", got)
+	}
+}
+
+func TestViewerRelationship(t *testing.T) {
+	uri := "at://did:plc:me/app.bsky.graph.follow/1"
+	yes := true
+	tests := []struct {
+		viewer *bsky.ActorDefs_ViewerState
+		want   string
+	}{
+		{nil, ""},
+		{&bsky.ActorDefs_ViewerState{}, ""},
+		{&bsky.ActorDefs_ViewerState{Following: &uri, FollowedBy: &uri}, "following,followed-by"},
+		{&bsky.ActorDefs_ViewerState{Muted: &yes, Blocking: &uri}, "muted,blocked"},
+		{&bsky.ActorDefs_ViewerState{BlockedBy: &yes}, "blocked-by"},
+	}
+	for _, test := range tests {
+		if got := viewerRelationship(test.viewer); got != test.want {
+			t.Errorf("want %q but got %q", test.want, got)
+		}
+	}
+
+	followers := int64(42)
+	header, row := outputRow(newActorData(&bsky.ActorDefs_ProfileViewDetailed{
+		Did:            "did:plc:xxx",
+		Handle:         "mattn.jp",
+		FollowersCount: &followers,
+		Viewer:         &bsky.ActorDefs_ViewerState{Following: &uri},
+	}))
+	if strings.Join(header, ",") != "HANDLE,NAME,FOLLOWERS,RELATIONSHIP,DID" || strings.Join(row, ",") != "mattn.jp,,42,following,did:plc:xxx" {
+		t.Fatalf("unexpected row: %v %v", header, row)
+	}
+}
+
+func TestNewFollowOutput(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		err    bool
+	}{
+		{format: "json", err: true},
+		{format: "jsonl"},
+		{format: "table"},
+		{format: ""},
+	} {
+		set := flag.NewFlagSet("timeline", flag.ContinueOnError)
+		set.String("format", tt.format, "")
+		_, err := newFollowOutput(cli.NewContext(cli.NewApp(), set, nil))
+		if (err != nil) != tt.err {
+			t.Errorf("--format %q: unexpected error %v", tt.format, err)
+		}
+	}
+}
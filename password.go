@@ -26,16 +26,23 @@ func doListAppPasswords(cCtx *cli.Context) error {
 		arg = xrpcc.Auth.Handle
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	passwords, err := comatproto.ServerListAppPasswords(context.TODO(), xrpcc)
 	if err != nil {
 		return fmt.Errorf("cannot get profile: %w", err)
 	}
 
-	if cCtx.Bool("json") {
+	if out != nil {
 		for _, password := range passwords.Passwords {
-			json.NewEncoder(os.Stdout).Encode(password)
+			if err := out.write(password); err != nil {
+				return err
+			}
 		}
-		return nil
+		return out.close()
 	}
 
 	for _, password := range passwords.Passwords {
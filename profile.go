@@ -157,12 +157,16 @@ func doUpdateProfile(cCtx *cli.Context) error {
 		return fmt.Errorf("cannot get profile: %w", err)
 	}
 
-	updatedRecord := &lexutil.LexiconTypeDecoder{Val: &bsky.ActorProfile{
-		Description: desc,
-		DisplayName: name,
-		Avatar:      avatar,
-		Banner:      banner,
-	}}
+	// keep the other fields like pinnedPost
+	profile, ok := currentRecord.Value.Val.(*bsky.ActorProfile)
+	if !ok {
+		profile = &bsky.ActorProfile{}
+	}
+	profile.Description = desc
+	profile.DisplayName = name
+	profile.Avatar = avatar
+	profile.Banner = banner
+	updatedRecord := &lexutil.LexiconTypeDecoder{Val: profile}
 
 	_, err = comatproto.RepoPutRecord(context.TODO(), xrpcc, &comatproto.RepoPutRecord_Input{
 		Repo:       xrpcc.Auth.Did,
@@ -178,6 +182,78 @@ func doUpdateProfile(cCtx *cli.Context) error {
 	return nil
 }
 
+// setPinnedPost updates pinnedPost of the profile record. Other fields of
+// the record are kept as they are.
+func setPinnedPost(xrpcc *xrpc.Client, ref *comatproto.RepoStrongRef) error {
+	currentRecord, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", "app.bsky.actor.profile", xrpcc.Auth.Did, "self")
+	if err != nil {
+		return fmt.Errorf("cannot get profile: %w", err)
+	}
+	profile, ok := currentRecord.Value.Val.(*bsky.ActorProfile)
+	if !ok {
+		return fmt.Errorf("cannot get profile: unexpected record")
+	}
+	profile.PinnedPost = ref
+
+	_, err = comatproto.RepoPutRecord(context.TODO(), xrpcc, &comatproto.RepoPutRecord_Input{
+		Repo:       xrpcc.Auth.Did,
+		Collection: "app.bsky.actor.profile",
+		Rkey:       "self",
+		Record:     &lexutil.LexiconTypeDecoder{Val: profile},
+		SwapRecord: currentRecord.Cid,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot update profile: %w", err)
+	}
+	return nil
+}
+
+func doPin(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	arg := atURI(cCtx.Args().First())
+	parts := strings.Split(arg, "/")
+	if len(parts) < 5 {
+		return fmt.Errorf("invalid post uri: %q", arg)
+	}
+	rkey := parts[len(parts)-1]
+	collection := parts[len(parts)-2]
+	did := parts[2]
+	if did != xrpcc.Auth.Did {
+		return fmt.Errorf("cannot pin a post of another user: %q", arg)
+	}
+
+	resp, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", collection, did, rkey)
+	if err != nil {
+		return fmt.Errorf("cannot get record: %w", err)
+	}
+	if _, ok := resp.Value.Val.(*bsky.FeedPost); !ok || resp.Cid == nil {
+		return fmt.Errorf("not a post: %q", arg)
+	}
+
+	return setPinnedPost(xrpcc, &comatproto.RepoStrongRef{Uri: resp.Uri, Cid: *resp.Cid})
+}
+
+func doUnpin(cCtx *cli.Context) error {
+	if cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	return setPinnedPost(xrpcc, nil)
+}
+
 func doFollow(cCtx *cli.Context) error {
 	if !cCtx.Args().Present() {
 		return cli.ShowSubcommandHelp(cCtx)
@@ -194,27 +270,36 @@ func doFollow(cCtx *cli.Context) error {
 			return fmt.Errorf("cannot get profile: %w", err)
 		}
 
-		follow := bsky.GraphFollow{
-			LexiconTypeID: "app.bsky.graph.follow",
-			CreatedAt:     time.Now().Local().Format(time.RFC3339),
-			Subject:       profile.Did,
-		}
-
-		resp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
-			Collection: "app.bsky.graph.follow",
-			Repo:       xrpcc.Auth.Did,
-			Record: &lexutil.LexiconTypeDecoder{
-				Val: &follow,
-			},
-		})
+		uri, err := createFollow(xrpcc, profile.Did)
 		if err != nil {
 			return err
 		}
-		fmt.Println(resp.Uri)
+		fmt.Println(uri)
 	}
 	return nil
 }
 
+// createFollow follows did and returns the URI of the follow record.
+func createFollow(xrpcc *xrpc.Client, did string) (string, error) {
+	follow := bsky.GraphFollow{
+		LexiconTypeID: "app.bsky.graph.follow",
+		CreatedAt:     time.Now().Local().Format(time.RFC3339),
+		Subject:       did,
+	}
+
+	resp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.graph.follow",
+		Repo:       xrpcc.Auth.Did,
+		Record: &lexutil.LexiconTypeDecoder{
+			Val: &follow,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Uri, nil
+}
+
 func doUnfollow(cCtx *cli.Context) error {
 	if !cCtx.Args().Present() {
 		return cli.ShowSubcommandHelp(cCtx)
@@ -259,6 +344,11 @@ func doFollows(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -276,9 +366,11 @@ func doFollows(cCtx *cli.Context) error {
 			return fmt.Errorf("getting record: %w", err)
 		}
 
-		if cCtx.Bool("json") {
+		if out != nil {
 			for _, f := range follows.Follows {
-				json.NewEncoder(os.Stdout).Encode(f)
+				if err := out.write(f); err != nil {
+					return err
+				}
 			}
 		} else {
 			for _, f := range follows.Follows {
@@ -296,7 +388,7 @@ func doFollows(cCtx *cli.Context) error {
 		}
 		cursor = *follows.Cursor
 	}
-	return nil
+	return out.close()
 }
 
 func doFollowers(cCtx *cli.Context) error {
@@ -304,6 +396,11 @@ func doFollowers(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -321,9 +418,11 @@ func doFollowers(cCtx *cli.Context) error {
 			return fmt.Errorf("getting record: %w", err)
 		}
 
-		if cCtx.Bool("json") {
+		if out != nil {
 			for _, f := range followers.Followers {
-				json.NewEncoder(os.Stdout).Encode(f)
+				if err := out.write(f); err != nil {
+					return err
+				}
 			}
 		} else {
 			for _, f := range followers.Followers {
@@ -341,7 +440,7 @@ func doFollowers(cCtx *cli.Context) error {
 		}
 		cursor = *followers.Cursor
 	}
-	return nil
+	return out.close()
 }
 
 func doBlock(cCtx *cli.Context) error {
@@ -563,20 +662,7 @@ func doModList(cCtx *cli.Context) error {
 			}
 		}
 
-		listItem := bsky.GraphListitem{
-			Subject:   *did,
-			List:      listURI,
-			CreatedAt: time.Now().Format(time.RFC3339),
-		}
-
-		_, err = comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
-			Repo:       xrpcc.Auth.Did,
-			Collection: "app.bsky.graph.listitem",
-			Record: &lexutil.LexiconTypeDecoder{
-				Val: &listItem,
-			},
-		})
-		if err != nil {
+		if _, err := addListItem(xrpcc, listURI, *did); err != nil {
 			panic(err)
 		}
 
@@ -585,6 +671,27 @@ func doModList(cCtx *cli.Context) error {
 	return nil
 }
 
+// addListItem adds did to the list and returns the URI of the list item.
+func addListItem(xrpcc *xrpc.Client, list, did string) (string, error) {
+	listItem := bsky.GraphListitem{
+		Subject:   did,
+		List:      list,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	resp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
+		Repo:       xrpcc.Auth.Did,
+		Collection: "app.bsky.graph.listitem",
+		Record: &lexutil.LexiconTypeDecoder{
+			Val: &listItem,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Uri, nil
+}
+
 func doUnmute(cCtx *cli.Context) error {
 	if !cCtx.Args().Present() {
 		return cli.ShowSubcommandHelp(cCtx)
@@ -620,6 +727,11 @@ func doMutes(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -632,9 +744,11 @@ func doMutes(cCtx *cli.Context) error {
 			return fmt.Errorf("getting mutes: %w", err)
 		}
 
-		if cCtx.Bool("json") {
+		if out != nil {
 			for _, m := range mutes.Mutes {
-				json.NewEncoder(os.Stdout).Encode(m)
+				if err := out.write(m); err != nil {
+					return err
+				}
 			}
 		} else {
 			for _, m := range mutes.Mutes {
@@ -652,7 +766,7 @@ func doMutes(cCtx *cli.Context) error {
 		}
 		cursor = *mutes.Cursor
 	}
-	return nil
+	return out.close()
 }
 
 func doLists(cCtx *cli.Context) error {
@@ -660,6 +774,11 @@ func doLists(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -677,9 +796,11 @@ func doLists(cCtx *cli.Context) error {
 			return fmt.Errorf("cannot get lists: %w", err)
 		}
 
-		if cCtx.Bool("json") {
+		if out != nil {
 			for _, l := range resp.Lists {
-				json.NewEncoder(os.Stdout).Encode(l)
+				if err := out.write(l); err != nil {
+					return err
+				}
 			}
 		} else {
 			for _, l := range resp.Lists {
@@ -705,7 +826,7 @@ func doLists(cCtx *cli.Context) error {
 		}
 		cursor = *resp.Cursor
 	}
-	return nil
+	return out.close()
 }
 
 func doList(cCtx *cli.Context) error {
@@ -713,6 +834,11 @@ func doList(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -727,9 +853,11 @@ func doList(cCtx *cli.Context) error {
 			return fmt.Errorf("cannot get list: %w", err)
 		}
 
-		if cCtx.Bool("json") {
+		if out != nil {
 			for _, item := range resp.Items {
-				json.NewEncoder(os.Stdout).Encode(item)
+				if err := out.writeAs(item, item.Subject); err != nil {
+					return err
+				}
 			}
 		} else {
 			if cursor == "" {
@@ -757,7 +885,68 @@ func doList(cCtx *cli.Context) error {
 		}
 		cursor = *resp.Cursor
 	}
-	return nil
+	return out.close()
+}
+
+// searchActors returns up to n actors matching term. Typeahead returns only
+// one page of up to 100 actors.
+func searchActors(ctx context.Context, xrpcc *xrpc.Client, term string, n int64, typeahead bool) ([]string, error) {
+	var dids []string
+	if typeahead {
+		resp, err := bsky.ActorSearchActorsTypeahead(ctx, xrpcc, min(n, 100), term, "")
+		if err != nil {
+			return nil, fmt.Errorf("cannot search actors: %w", err)
+		}
+		for _, actor := range resp.Actors {
+			dids = append(dids, actor.Did)
+		}
+		return dids, nil
+	}
+
+	var cursor string
+	for int64(len(dids)) < n {
+		resp, err := bsky.ActorSearchActors(ctx, xrpcc, cursor, min(n-int64(len(dids)), 100), term, "")
+		if err != nil {
+			return nil, fmt.Errorf("cannot search actors: %w", err)
+		}
+		for _, actor := range resp.Actors {
+			dids = append(dids, actor.Did)
+		}
+		if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Actors) == 0 {
+			break
+		}
+		cursor = *resp.Cursor
+	}
+	if int64(len(dids)) > n {
+		dids = dids[:n]
+	}
+	return dids, nil
+}
+
+// maxGetProfiles is the maximum number of actors of app.bsky.actor.getProfiles.
+const maxGetProfiles = 25
+
+// getProfiles returns the detailed profiles of dids in the same order. The
+// search results lack the counts, so they are fetched again. Actors which
+// are not found are skipped.
+func getProfiles(ctx context.Context, xrpcc *xrpc.Client, dids []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error) {
+	var profiles []*bsky.ActorDefs_ProfileViewDetailed
+	for i := 0; i < len(dids); i += maxGetProfiles {
+		resp, err := bsky.ActorGetProfiles(ctx, xrpcc, dids[i:min(i+maxGetProfiles, len(dids))])
+		if err != nil {
+			return nil, fmt.Errorf("cannot get profiles: %w", err)
+		}
+		found := map[string]*bsky.ActorDefs_ProfileViewDetailed{}
+		for _, p := range resp.Profiles {
+			found[p.Did] = p
+		}
+		for _, did := range dids[i:min(i+maxGetProfiles, len(dids))] {
+			if p, ok := found[did]; ok {
+				profiles = append(profiles, p)
+			}
+		}
+	}
+	return profiles, nil
 }
 
 func doSearchActors(cCtx *cli.Context) error {
@@ -765,6 +954,11 @@ func doSearchActors(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -773,18 +967,38 @@ func doSearchActors(cCtx *cli.Context) error {
 	n := cCtx.Int64("n")
 
 	for _, arg := range cCtx.Args().Slice() {
-		result, err := bsky.ActorSearchActors(context.TODO(), xrpcc, "", n, arg, "")
+		dids, err := searchActors(context.TODO(), xrpcc, arg, n, cCtx.Bool("typeahead"))
 		if err != nil {
-			panic("Failed to search: " + err.Error())
+			return err
 		}
-		for _, actor := range result.Actors {
-			jsn, err := json.MarshalIndent(&actor, "", "  ")
-			if err == nil {
-				fmt.Println("Actor: ", string(jsn))
+		profiles, err := getProfiles(context.TODO(), xrpcc, dids)
+		if err != nil {
+			return err
+		}
+		if out != nil {
+			for _, p := range profiles {
+				if err := out.writeAs(p, newActorData(p)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, p := range profiles {
+			color.Set(color.FgHiRed)
+			fmt.Print(p.Handle)
+			color.Set(color.Reset)
+			fmt.Printf(" [%s] %d followers ", stringp(p.DisplayName), int64p(p.FollowersCount))
+			if rel := viewerRelationship(p.Viewer); rel != "" {
+				color.Set(color.FgGreen)
+				fmt.Printf("(%s) ", rel)
+				color.Set(color.Reset)
 			}
+			color.Set(color.FgBlue)
+			fmt.Println(p.Did)
+			color.Set(color.Reset)
 		}
 	}
-	return nil
+	return out.close()
 }
 
 func doUnblock(cCtx *cli.Context) error {
@@ -831,6 +1045,11 @@ func doBlocks(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -843,9 +1062,11 @@ func doBlocks(cCtx *cli.Context) error {
 			return fmt.Errorf("getting record: %w", err)
 		}
 
-		if cCtx.Bool("json") {
+		if out != nil {
 			for _, f := range blocks.Blocks {
-				json.NewEncoder(os.Stdout).Encode(f)
+				if err := out.write(f); err != nil {
+					return err
+				}
 			}
 		} else {
 			for _, f := range blocks.Blocks {
@@ -863,7 +1084,7 @@ func doBlocks(cCtx *cli.Context) error {
 		}
 		cursor = *blocks.Cursor
 	}
-	return nil
+	return out.close()
 }
 
 func doLogin(cCtx *cli.Context) error {
@@ -892,52 +1113,93 @@ func doNotification(cCtx *cli.Context) error {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
-	xrpcc, err := makeXRPCC(cCtx)
+	newOut := newOutput
+	if cCtx.Bool("f") {
+		newOut = newFollowOutput
+	}
+	out, err := newOut(cCtx)
 	if err != nil {
-		return fmt.Errorf("cannot create client: %w", err)
+		return err
 	}
 
-	notifs, err := bsky.NotificationListNotifications(context.TODO(), xrpcc, "", 50, false, nil, "")
+	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot create client: %w", err)
 	}
 
-	if cCtx.Bool("json") {
+	var seen map[string]bool
+	poll := func() (int, error) {
+		notifs, err := bsky.NotificationListNotifications(context.TODO(), xrpcc, "", 50, false, nil, "")
+		if err != nil {
+			return 0, err
+		}
+
+		// only the notifications in the latest page can appear again.
+		page := map[string]bool{}
+		var items []*bsky.NotificationListNotifications_Notification
 		for _, n := range notifs.Notifications {
-			json.NewEncoder(os.Stdout).Encode(n)
+			page[n.Uri] = true
+			if seen == nil {
+				if out != nil || cCtx.Bool("a") || !n.IsRead {
+					items = append(items, n)
+				}
+			} else if !seen[n.Uri] {
+				// new notifications are printed oldest first like tail -f.
+				items = append([]*bsky.NotificationListNotifications_Notification{n}, items...)
+			}
 		}
-		return nil
-	}
+		seen = page
 
-	for _, n := range notifs.Notifications {
-		if !cCtx.Bool("a") && n.IsRead {
-			continue
+		if out != nil {
+			for _, n := range items {
+				if err := out.writeAs(n, newNotificationData(n)); err != nil {
+					return 0, err
+				}
+			}
+			return len(items), out.flush()
 		}
-		color.Set(color.FgHiRed)
-		fmt.Print(n.Author.Handle)
-		color.Set(color.Reset)
-		fmt.Printf(" [%s] ", stringp(n.Author.DisplayName))
-		color.Set(color.FgBlue)
-		fmt.Println(n.Author.Did)
-		color.Set(color.Reset)
-
-		switch v := n.Record.Val.(type) {
-		case *bsky.FeedPost:
-			fmt.Println(" " + n.Reason + " to " + n.Uri)
-		case *bsky.FeedRepost:
-			fmt.Printf(" reposted %s\n", v.Subject.Uri)
-		case *bsky.FeedLike:
-			fmt.Printf(" liked %s\n", v.Subject.Uri)
-		case *bsky.GraphFollow:
-			fmt.Println(" followed you")
-		}
-
-		bsky.NotificationUpdateSeen(context.TODO(), xrpcc, &bsky.NotificationUpdateSeen_Input{
-			SeenAt: time.Now().Local().Format(time.RFC3339),
-		})
+
+		for _, n := range items {
+			printNotification(n)
+		}
+		if len(items) > 0 {
+			bsky.NotificationUpdateSeen(context.TODO(), xrpcc, &bsky.NotificationUpdateSeen_Input{
+				SeenAt: time.Now().Local().Format(time.RFC3339),
+			})
+		}
+		return len(items), nil
 	}
 
-	return nil
+	if cCtx.Bool("f") {
+		err = follow(cCtx, xrpcc, poll)
+	} else {
+		_, err = poll()
+	}
+	if err != nil {
+		return err
+	}
+	return out.close()
+}
+
+func printNotification(n *bsky.NotificationListNotifications_Notification) {
+	color.Set(color.FgHiRed)
+	fmt.Print(n.Author.Handle)
+	color.Set(color.Reset)
+	fmt.Printf(" [%s] ", stringp(n.Author.DisplayName))
+	color.Set(color.FgBlue)
+	fmt.Println(n.Author.Did)
+	color.Set(color.Reset)
+
+	switch v := n.Record.Val.(type) {
+	case *bsky.FeedPost:
+		fmt.Println(" " + n.Reason + " to " + n.Uri)
+	case *bsky.FeedRepost:
+		fmt.Printf(" reposted %s\n", v.Subject.Uri)
+	case *bsky.FeedLike:
+		fmt.Printf(" liked %s\n", v.Subject.Uri)
+	case *bsky.GraphFollow:
+		fmt.Println(" followed you")
+	}
 }
 
 func doShowSession(cCtx *cli.Context) error {
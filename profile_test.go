@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestSearchActors(t *testing.T) {
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"cursor":"2","actors":[{"did":"did:plc:a","handle":"a.test"},{"did":"did:plc:b","handle":"b.test"}]}`))
+		default:
+			w.Write([]byte(`{"cursor":"3","actors":[{"did":"did:plc:c","handle":"c.test"},{"did":"did:plc:d","handle":"d.test"}]}`))
+		}
+	}))
+	defer ts.Close()
+	xrpcc := &xrpc.Client{Client: ts.Client(), Host: ts.URL}
+
+	dids, err := searchActors(context.Background(), xrpcc, "test", 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(dids, ",") != "did:plc:a,did:plc:b,did:plc:c" {
+		t.Fatalf("want 3 actors over 2 pages but got %v", dids)
+	}
+	if len(queries) != 2 || !strings.Contains(queries[1], "limit=1") {
+		t.Fatalf("want the second page limited to the rest but got %q", queries)
+	}
+}
+
+func TestGetProfiles(t *testing.T) {
+	m := &mockXRPC{responses: map[string]string{
+		"app.bsky.actor.getProfiles": `{"profiles":[
+			{"did":"did:plc:b","handle":"b.test","followersCount":2},
+			{"did":"did:plc:a","handle":"a.test","followersCount":1}]}`,
+	}, calls: map[string][]string{}}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	xrpcc := &xrpc.Client{Client: ts.Client(), Host: ts.URL}
+
+	dids := make([]string, 30)
+	for i := range dids {
+		dids[i] = "did:plc:x"
+	}
+	dids[0], dids[1] = "did:plc:a", "did:plc:b"
+	profiles, err := getProfiles(context.Background(), xrpcc, dids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[0].Handle != "a.test" || int64p(profiles[1].FollowersCount) != 2 {
+		t.Fatalf("want the profiles in the order of the search but got %v", profiles)
+	}
+	if n := len(m.calls["app.bsky.actor.getProfiles"]); n != 2 {
+		t.Fatalf("want 2 requests of up to 25 actors but got %d", n)
+	}
+}
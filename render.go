@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/rivo/uniseg"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+// minRenderWidth is the narrowest width which posts are wrapped to.
+const minRenderWidth = 20
+
+// renderer renders posts for the terminal. Colors are disabled when NO_COLOR
+// is set or the output is not a terminal, and hyperlinks are written as OSC 8
+// escape sequences only to a terminal.
+type renderer struct {
+	w     io.Writer
+	width int
+	color bool
+	links bool
+	now   time.Time
+
+	// images is set to show images inline with --images.
+	images *imagePreviewer
+}
+
+func newRenderer(cCtx *cli.Context) *renderer {
+	tty := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+	r := &renderer{
+		w:     color.Output,
+		width: terminalWidth(),
+		color: !color.NoColor,
+		links: tty && os.Getenv("TERM") != "dumb",
+		now:   time.Now(),
+	}
+	if cCtx.Bool("images") && r.color {
+		r.images = newImagePreviewer()
+	}
+	return r
+}
+
+// terminalWidth returns the width of the terminal, or $COLUMNS, or 80.
+func terminalWidth() int {
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		return w
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// relativeTime returns t relative to now like "5m ago". Times older than a
+// week are printed as dates.
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case t.IsZero():
+		return ""
+	case d < -time.Minute:
+		return t.Local().Format("2006-01-02 15:04")
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	case d < 7*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+// span is a part of a rendered line.
+type span struct {
+	text  string
+	url   string
+	attrs []color.Attribute
+}
+
+func (r *renderer) style(s string, attrs ...color.Attribute) string {
+	if !r.color || len(attrs) == 0 || s == "" {
+		return s
+	}
+	c := color.New(attrs...)
+	c.EnableColor()
+	return c.Sprint(s)
+}
+
+// linkable reports whether u can be a hyperlink. The URL is embedded in the
+// escape sequence as it is, so the control characters which would end the
+// sequence are refused, and only http, https and at URLs are linked.
+func linkable(u string) bool {
+	for _, c := range u {
+		if c < 0x20 || (c >= 0x7f && c < 0xa0) {
+			return false
+		}
+	}
+	scheme, _, ok := strings.Cut(u, ":")
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "at":
+		return true
+	}
+	return false
+}
+
+func (r *renderer) link(s, u string) string {
+	if !r.links || s == "" || !linkable(u) {
+		return s
+	}
+	return "\x1b]8;;" + u + "\x1b\\" + s + "\x1b]8;;\x1b\\"
+}
+
+// stripControls removes the control characters except newlines from s. The
+// texts, the names and the handles are written by anyone, and must not send
+// escape sequences to the terminal.
+func stripControls(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c == '\n':
+			return c
+		case c == '\t':
+			return ' '
+		case c < 0x20 || (c >= 0x7f && c < 0xa0):
+			return -1
+		}
+		return c
+	}, s)
+}
+
+func (r *renderer) span(s span) string {
+	return r.link(r.style(stripControls(s.text), s.attrs...), s.url)
+}
+
+// escapeRe matches CSI, OSC, DCS and APC escape sequences, and saving and
+// restoring the cursor.
+var escapeRe = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[P_][^\x1b]*\x1b\\|\x1b[78]`)
+
+// visibleWidth returns the number of cells which s occupies in the terminal.
+func visibleWidth(s string) int {
+	return uniseg.StringWidth(escapeRe.ReplaceAllString(s, ""))
+}
+
+// cutWidth splits s at the last grapheme boundary which fits in width.
+func cutWidth(s string, width int) (string, string) {
+	w := 0
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		gw := g.Width()
+		if w+gw > width {
+			from, _ := g.Positions()
+			return s[:from], s[from:]
+		}
+		w += gw
+	}
+	return s, ""
+}
+
+// wrap word-wraps spans to width. Spaces are collapsed and words longer than
+// width are broken.
+func (r *renderer) wrap(spans []span, width int) []string {
+	width = max(width, 1)
+	var (
+		lines     []string
+		line      string
+		lineWidth int
+		word      []span
+		wordWidth int
+	)
+	emit := func() {
+		lines = append(lines, line)
+		line, lineWidth = "", 0
+	}
+	addWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		if lineWidth > 0 {
+			if lineWidth+1+wordWidth > width {
+				emit()
+			} else {
+				line += " "
+				lineWidth++
+			}
+		}
+		for _, s := range word {
+			for s.text != "" {
+				if w := uniseg.StringWidth(s.text); lineWidth+w <= width {
+					line += r.span(s)
+					lineWidth += w
+					break
+				}
+				head, rest := cutWidth(s.text, width-lineWidth)
+				if head == "" && lineWidth == 0 {
+					// a grapheme wider than the line
+					head, rest, _, _ = uniseg.FirstGraphemeClusterInString(s.text, -1)
+				}
+				if head != "" {
+					line += r.span(span{text: head, url: s.url, attrs: s.attrs})
+					lineWidth += uniseg.StringWidth(head)
+				}
+				emit()
+				s.text = rest
+			}
+		}
+		word, wordWidth = nil, 0
+	}
+
+	for _, s := range spans {
+		for i, para := range strings.Split(stripControls(s.text), "\n") {
+			if i > 0 {
+				addWord()
+				emit()
+			}
+			for j, f := range strings.Split(para, " ") {
+				if j > 0 {
+					addWord()
+				}
+				if f != "" {
+					word = append(word, span{text: f, url: s.url, attrs: s.attrs})
+					wordWidth += uniseg.StringWidth(f)
+				}
+			}
+		}
+	}
+	addWord()
+	if lineWidth > 0 {
+		emit()
+	}
+	return lines
+}
+
+// box draws a frame of width around lines.
+func (r *renderer) box(lines []string, width int) []string {
+	inner := max(width-4, 1)
+	border := strings.Repeat("─", inner+2)
+	bar := r.style("│", color.Faint)
+	boxed := []string{r.style("╭"+border+"╮", color.Faint)}
+	for _, l := range lines {
+		pad := max(inner-visibleWidth(l), 0)
+		boxed = append(boxed, bar+" "+l+strings.Repeat(" ", pad)+" "+bar)
+	}
+	return append(boxed, r.style("╰"+border+"╯", color.Faint))
+}
+
+// textSpans returns the spans of the text with links, mentions and hashtags.
+func textSpans(text string, facets []*bsky.RichtextFacet) []span {
+	var spans []span
+	for _, seg := range facetSegments(text, facets) {
+		s := span{text: seg.Text, url: seg.URL}
+		switch {
+		case strings.HasPrefix(seg.URL, "https://bsky.app/profile/"):
+			s.attrs = []color.Attribute{color.Bold}
+		case seg.URL != "":
+			s.attrs = []color.Attribute{color.Underline}
+		}
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+// postLines renders the header, the text and the embeds of e in width.
+// Quoted posts are rendered in boxes.
+func (r *renderer) postLines(e *exportPost, width int) []string {
+	width = max(width, minRenderWidth)
+	if e.Note != "" {
+		return r.wrap([]span{{text: e.Note, attrs: []color.Attribute{color.Faint}}}, width)
+	}
+
+	header := []span{{text: e.Handle, url: webURL(e.Uri, e.Handle), attrs: []color.Attribute{color.FgHiRed}}}
+	if e.DisplayName != "" {
+		header = append(header, span{text: " [" + e.DisplayName + "]"})
+	}
+	if t := relativeTime(e.CreatedAt, r.now); t != "" {
+		header = append(header, span{text: " · "}, span{text: t, url: webURL(e.Uri, e.Handle), attrs: []color.Attribute{color.Faint}})
+	}
+	lines := r.wrap(header, width)
+	lines = append(lines, r.wrap(textSpans(e.Text, e.Facets), width)...)
+
+	for _, img := range e.Images {
+		alt := img.Alt
+		if alt == "" {
+			alt = "image"
+		}
+		lines = append(lines, r.wrap([]span{{text: "🖼 "}, {text: alt, url: img.Fullsize, attrs: []color.Attribute{color.FgCyan}}}, width)...)
+		if r.images != nil {
+			lines = append(lines, r.images.lines(img.Thumb, width)...)
+		}
+	}
+	if e.Video != nil {
+		alt := stringp(e.Video.Alt)
+		if alt == "" {
+			alt = "video"
+		}
+		lines = append(lines, r.wrap([]span{{text: "🎬 "}, {text: alt, url: e.Video.Playlist, attrs: []color.Attribute{color.FgCyan}}}, width)...)
+	}
+	if ext := e.External; ext != nil {
+		title := ext.Title
+		if title == "" {
+			title = ext.Uri
+		}
+		card := []span{{text: "🔗 "}, {text: title, url: ext.Uri, attrs: []color.Attribute{color.Underline}}}
+		if u, err := url.Parse(ext.Uri); err == nil && u.Host != "" && title != ext.Uri {
+			card = append(card, span{text: " (" + u.Host + ")", attrs: []color.Attribute{color.Faint}})
+		}
+		lines = append(lines, r.wrap(card, width)...)
+	}
+	if e.Quote != nil {
+		lines = append(lines, r.box(r.postLines(e.Quote, width-4), width)...)
+	}
+	return lines
+}
+
+// post renders p with its counts and URIs.
+func (r *renderer) post(p *bsky.FeedDefs_PostView, width int) []string {
+	e := newExportPost(&threadNode{Uri: p.Uri, Post: p})
+	lines := r.postLines(e, width)
+	lines = append(lines, fmt.Sprintf(" 👍(%d)⚡(%d)↩️ (%d)",
+		int64p(p.LikeCount),
+		int64p(p.RepostCount),
+		int64p(p.ReplyCount),
+	))
+	if rec, ok := p.Record.Val.(*bsky.FeedPost); ok && rec.Reply != nil && rec.Reply.Parent != nil {
+		parent := rec.Reply.Parent.Uri
+		lines = append(lines, " > "+r.span(span{text: parent, url: webURL(parent, ""), attrs: []color.Attribute{color.FgBlue}}))
+	}
+	if p.Uri != "" {
+		lines = append(lines, " - "+r.span(span{text: p.Uri, url: webURL(p.Uri, p.Author.Handle), attrs: []color.Attribute{color.FgBlue}}))
+	}
+	return lines
+}
+
+// user renders the handle and the display name of an actor.
+func (r *renderer) user(handle string, displayName *string) string {
+	return r.span(span{text: handle, url: "https://bsky.app/profile/" + handle, attrs: []color.Attribute{color.FgHiRed}}) +
+		" [" + stripControls(stringp(displayName)) + "]"
+}
+
+func (r *renderer) println(lines ...string) {
+	for _, l := range lines {
+		fmt.Fprintln(r.w, l)
+	}
+}
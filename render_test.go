@@ -0,0 +1,179 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Time{}, ""},
+		{now.Add(-10 * time.Second), "just now"},
+		{now.Add(-5 * time.Minute), "5m ago"},
+		{now.Add(-3 * time.Hour), "3h ago"},
+		{now.Add(-50 * time.Hour), "2d ago"},
+		{now.Add(-30 * 24 * time.Hour), now.Add(-30 * 24 * time.Hour).Local().Format("2006-01-02")},
+	}
+	for _, test := range tests {
+		if got := relativeTime(test.t, now); got != test.want {
+			t.Errorf("want %q but got %q for %v", test.want, got, test.t)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	r := &renderer{}
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"hello world", 20, []string{"hello world"}},
+		{"hello  big world", 9, []string{"hello big", "world"}},
+		{"line1\n\nline2", 20, []string{"line1", "", "line2"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"日本語の文章です", 6, []string{"日本語", "の文章", "です"}},
+	}
+	for _, test := range tests {
+		got := r.wrap([]span{{text: test.text}}, test.width)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("want %q but got %q for %q", test.want, got, test.text)
+		}
+	}
+}
+
+func TestWrapStyled(t *testing.T) {
+	r := &renderer{color: true, links: true}
+	got := r.wrap([]span{{text: "see "}, {text: "example link", url: "https://example.com"}}, 8)
+	if len(got) != 3 {
+		t.Fatalf("want 3 lines but got %q", got)
+	}
+	for _, l := range got {
+		if w := visibleWidth(l); w > 8 {
+			t.Fatalf("want width <= 8 but got %d for %q", w, l)
+		}
+	}
+	if !strings.Contains(got[2], "\x1b]8;;https://example.com\x1b\\link") {
+		t.Fatalf("want hyperlink but got %q", got[2])
+	}
+}
+
+func TestRenderPost(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	p := &bsky.FeedDefs_PostView{
+		Uri:    "at://did:plc:xxx/app.bsky.feed.post/1",
+		Author: &bsky.ActorDefs_ProfileViewBasic{Handle: "mattn.jp"},
+		Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+			Text:      "look at this",
+			CreatedAt: now.Add(-2 * time.Hour).Format(time.RFC3339),
+		}},
+		Embed: &bsky.FeedDefs_PostView_Embed{
+			EmbedRecordWithMedia_View: &bsky.EmbedRecordWithMedia_View{
+				Media: &bsky.EmbedRecordWithMedia_View_Media{
+					EmbedImages_View: &bsky.EmbedImages_View{
+						Images: []*bsky.EmbedImages_ViewImage{{Alt: "a cat", Fullsize: "https://cdn.example.com/cat"}},
+					},
+				},
+				Record: &bsky.EmbedRecord_View{
+					Record: &bsky.EmbedRecord_View_Record{
+						EmbedRecord_ViewRecord: &bsky.EmbedRecord_ViewRecord{
+							Uri:    "at://did:plc:yyy/app.bsky.feed.post/2",
+							Author: &bsky.ActorDefs_ProfileViewBasic{Handle: "bob.test"},
+							Value:  &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "quoted"}},
+							Embeds: []*bsky.EmbedRecord_ViewRecord_Embeds_Elem{{
+								EmbedExternal_View: &bsky.EmbedExternal_View{
+									External: &bsky.EmbedExternal_ViewExternal{Title: "Example", Uri: "https://example.com/page"},
+								},
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// without colors and hyperlinks as when NO_COLOR is set or piped
+	r := &renderer{now: now}
+	got := strings.Join(r.post(p, 30), "\n")
+	want := strings.Join([]string{
+		"mattn.jp · 2h ago",
+		"look at this",
+		"🖼 a cat",
+		"╭────────────────────────────╮",
+		"│ bob.test                   │",
+		"│ quoted                     │",
+		"│ 🔗 Example (example.com)   │",
+		"╰────────────────────────────╯",
+		" 👍(0)⚡(0)↩️ (0)",
+		" - at://did:plc:xxx/app.bsky.feed.post/1",
+	}, "\n")
+	if got != want {
+		t.Fatalf("want\n%s\nbut got\n%s", want, got)
+	}
+	if strings.Contains(got, "\x1b") {
+		t.Fatalf("want no escape sequences but got %q", got)
+	}
+
+	r = &renderer{now: now, color: true, links: true}
+	got = strings.Join(r.post(p, 30), "\n")
+	if !strings.Contains(got, "\x1b]8;;https://bsky.app/profile/mattn.jp/post/1\x1b\\") {
+		t.Fatalf("want hyperlink to bsky.app but got %q", got)
+	}
+}
+
+func TestRendererLinkUnsafe(t *testing.T) {
+	r := &renderer{links: true}
+	for _, u := range []string{
+		"javascript:alert(1)",
+		"file:///etc/passwd",
+		"https://example.com/\x1b]8;;https://evil.example\x1b\\",
+		"https://example.com/\x07",
+		"https://example.com/\x7f",
+		"https://example.com/\u009c",
+		"example.com",
+	} {
+		if got := r.link("text", u); got != "text" {
+			t.Errorf("%q should not be linked but got %q", u, got)
+		}
+	}
+	for _, u := range []string{"https://example.com/?q=1", "HTTP://example.com", "at://did:plc:xxx/app.bsky.feed.post/1"} {
+		if got := r.link("text", u); got != "\x1b]8;;"+u+"\x1b\\text\x1b]8;;\x1b\\" {
+			t.Errorf("%q should be linked but got %q", u, got)
+		}
+	}
+}
+
+func TestRendererStripControls(t *testing.T) {
+	r := &renderer{now: time.Now(), links: true}
+	name := "evil\x1b[2J"
+	p := &bsky.FeedDefs_PostView{
+		Uri:    "at://did:plc:xxx/app.bsky.feed.post/1",
+		Author: &bsky.ActorDefs_ProfileViewBasic{Did: "did:plc:xxx", Handle: "mattn.jp\x07", DisplayName: &name},
+		Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+			Text:      "click \x1b]8;;https://evil.example\x1b\\here\x1b]8;;\x1b\\\r\nnext\u009b2J line",
+			CreatedAt: "2025-01-01T00:00:00Z",
+		}},
+	}
+	lines := r.post(p, 80)
+	lines = append(lines, r.user("mattn.jp\x1b[31m", &name))
+	for _, l := range lines {
+		// the only escape sequences are the hyperlinks of the renderer
+		l = regexp.MustCompile(`\x1b\]8;;https://bsky\.app/profile/[^\x1b]*\x1b\\`).ReplaceAllString(l, "")
+		l = strings.ReplaceAll(l, "\x1b]8;;\x1b\\", "")
+		if strings.ContainsAny(l, "\x1b\x07\r\u009b") {
+			t.Fatalf("want no control characters from the post but got %q", l)
+		}
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), "next2J line") {
+		t.Fatalf("want the text kept: %q", lines)
+	}
+}
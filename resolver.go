@@ -0,0 +1,224 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+const (
+	// profileCacheSize is the number of the profiles cached by the stream.
+	profileCacheSize = 10000
+	// profileCacheTTL is how long a profile is cached, to follow the
+	// changes of the handles and the display names.
+	profileCacheTTL = time.Hour
+	// profileMissTTL is how long an actor which is not found, or failed to
+	// be fetched, is not fetched again.
+	profileMissTTL = time.Minute
+	// profileWorkers is the number of the concurrent lookups.
+	profileWorkers = 4
+	// profileQueueSize is the number of the lookups waiting for the
+	// workers. The lookups are given up when the queue is full.
+	profileQueueSize = 1000
+	// profileInterval is the interval of the requests of all the workers.
+	profileInterval = 100 * time.Millisecond
+)
+
+// profileEntry is a profile in the cache. profile is nil for the actors
+// which are not found.
+type profileEntry struct {
+	did     string
+	profile *bsky.ActorDefs_ProfileViewBasic
+	expires time.Time
+}
+
+// profileResolver resolves DIDs to profiles for the stream. The profiles are
+// kept in an LRU cache, and the misses are fetched in batches by a bounded
+// pool of workers at a limited rate, so that a busy stream neither stalls on
+// the lookups nor floods the server with them.
+type profileResolver struct {
+	fetch func(context.Context, []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error)
+	size  int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	pending map[string]chan struct{}
+	queue   chan string
+}
+
+func newProfileResolver(fetch func(context.Context, []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error), size, queue int) *profileResolver {
+	return &profileResolver{
+		fetch:   fetch,
+		size:    size,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		pending: map[string]chan struct{}{},
+		queue:   make(chan string, queue),
+	}
+}
+
+// start starts the workers until ctx is done. The workers share one
+// request per interval. interval 0 does not limit the rate.
+func (r *profileResolver) start(ctx context.Context, workers int, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		context.AfterFunc(ctx, ticker.Stop)
+		tick = ticker.C
+	}
+	for range workers {
+		go r.work(ctx, tick)
+	}
+}
+
+func (r *profileResolver) work(ctx context.Context, tick <-chan time.Time) {
+	for {
+		var did string
+		select {
+		case <-ctx.Done():
+			return
+		case did = <-r.queue:
+		}
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+
+		dids := []string{did}
+	batch:
+		for len(dids) < maxGetProfiles {
+			select {
+			case did := <-r.queue:
+				dids = append(dids, did)
+			default:
+				break batch
+			}
+		}
+		profiles, err := r.fetch(ctx, dids)
+		if err != nil {
+			profiles = nil
+		}
+		r.store(dids, profiles)
+	}
+}
+
+// resolve returns the profile of did. When it is not cached, resolve waits
+// for the lookup up to wait, and returns nil when the lookup is not done in
+// time, the actor is not found, or the queue is full. With wait 0, resolve
+// only queues the lookup, so that the next events of did find the profile.
+func (r *profileResolver) resolve(did string, wait time.Duration) *bsky.ActorDefs_ProfileViewBasic {
+	r.mu.Lock()
+	if p, ok := r.cached(did); ok {
+		r.mu.Unlock()
+		return p
+	}
+	done, ok := r.pending[did]
+	if !ok {
+		select {
+		case r.queue <- did:
+			done = make(chan struct{})
+			r.pending[did] = done
+		default:
+			r.mu.Unlock()
+			return nil
+		}
+	}
+	r.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, _ := r.cached(did)
+	return p
+}
+
+// cached returns the cached profile of did. r.mu must be held.
+func (r *profileResolver) cached(did string) (*bsky.ActorDefs_ProfileViewBasic, bool) {
+	e, ok := r.entries[did]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*profileEntry)
+	if r.now().After(entry.expires) {
+		r.lru.Remove(e)
+		delete(r.entries, did)
+		return nil, false
+	}
+	r.lru.MoveToFront(e)
+	return entry.profile, true
+}
+
+// store caches the profiles fetched for dids, evicting the least recently
+// used ones, and wakes up the lookups waiting for them.
+func (r *profileResolver) store(dids []string, profiles []*bsky.ActorDefs_ProfileViewDetailed) {
+	found := map[string]*bsky.ActorDefs_ProfileViewDetailed{}
+	for _, p := range profiles {
+		found[p.Did] = p
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for _, did := range dids {
+		entry := &profileEntry{did: did, expires: now.Add(profileMissTTL)}
+		if p, ok := found[did]; ok {
+			entry.profile = basicProfile(p)
+			entry.expires = now.Add(profileCacheTTL)
+		}
+		if e, ok := r.entries[did]; ok {
+			e.Value = entry
+			r.lru.MoveToFront(e)
+		} else {
+			r.entries[did] = r.lru.PushFront(entry)
+		}
+		for r.lru.Len() > r.size {
+			e := r.lru.Back()
+			r.lru.Remove(e)
+			delete(r.entries, e.Value.(*profileEntry).did)
+		}
+		if done, ok := r.pending[did]; ok {
+			close(done)
+			delete(r.pending, did)
+		}
+	}
+}
+
+// forget drops the cached profile of did, for the changes of the handle.
+func (r *profileResolver) forget(did string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[did]; ok {
+		r.lru.Remove(e)
+		delete(r.entries, did)
+	}
+}
+
+func basicProfile(p *bsky.ActorDefs_ProfileViewDetailed) *bsky.ActorDefs_ProfileViewBasic {
+	return &bsky.ActorDefs_ProfileViewBasic{
+		Avatar:      p.Avatar,
+		Did:         p.Did,
+		DisplayName: p.DisplayName,
+		Handle:      p.Handle,
+		Labels:      p.Labels,
+		Viewer:      p.Viewer,
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// fakeProfiles returns a fetch of the resolver which finds the DIDs except
+// did:plc:unknown, and records the batches.
+func fakeProfiles() (func(context.Context, []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error), func() [][]string) {
+	var mu sync.Mutex
+	var batches [][]string
+	fetch := func(ctx context.Context, dids []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error) {
+		mu.Lock()
+		batches = append(batches, dids)
+		mu.Unlock()
+		var profiles []*bsky.ActorDefs_ProfileViewDetailed
+		for _, did := range dids {
+			if did != "did:plc:unknown" {
+				profiles = append(profiles, &bsky.ActorDefs_ProfileViewDetailed{Did: did, Handle: did[len("did:plc:"):] + ".test"})
+			}
+		}
+		return profiles, nil
+	}
+	return fetch, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return batches
+	}
+}
+
+func TestProfileResolver(t *testing.T) {
+	fetch, batches := fakeProfiles()
+	r := newProfileResolver(fetch, 2, 10)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.start(ctx, 1, 0)
+
+	if p := r.resolve("did:plc:a", time.Second); p == nil || p.Handle != "a.test" {
+		t.Fatalf("want a.test but got %v", p)
+	}
+	r.resolve("did:plc:a", time.Second)
+	if got := len(batches()); got != 1 {
+		t.Fatalf("want the profile cached but got %d fetches", got)
+	}
+	if p := r.resolve("did:plc:unknown", time.Second); p != nil {
+		t.Fatalf("want nil for the unknown actor but got %v", p)
+	}
+	r.resolve("did:plc:unknown", time.Second)
+	if got := len(batches()); got != 2 {
+		t.Fatalf("want the miss cached but got %d fetches", got)
+	}
+
+	r.resolve("did:plc:b", time.Second)
+	if _, ok := r.entries["did:plc:a"]; ok {
+		t.Fatal("want did:plc:a evicted as the least recently used")
+	}
+	now = now.Add(profileMissTTL + time.Second)
+	r.resolve("did:plc:unknown", time.Second)
+	if got := len(batches()); got != 4 {
+		t.Fatalf("want the expired miss fetched again but got %d fetches", got)
+	}
+}
+
+func TestProfileResolverBatch(t *testing.T) {
+	fetch, batches := fakeProfiles()
+	r := newProfileResolver(fetch, 100, 100)
+	for i := range 30 {
+		if p := r.resolve(fmt.Sprintf("did:plc:%d", i), 0); p != nil {
+			t.Fatalf("want nil before the workers start but got %v", p)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.start(ctx, 1, 0)
+	if p := r.resolve("did:plc:29", time.Second); p == nil || p.Handle != "29.test" {
+		t.Fatalf("want 29.test but got %v", p)
+	}
+	got := batches()
+	if len(got) != 2 || len(got[0]) != maxGetProfiles || len(got[1]) != 5 {
+		t.Fatalf("want the queue fetched in 2 batches but got %v", got)
+	}
+}
+
+func TestProfileResolverQueueFull(t *testing.T) {
+	fetch, batches := fakeProfiles()
+	r := newProfileResolver(fetch, 10, 1)
+	start := time.Now()
+	r.resolve("did:plc:a", 0)
+	if p := r.resolve("did:plc:b", time.Minute); p != nil {
+		t.Fatalf("want nil when the queue is full but got %v", p)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("should not wait when the queue is full")
+	}
+	if len(batches()) != 0 {
+		t.Fatal("should not fetch without the workers")
+	}
+}
+
+func TestStreamHandlerNoWait(t *testing.T) {
+	// the lookups never finish
+	fetch := func(ctx context.Context, dids []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	set := flag.NewFlagSet("stream", flag.ContinueOnError)
+	set.Bool("json", false, "")
+	h := &streamHandler{
+		cCtx:     cli.NewContext(cli.NewApp(), set, nil),
+		profiles: newProfileResolver(fetch, 10, 10),
+	}
+	h.start(t.Context())
+
+	start := time.Now()
+	b := captureStdout(t, func() {
+		for i := range 5 {
+			ev := &streamEvent{Kind: streamKindCommit, Did: fmt.Sprintf("did:plc:%d", i), Rec: &bsky.FeedPost{Text: "hello"}}
+			if err := h.handle(ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("the stream should not wait for the lookups but took %v", d)
+	}
+	if !strings.Contains(string(b), "did:plc:4") {
+		t.Fatalf("want the post printed with the DID but got %q", b)
+	}
+}
+
+func BenchmarkProfileResolver(b *testing.B) {
+	m := &mockXRPC{responses: map[string]string{
+		"app.bsky.actor.getProfiles": `{"profiles":[{"did":"did:plc:a","handle":"a.test"}]}`,
+	}, calls: map[string][]string{}}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	xrpcc := &xrpc.Client{Client: ts.Client(), Host: ts.URL}
+	fetch := func(ctx context.Context, dids []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error) {
+		return getProfiles(ctx, xrpcc, dids)
+	}
+
+	b.Run("hit", func(b *testing.B) {
+		r := newProfileResolver(fetch, profileCacheSize, profileQueueSize)
+		r.start(b.Context(), profileWorkers, 0)
+		r.resolve("did:plc:a", time.Second)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				r.resolve("did:plc:a", time.Second)
+			}
+		})
+	})
+	b.Run("miss", func(b *testing.B) {
+		r := newProfileResolver(fetch, profileCacheSize, profileQueueSize)
+		r.start(b.Context(), profileWorkers, 0)
+		for i := 0; b.Loop(); i++ {
+			r.resolve(fmt.Sprintf("did:plc:%d", i), 0)
+		}
+	})
+	// the stream printing the posts of the authors not cached
+	b.Run("stream", func(b *testing.B) {
+		set := flag.NewFlagSet("stream", flag.ContinueOnError)
+		set.Bool("json", false, "")
+		h := &streamHandler{
+			cCtx:     cli.NewContext(cli.NewApp(), set, nil),
+			profiles: newProfileResolver(fetch, profileCacheSize, profileQueueSize),
+		}
+		h.start(b.Context())
+		output := color.Output
+		color.Output = io.Discard
+		defer func() { color.Output = output }()
+		for i := 0; b.Loop(); i++ {
+			ev := &streamEvent{Kind: streamKindCommit, Did: fmt.Sprintf("did:plc:%d", i), Rec: &bsky.FeedPost{Text: "hello"}}
+			if err := h.handle(ev); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
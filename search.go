@@ -1,39 +1,177 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
 )
 
-func doSearch(cCtx *cli.Context) error {
-	if !cCtx.Args().Present() {
-		return cli.ShowSubcommandHelp(cCtx)
+// searchQuery is the parameters of app.bsky.feed.searchPosts. Since and
+// Until are RFC3339 timestamps.
+type searchQuery struct {
+	Q        string   `json:"q"`
+	Author   string   `json:"author,omitempty"`
+	Mentions string   `json:"mentions,omitempty"`
+	Lang     string   `json:"lang,omitempty"`
+	Domain   string   `json:"domain,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Since    string   `json:"since,omitempty"`
+	Until    string   `json:"until,omitempty"`
+	Sort     string   `json:"sort,omitempty"`
+}
+
+// searchQueryFlags are the flags of the parameters of searchPosts.
+var searchQueryFlags = []string{"author", "mentions", "lang", "domain", "url", "tag", "since", "until", "sort"}
+
+// searchFlags returns the flags of the parameters of searchPosts.
+func searchFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "author", Usage: "only posts by the handle or DID (from:)"},
+		&cli.StringFlag{Name: "mentions", Usage: "only posts mentioning the handle or DID (mentions:)"},
+		&cli.StringFlag{Name: "lang", Usage: "only posts in the language (lang:)"},
+		&cli.StringFlag{Name: "domain", Usage: "only posts linking to the domain (domain:)"},
+		&cli.StringFlag{Name: "url", Usage: "only posts linking to the URL (url:)"},
+		&cli.StringSliceFlag{Name: "tag", Usage: "only posts with the hashtag (tag:)"},
+		&cli.StringFlag{Name: "since", Usage: "only posts after the date, time or duration ago like 7d (since:)"},
+		&cli.StringFlag{Name: "until", Usage: "only posts before the date, time or duration ago like 7d (until:)"},
+		&cli.StringFlag{Name: "sort", Usage: "sort order: top or latest (sort:)"},
 	}
+}
 
-	xrpcc, err := makeXRPCC(cCtx)
-	if err != nil {
-		return fmt.Errorf("cannot create client: %w", err)
+// searchTime converts a date, a RFC3339 timestamp or a duration ago like
+// "24h" or "7d" to a RFC3339 timestamp.
+func searchTime(s string, now time.Time) (string, error) {
+	if t, err := parseDate(s); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	unit := time.Duration(1)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		s, unit = days+"h", 24
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return "", fmt.Errorf("invalid time: %q", s)
 	}
+	return now.Add(-d * unit).UTC().Format(time.RFC3339), nil
+}
 
-	n := cCtx.Int64("n")
+// set sets the parameter of name, which is an inline operator or a flag. It
+// returns false for an unknown name.
+func (q *searchQuery) set(name, value string, now time.Time) (bool, error) {
+	var err error
+	switch name {
+	case "from", "author":
+		q.Author = strings.TrimPrefix(value, "@")
+	case "mentions", "to":
+		q.Mentions = strings.TrimPrefix(value, "@")
+	case "lang":
+		q.Lang = value
+	case "domain":
+		q.Domain = value
+	case "url":
+		q.URL = value
+	case "tag":
+		q.Tags = append(q.Tags, strings.TrimPrefix(value, "#"))
+	case "since":
+		q.Since, err = searchTime(value, now)
+	case "until":
+		q.Until, err = searchTime(value, now)
+	case "sort":
+		if value != "top" && value != "latest" {
+			err = fmt.Errorf("invalid sort: %q (must be top or latest)", value)
+		}
+		q.Sort = value
+	default:
+		return false, nil
+	}
+	return true, err
+}
 
-	terms := strings.Join(cCtx.Args().Slice(), " ")
+// parseSearchQuery takes inline operators like from:, since: and lang: out of
+// the terms. Other words are left in Q.
+func parseSearchQuery(terms string, now time.Time) (*searchQuery, error) {
+	q := &searchQuery{}
+	var words []string
+	for _, word := range strings.Fields(terms) {
+		if name, value, ok := strings.Cut(word, ":"); ok && value != "" {
+			found, err := q.set(name, value, now)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				continue
+			}
+		}
+		words = append(words, word)
+	}
+	q.Q = strings.Join(words, " ")
+	return q, nil
+}
 
-	var results []*bsky.FeedDefs_PostView
+// searchTerms returns the terms with the flags appended as inline operators.
+// Saved searches keep them as they are, so that relative times like since:7d
+// are resolved whenever the search runs.
+func searchTerms(cCtx *cli.Context, args []string) string {
+	terms := append([]string(nil), args...)
+	for _, name := range searchQueryFlags {
+		if !cCtx.IsSet(name) {
+			continue
+		}
+		values := []string{cCtx.String(name)}
+		if name == "tag" {
+			values = cCtx.StringSlice(name)
+		}
+		for _, v := range values {
+			if v != "" {
+				terms = append(terms, name+":"+v)
+			}
+		}
+	}
+	return strings.Join(terms, " ")
+}
 
-	var cursor string
+// newSearchQuery parses the terms of a search. The later operators override
+// the earlier ones, so the flags appended by searchTerms win.
+func newSearchQuery(terms string) (*searchQuery, error) {
+	q, err := parseSearchQuery(terms, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return q, q.normalize()
+}
+
+// normalize checks that q has something to search. searchPosts requires q,
+// so "*" matching any post is used when only the filters are given.
+func (q *searchQuery) normalize() error {
+	if q.Q != "" {
+		return nil
+	}
+	if len(q.Tags) == 0 && q.Author == "" && q.Mentions == "" && q.Domain == "" && q.URL == "" {
+		return fmt.Errorf("no search terms")
+	}
+	q.Q = "*"
+	return nil
+}
 
+// searchPosts returns more than n posts matching q if found.
+func searchPosts(ctx context.Context, xrpcc *xrpc.Client, q *searchQuery, n int64) ([]*bsky.FeedDefs_PostView, error) {
+	var results []*bsky.FeedDefs_PostView
+	var cursor string
 	for {
-		resp, err := bsky.FeedSearchPosts(context.TODO(), xrpcc, "", cursor, "", "", 100, "", terms, "", "", nil, "", "")
+		resp, err := bsky.FeedSearchPosts(ctx, xrpcc, q.Author, cursor, q.Domain, q.Lang, 100, q.Mentions, q.Q, q.Since, q.Sort, q.Tags, q.Until, q.URL)
 		if err != nil {
-			return fmt.Errorf("cannot perform search: %w", err)
+			return nil, fmt.Errorf("cannot perform search: %w", err)
 		}
 		if resp.Cursor != nil {
 			cursor = *resp.Cursor
@@ -46,27 +184,376 @@ func doSearch(cCtx *cli.Context) error {
 		if cursor == "" || int64(len(results)) > n {
 			break
 		}
+	}
+	return results, nil
+}
 
+// postTime returns when p was created, or indexed when the record is not a
+// post or has no valid createdAt.
+func postTime(p *bsky.FeedDefs_PostView) time.Time {
+	if p.Record != nil {
+		if rec, ok := p.Record.Val.(*bsky.FeedPost); ok {
+			if t, err := parseTime(rec.CreatedAt); err == nil {
+				return t
+			}
+		}
 	}
+	t, _ := parseTime(p.IndexedAt)
+	return t
+}
 
-	sort.Slice(results, func(i, j int) bool {
-		ri := timep(results[i].Record.Val.(*bsky.FeedPost).CreatedAt)
-		rj := timep(results[j].Record.Val.(*bsky.FeedPost).CreatedAt)
-		return ri.Before(rj)
+// limitSearchResults returns the last n results in time order, or the first
+// n results as ranked for the top sort.
+func limitSearchResults(results []*bsky.FeedDefs_PostView, q *searchQuery, n int64) []*bsky.FeedDefs_PostView {
+	if q.Sort == "top" {
+		if int64(len(results)) > n {
+			results = results[:n]
+		}
+		return results
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return postTime(results[i]).Before(postTime(results[j]))
 	})
 	if int64(len(results)) > n {
 		results = results[len(results)-int(n):]
 	}
+	return results
+}
 
-	if cCtx.Bool("json") {
+func doSearch(cCtx *cli.Context) error {
+	if !cCtx.Args().Present() && !hasSearchFlags(cCtx) {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	q, err := newSearchQuery(searchTerms(cCtx, cCtx.Args().Slice()))
+	if err != nil {
+		return err
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	n := cCtx.Int64("n")
+
+	results, err := searchPosts(context.TODO(), xrpcc, q, n)
+	if err != nil {
+		return err
+	}
+	return printSearchResultsOnce(cCtx, limitSearchResults(results, q, n))
+}
+
+// printSearchResultsOnce prints results with the output of the command.
+func printSearchResultsOnce(cCtx *cli.Context, results []*bsky.FeedDefs_PostView) error {
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+	if err := printSearchResults(cCtx, out, results); err != nil {
+		return err
+	}
+	return out.close()
+}
+
+// printSearchResults prints results to out, or as usual when out is nil. The
+// caller closes out.
+func printSearchResults(cCtx *cli.Context, out *output, results []*bsky.FeedDefs_PostView) error {
+	if out != nil {
 		for _, p := range results {
-			json.NewEncoder(os.Stdout).Encode(p)
+			if err := out.writeAs(p, newPostData(p)); err != nil {
+				return err
+			}
 		}
-	} else {
-		for _, p := range results {
-			printPost(p)
+		return out.flush()
+	}
+	r := newRenderer(cCtx)
+	for _, p := range results {
+		printPost(r, p)
+	}
+	return nil
+}
+
+func hasSearchFlags(cCtx *cli.Context) bool {
+	for _, name := range searchQueryFlags {
+		if cCtx.IsSet(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchFeed returns the posts matching q as a feed, so that new matches of a
+// saved search are found like new posts of a feed. The latest posts come
+// first whatever the sort of q is.
+func searchFeed(ctx context.Context, xrpcc *xrpc.Client, q *searchQuery) feedPage {
+	return func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+		resp, err := bsky.FeedSearchPosts(ctx, xrpcc, q.Author, cursor, q.Domain, q.Lang, 100, q.Mentions, q.Q, q.Since, "latest", q.Tags, q.Until, q.URL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot perform search: %w", err)
 		}
+		feed := make([]*bsky.FeedDefs_FeedViewPost, 0, len(resp.Posts))
+		for _, p := range resp.Posts {
+			feed = append(feed, &bsky.FeedDefs_FeedViewPost{Post: p})
+		}
+		return feed, resp.Cursor, nil
 	}
+}
+
+// savedSearchKey is the key of the marker of a saved search in the state.
+func savedSearchKey(name string) string {
+	return "search:" + name
+}
 
+func loadSavedSearch(cCtx *cli.Context, name string) (*searchQuery, error) {
+	st, err := loadState(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	terms, ok := st.Searches[name]
+	if !ok {
+		return nil, fmt.Errorf("no such saved search: %q", name)
+	}
+	return newSearchQuery(terms)
+}
+
+// runSearchHook runs the command given with --exec for a new match. The post
+// is passed as JSON on stdin, and its URI, author and text as environment
+// variables.
+func runSearchHook(command, name string, p *bsky.FeedDefs_PostView) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	cmd := shellCommand(command)
+	var text string
+	if rec, ok := p.Record.Val.(*bsky.FeedPost); ok {
+		text = rec.Text
+	}
+	cmd.Env = append(os.Environ(),
+		"BSKY_SEARCH="+name,
+		"BSKY_URI="+p.Uri,
+		"BSKY_CID="+p.Cid,
+		"BSKY_AUTHOR="+p.Author.Handle,
+		"BSKY_URL="+webURL(p.Uri, p.Author.Handle),
+		"BSKY_TEXT="+text,
+	)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cannot run hook for %s: %w", p.Uri, err)
+	}
 	return nil
 }
+
+// newSearchWatcher returns the watcher of the new matches of the saved
+// search. They are printed, or passed to the hook with --exec. When a hook
+// fails, the marker is not moved, so the matches are tried again next time.
+func newSearchWatcher(cCtx *cli.Context, xrpcc *xrpc.Client, out *output, name string, q *searchQuery, label bool) (*feedWatcher, error) {
+	w, err := newFeedWatcher(cCtx, out, savedSearchKey(name), nil, true, searchFeed(context.TODO(), xrpcc, q))
+	if err != nil {
+		return nil, err
+	}
+	w.print = func(feed []*bsky.FeedDefs_FeedViewPost) error {
+		results := make([]*bsky.FeedDefs_PostView, 0, len(feed))
+		for _, p := range feed {
+			results = append(results, p.Post)
+		}
+		if command := cCtx.String("exec"); command != "" {
+			for _, p := range results {
+				if err := runSearchHook(command, name, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if label && len(results) > 0 && !cCtx.Bool("json") && cCtx.String("format") == "" && cCtx.String("template") == "" {
+			r := newRenderer(cCtx)
+			r.println(r.style("# "+name, color.Faint))
+		}
+		return printSearchResults(cCtx, out, results)
+	}
+	return w, nil
+}
+
+func doSearchSave(cCtx *cli.Context) error {
+	if !cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	name := cCtx.Args().First()
+	terms := searchTerms(cCtx, cCtx.Args().Tail())
+	if _, err := newSearchQuery(terms); err != nil {
+		return err
+	}
+	return updateState(cCtx, func(st *state) {
+		if st.Searches == nil {
+			st.Searches = map[string]string{}
+		}
+		if st.Searches[name] != terms {
+			// the matches of the old terms are not of the new ones
+			delete(st.Feeds, savedSearchKey(name))
+		}
+		st.Searches[name] = terms
+	})
+}
+
+// savedSearchData is a saved search listed with the newest match shown.
+type savedSearchData struct {
+	Name   string      `json:"name"`
+	Terms  string      `json:"terms"`
+	Marker *feedMarker `json:"marker,omitempty"`
+}
+
+func doSearchList(cCtx *cli.Context) error {
+	if cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	st, err := loadState(cCtx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(st.Searches))
+	for name := range st.Searches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if out != nil {
+			if err := out.write(&savedSearchData{
+				Name:   name,
+				Terms:  st.Searches[name],
+				Marker: st.Feeds[savedSearchKey(name)],
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		color.Set(color.FgHiRed)
+		fmt.Print(name)
+		color.Set(color.Reset)
+		fmt.Printf(" %s\n", st.Searches[name])
+	}
+	return out.close()
+}
+
+func doSearchDelete(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	name := cCtx.Args().First()
+	if _, err := loadSavedSearch(cCtx, name); err != nil {
+		return err
+	}
+	return updateState(cCtx, func(st *state) {
+		delete(st.Searches, name)
+		delete(st.Feeds, savedSearchKey(name))
+	})
+}
+
+func doSearchRun(cCtx *cli.Context) error {
+	if cCtx.Args().Len() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	name := cCtx.Args().First()
+	q, err := loadSavedSearch(cCtx, name)
+	if err != nil {
+		return err
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	if cCtx.Bool("new") {
+		out, err := newOutput(cCtx)
+		if err != nil {
+			return err
+		}
+		w, err := newSearchWatcher(cCtx, xrpcc, out, name, q, false)
+		if err != nil {
+			return err
+		}
+		if _, err := w.poll(); err != nil {
+			return err
+		}
+		return out.close()
+	}
+
+	n := cCtx.Int64("n")
+	results, err := searchPosts(context.TODO(), xrpcc, q, n)
+	if err != nil {
+		return err
+	}
+	results = limitSearchResults(results, q, n)
+	if command := cCtx.String("exec"); command != "" {
+		for _, p := range results {
+			if err := runSearchHook(command, name, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return printSearchResultsOnce(cCtx, results)
+}
+
+func doSearchWatch(cCtx *cli.Context) error {
+	names := cCtx.Args().Slice()
+	if len(names) == 0 {
+		st, err := loadState(cCtx)
+		if err != nil {
+			return err
+		}
+		for name := range st.Searches {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no saved searches")
+		}
+		sort.Strings(names)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	out, err := newFollowOutput(cCtx)
+	if err != nil {
+		return err
+	}
+	var watchers []*feedWatcher
+	for _, name := range names {
+		q, err := loadSavedSearch(cCtx, name)
+		if err != nil {
+			return err
+		}
+		w, err := newSearchWatcher(cCtx, xrpcc, out, name, q, len(names) > 1)
+		if err != nil {
+			return err
+		}
+		watchers = append(watchers, w)
+	}
+
+	err = follow(cCtx, xrpcc, func() (int, error) {
+		total := 0
+		for _, w := range watchers {
+			n, err := w.poll()
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		return total, nil
+	})
+	if err != nil {
+		return err
+	}
+	return out.close()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input string
+		want  searchQuery
+		err   bool
+	}{
+		{input: "golang vim", want: searchQuery{Q: "golang vim"}},
+		{
+			input: "from:@mattn.bsky.social vim lang:ja",
+			want:  searchQuery{Q: "vim", Author: "mattn.bsky.social", Lang: "ja"},
+		},
+		{
+			input: "tag:#golang tag:release to:alice.test",
+			want:  searchQuery{Mentions: "alice.test", Tags: []string{"golang", "release"}},
+		},
+		{
+			input: "since:2026-01-01T00:00:00Z until:2d sort:top news",
+			want:  searchQuery{Q: "news", Since: "2026-01-01T00:00:00Z", Until: "2026-01-08T12:00:00Z", Sort: "top"},
+		},
+		{
+			input: "see https://example.com/ note: time:12",
+			want:  searchQuery{Q: "see https://example.com/ note: time:12"},
+		},
+		{input: "sort:new", err: true},
+		{input: "since:yesterday", err: true},
+	}
+	for _, test := range tests {
+		got, err := parseSearchQuery(test.input, now)
+		if test.err {
+			if err == nil {
+				t.Fatalf("%q should be an error", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Fatalf("want %+v but got %+v for %q", test.want, *got, test.input)
+		}
+	}
+}
+
+func TestSearchQueryNormalize(t *testing.T) {
+	q := &searchQuery{Tags: []string{"golang"}}
+	if err := q.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if q.Q != "*" {
+		t.Fatalf("want * but got %q", q.Q)
+	}
+	q = &searchQuery{Lang: "ja"}
+	if err := q.normalize(); err == nil {
+		t.Fatal("a query with only lang should be an error")
+	}
+}
+
+const mockSearchPosts = `{"posts":[
+{"uri":"at://did:plc:alice/app.bsky.feed.post/3","cid":"cid3","indexedAt":"2026-01-03T00:00:00Z",
+ "author":{"did":"did:plc:alice","handle":"alice.test"},
+ "record":{"$type":"app.bsky.feed.post","text":"bsky is great","createdAt":"2026-01-03T00:00:00Z"}},
+{"uri":"at://did:plc:bob/app.bsky.feed.post/2","cid":"cid2","indexedAt":"2026-01-02T00:00:00Z",
+ "author":{"did":"did:plc:bob","handle":"bob.test"},
+ "record":{"$type":"app.bsky.feed.post","text":"using bsky","createdAt":"2026-01-02T00:00:00Z"}},
+{"uri":"at://did:plc:carol/app.bsky.feed.post/1","cid":"cid1","indexedAt":"2026-01-01T00:00:00Z",
+ "author":{"did":"did:plc:carol","handle":"carol.test"},
+ "record":{"$type":"app.bsky.feed.post","text":"what is bsky","createdAt":"2026-01-01T00:00:00Z"}}
+]}`
+
+func TestSearchFeed(t *testing.T) {
+	m := &mockXRPC{responses: map[string]string{"app.bsky.feed.searchPosts": mockSearchPosts}, calls: map[string][]string{}}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	xrpcc := &xrpc.Client{Client: ts.Client(), Host: ts.URL}
+
+	q := &searchQuery{Q: "bsky", Lang: "en", Sort: "top"}
+	marker := &feedMarker{Uri: "at://did:plc:carol/app.bsky.feed.post/1", IndexedAt: "2026-01-01T00:00:00Z"}
+	feed, marker, err := collectNewFeed(30, nil, marker, true, searchFeed(context.Background(), xrpcc, q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed) != 2 || feed[0].Post.Uri != "at://did:plc:alice/app.bsky.feed.post/3" {
+		t.Fatalf("want 2 new matches but got %d", len(feed))
+	}
+	if marker.Uri != "at://did:plc:alice/app.bsky.feed.post/3" {
+		t.Fatalf("want the newest match as the marker but got %q", marker.Uri)
+	}
+	query, err := url.ParseQuery(m.calls["app.bsky.feed.searchPosts"][0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("q") != "bsky" || query.Get("lang") != "en" || query.Get("sort") != "latest" {
+		t.Fatalf("want the latest matches of the query but got %v", query)
+	}
+}
+
+func TestRunSearchHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	var resp struct {
+		Posts []*bsky.FeedDefs_PostView `json:"posts"`
+	}
+	if err := json.Unmarshal([]byte(mockSearchPosts), &resp); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "hook.txt")
+	command := `echo "$BSKY_SEARCH $BSKY_AUTHOR $BSKY_TEXT" > ` + fn + `; cat >> ` + fn
+	if err := runSearchHook(command, "brand", resp.Posts[1]); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, rest, _ := strings.Cut(string(b), "\n")
+	if line != "brand bob.test using bsky" {
+		t.Fatalf("want the environment variables but got %q", line)
+	}
+	if !strings.Contains(rest, `"uri":"at://did:plc:bob/app.bsky.feed.post/2"`) {
+		t.Fatalf("want the post as JSON but got %q", rest)
+	}
+
+	if err := runSearchHook("exit 1", "brand", resp.Posts[1]); err == nil {
+		t.Fatal("a failed hook should be an error")
+	}
+}
+
+func TestLimitSearchResultsOtherRecords(t *testing.T) {
+	results := []*bsky.FeedDefs_PostView{
+		{Uri: "at://3", IndexedAt: "2025-01-03T00:00:00Z", Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{CreatedAt: "2025-01-03T00:00:00Z"}}},
+		// not a post
+		{Uri: "at://2", IndexedAt: "2025-01-02T00:00:00Z", Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedLike{}}},
+		// invalid createdAt
+		{Uri: "at://1", IndexedAt: "2025-01-01T00:00:00Z", Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{CreatedAt: "yesterday"}}},
+	}
+	got := limitSearchResults(results, &searchQuery{}, 2)
+	if len(got) != 2 || got[0].Uri != "at://2" || got[1].Uri != "at://3" {
+		t.Fatalf("want at://2 and at://3 by indexedAt, got %d results", len(got))
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/urfave/cli/v2"
+)
+
+// streamSink writes the events of the stream with --out or --exec. write
+// blocks until the event is taken, so that a slow sink slows down the stream
+// instead of losing the events. flush makes the events written so far
+// durable.
+type streamSink interface {
+	write(ev *streamEvent) error
+	flush() error
+	close() error
+}
+
+// newStreamSink returns the sink of --out and --exec, or nil when neither is
+// given. --out takes a JSONL file, or a SQLite database as sqlite:FILE.
+func newStreamSink(cCtx *cli.Context) (streamSink, error) {
+	maxSize, err := parseSize(cCtx.String("rotate-size"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sinks multiSink
+	for _, out := range cCtx.StringSlice("out") {
+		var sink streamSink
+		if name, ok := strings.CutPrefix(out, "sqlite:"); ok {
+			sink, err = newSQLiteSink(name)
+		} else {
+			sink, err = newJSONLSink(out, maxSize, cCtx.Duration("rotate-interval"), cCtx.Bool("gzip"))
+		}
+		if err != nil {
+			sinks.close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if command := cCtx.String("exec"); command != "" {
+		sink, err := newExecSink(command)
+		if err != nil {
+			sinks.close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return sinks, nil
+}
+
+// parseSize parses the size like 100M. The suffixes K, M and G are powers
+// of 1024.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n := strings.TrimSuffix(strings.ToUpper(s), "B")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(n, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(n, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(n, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		n = n[:len(n)-1]
+	}
+	size, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return size * unit, nil
+}
+
+type multiSink []streamSink
+
+func (m multiSink) write(ev *streamEvent) error {
+	for _, sink := range m {
+		if err := sink.write(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiSink) flush() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.flush())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) close() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.close())
+	}
+	return errors.Join(errs...)
+}
+
+// streamOutput passes the events matched to the sink. It keeps the cursor
+// of the last event passed, which is saved with --resume only after the
+// sink is flushed. Once the sink fails, the following events are refused,
+// so that the stream stops at the event not written.
+type streamOutput struct {
+	sink   streamSink
+	cursor atomic.Int64
+
+	mu  sync.Mutex
+	err error
+}
+
+func (o *streamOutput) write(ev *streamEvent, matched bool) error {
+	if err := o.failed(); err != nil {
+		return err
+	}
+	if matched {
+		if err := o.sink.write(ev); err != nil {
+			return o.fail(fmt.Errorf("cannot write event %d: %w", ev.Seq, err))
+		}
+	}
+	// the other ops of the same commit may follow with the same seq, so
+	// the stream is resumed from the event itself
+	o.cursor.Store(ev.Seq - 1)
+	return nil
+}
+
+// flush flushes the sink, and returns the cursor of the events flushed.
+func (o *streamOutput) flush() (int64, error) {
+	if err := o.failed(); err != nil {
+		return 0, err
+	}
+	cursor := o.cursor.Load()
+	if err := o.sink.flush(); err != nil {
+		return 0, o.fail(fmt.Errorf("cannot flush events: %w", err))
+	}
+	return cursor, nil
+}
+
+func (o *streamOutput) fail(err error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err == nil {
+		o.err = err
+	}
+	return o.err
+}
+
+func (o *streamOutput) failed() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+// jsonlSink writes the events to a JSONL file as written with --json. With
+// maxSize or interval, the file is rotated to a new one named with the time,
+// like events-20260101-150405.jsonl, or events-20260101-150405-1.jsonl when
+// the file of the second exists. maxSize is the size before compression.
+type jsonlSink struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	gzip     bool
+	now      func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	gz     *gzip.Writer
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+func newJSONLSink(path string, maxSize int64, interval time.Duration, gz bool) (*jsonlSink, error) {
+	s := &jsonlSink{
+		path:     path,
+		maxSize:  maxSize,
+		interval: interval,
+		gzip:     gz || strings.HasSuffix(path, ".gz"),
+		now:      time.Now,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *jsonlSink) rotates() bool {
+	return s.maxSize > 0 || s.interval > 0
+}
+
+// name returns the name of the file opened at t. n is the sequence number
+// of the files rotated in the same second.
+func (s *jsonlSink) name(t time.Time, n int) string {
+	name := strings.TrimSuffix(s.path, ".gz")
+	if s.rotates() {
+		ext := filepath.Ext(name)
+		suffix := "-" + t.Format("20060102-150405")
+		if n > 0 {
+			suffix += "-" + strconv.Itoa(n)
+		}
+		name = strings.TrimSuffix(name, ext) + suffix + ext
+	}
+	if s.gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// open opens the file. Without rotation, the events are appended to the
+// file. The rotated files are always new, because appending to a gzip file
+// written by another run, or to the file just rotated, breaks the order of
+// the files.
+func (s *jsonlSink) open() error {
+	s.opened = s.now()
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if s.rotates() {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	f, err := os.OpenFile(s.name(s.opened, 0), flag, 0644)
+	for n := 1; s.rotates() && errors.Is(err, fs.ErrExist); n++ {
+		f, err = os.OpenFile(s.name(s.opened, n), flag, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open output file: %w", err)
+	}
+	s.f = f
+	s.size = 0
+	if s.gzip {
+		s.gz = gzip.NewWriter(f)
+		s.w = bufio.NewWriter(s.gz)
+	} else {
+		s.w = bufio.NewWriter(f)
+	}
+	return nil
+}
+
+func (s *jsonlSink) closeFile() error {
+	err := s.w.Flush()
+	if s.gz != nil {
+		err = errors.Join(err, s.gz.Close())
+		s.gz = nil
+	}
+	return errors.Join(err, s.f.Close())
+}
+
+func (s *jsonlSink) write(ev *streamEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if (s.maxSize > 0 && s.size > 0 && s.size+int64(len(b))+1 > s.maxSize) ||
+		(s.interval > 0 && s.now().Sub(s.opened) >= s.interval) {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	n, err := s.w.Write(append(b, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+func (s *jsonlSink) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.gz != nil {
+		return s.gz.Flush()
+	}
+	return nil
+}
+
+func (s *jsonlSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFile()
+}
+
+// sqliteBatchSize is the number of the events inserted in a transaction.
+const sqliteBatchSize = 1000
+
+// sqliteSink inserts the events to a SQLite database, in a table per
+// collection like app_bsky_feed_post, and in the tables identity, account
+// and sync for the changes of the accounts. data is the event as written
+// with --json.
+type sqliteSink struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	tx      *sql.Tx
+	stmts   map[string]*sql.Stmt
+	pending int
+}
+
+func newSQLiteSink(name string) (*sqliteSink, error) {
+	db, err := sql.Open("sqlite3", "file:"+name+"?_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("cannot open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open database: %w", err)
+	}
+	return &sqliteSink{db: db, stmts: map[string]*sql.Stmt{}}, nil
+}
+
+// sqliteTable returns the name of the table of the event.
+func sqliteTable(ev *streamEvent) string {
+	if ev.Kind != streamKindCommit {
+		return ev.Kind
+	}
+	collection, _, _ := strings.Cut(ev.Path, "/")
+	return strings.NewReplacer(".", "_", "-", "_").Replace(collection)
+}
+
+func (s *sqliteSink) stmt(table string) (*sql.Stmt, error) {
+	if stmt, ok := s.stmts[table]; ok {
+		return stmt, nil
+	}
+	q := `"` + strings.ReplaceAll(table, `"`, `""`) + `"`
+	_, err := s.tx.Exec(`CREATE TABLE IF NOT EXISTS ` + q + ` (seq INTEGER, did TEXT, op TEXT, rkey TEXT, cid TEXT, rev TEXT, time TEXT, data TEXT, UNIQUE (seq, did, rkey, op))`)
+	if err != nil {
+		return nil, err
+	}
+	// the events replayed after resuming are ignored
+	stmt, err := s.tx.Prepare(`INSERT OR IGNORE INTO ` + q + ` (seq, did, op, rkey, cid, rev, time, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[table] = stmt
+	return stmt, nil
+}
+
+func (s *sqliteSink) write(ev *streamEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		if s.tx, err = s.db.Begin(); err != nil {
+			return err
+		}
+	}
+	stmt, err := s.stmt(sqliteTable(ev))
+	if err != nil {
+		return err
+	}
+	var rkey, c string
+	if _, r, ok := strings.Cut(ev.Path, "/"); ok {
+		rkey = r
+	}
+	if ev.Rcid != nil {
+		c = ev.Rcid.String()
+	}
+	if _, err := stmt.Exec(ev.Seq, ev.Did, string(ev.Op), rkey, c, ev.Rev, ev.Time, string(b)); err != nil {
+		return err
+	}
+	s.pending++
+	if s.pending >= sqliteBatchSize {
+		return s.commit()
+	}
+	return nil
+}
+
+func (s *sqliteSink) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	s.stmts = map[string]*sql.Stmt{}
+	s.pending = 0
+	return err
+}
+
+func (s *sqliteSink) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit()
+}
+
+func (s *sqliteSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.commit(), s.db.Close())
+}
+
+// execSink pipes the events to the stdin of a command as JSON lines. The
+// command runs through the stream, and when it exits, the stream stops.
+type execSink struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func newExecSink(command string) (*execSink, error) {
+	cmd := shellCommand(command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot run %q: %w", command, err)
+	}
+	return &execSink{cmd: cmd, stdin: stdin, w: bufio.NewWriter(stdin)}, nil
+}
+
+func (s *execSink) write(ev *streamEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *execSink) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *execSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := errors.Join(s.w.Flush(), s.stdin.Close())
+	if werr := s.cmd.Wait(); werr != nil {
+		err = errors.Join(err, fmt.Errorf("command of --exec failed: %w", werr))
+	}
+	return err
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+		err  bool
+	}{
+		{s: "", want: 0},
+		{s: "512", want: 512},
+		{s: "10K", want: 10 << 10},
+		{s: "100M", want: 100 << 20},
+		{s: "1gb", want: 1 << 30},
+		{s: "M", err: true},
+		{s: "-1K", err: true},
+		{s: "1T", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseSize(%q) should fail", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSize(%q): %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func sinkEvents() []*streamEvent {
+	active := false
+	return []*streamEvent{
+		{Kind: streamKindCommit, Op: "create", Seq: 1, Did: "did:plc:a", Path: "app.bsky.feed.post/1"},
+		{Kind: streamKindCommit, Op: "create", Seq: 2, Did: "did:plc:a", Path: "app.bsky.feed.like/2"},
+		{Kind: streamKindAccount, Seq: 3, Did: "did:plc:b", Active: &active, Status: &[]string{"deactivated"}[0]},
+		{Kind: streamKindCommit, Op: "delete", Seq: 4, Did: "did:plc:b", Path: "app.bsky.feed.post/3"},
+	}
+}
+
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestJSONLSink(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 15, 0, 0, 0, time.UTC)
+	s := &jsonlSink{
+		path:    filepath.Join(dir, "events.jsonl.gz"),
+		maxSize: 200,
+		gzip:    true,
+		now: func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		},
+	}
+	if err := s.open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range sinkEvents() {
+		if err := s.write(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "events-*.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) < 2 {
+		t.Fatalf("the file should be rotated: %v", names)
+	}
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, readLines(t, name)...)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("want 4 lines, got %d: %v", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], `"seq":1`) || !strings.Contains(lines[3], `"seq":4`) {
+		t.Errorf("the events are not in order: %v", lines)
+	}
+}
+
+func TestJSONLSinkSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 15, 0, 0, 0, time.UTC)
+	s := &jsonlSink{
+		path:    filepath.Join(dir, "events.jsonl"),
+		maxSize: 1,
+		now:     func() time.Time { return now },
+	}
+	if err := s.open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range sinkEvents() {
+		if err := s.write(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// every rotation opens a new file even in the same second
+	for i, name := range []string{
+		"events-20260101-150000.jsonl",
+		"events-20260101-150000-1.jsonl",
+		"events-20260101-150000-2.jsonl",
+		"events-20260101-150000-3.jsonl",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(b), "\n"); n != 1 || !strings.Contains(string(b), fmt.Sprintf(`"seq":%d`, i+1)) {
+			t.Errorf("%s: want only the event %d, got %q", name, i+1, b)
+		}
+	}
+}
+
+func TestSQLiteSink(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "events.db")
+	s, err := newSQLiteSink(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the events are written again as when resumed
+	for range 2 {
+		for _, ev := range sinkEvents() {
+			if err := s.write(ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for table, want := range map[string]int{"app_bsky_feed_post": 2, "app_bsky_feed_like": 1, "account": 1} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("%s: want %d rows, got %d", table, want, n)
+		}
+	}
+	var rkey, op string
+	if err := db.QueryRow(`SELECT rkey, op FROM app_bsky_feed_post WHERE seq = 4`).Scan(&rkey, &op); err != nil {
+		t.Fatal(err)
+	}
+	if rkey != "3" || op != "delete" {
+		t.Errorf("want rkey 3 and op delete, got %q and %q", rkey, op)
+	}
+}
+
+func TestExecSink(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "events.jsonl")
+	s, err := newExecSink("cat > '" + name + "'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range sinkEvents() {
+		if err := s.write(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 4 {
+		t.Errorf("want 4 lines, got %d", n)
+	}
+}
+
+type failingSink struct {
+	written []int64
+}
+
+func (s *failingSink) write(ev *streamEvent) error {
+	if ev.Seq == 3 {
+		return errors.New("disk full")
+	}
+	s.written = append(s.written, ev.Seq)
+	return nil
+}
+
+func (s *failingSink) flush() error { return nil }
+func (s *failingSink) close() error { return nil }
+
+func TestStreamOutput(t *testing.T) {
+	sink := &failingSink{}
+	out := &streamOutput{sink: sink}
+	events := sinkEvents()
+
+	if err := out.write(events[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.write(events[1], false); err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := out.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != 1 {
+		t.Errorf("want cursor 1, got %d", cursor)
+	}
+
+	if err := out.write(events[2], true); err == nil {
+		t.Fatal("the write should fail")
+	}
+	if err := out.write(events[3], true); err == nil {
+		t.Fatal("the events after the failure should be refused")
+	}
+	if _, err := out.flush(); err == nil {
+		t.Fatal("the cursor should not be saved after the failure")
+	}
+	if len(sink.written) != 1 || sink.written[0] != 1 {
+		t.Errorf("want only the first event written, got %v", sink.written)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+// feedMarker is the newest post already shown in a feed.
+type feedMarker struct {
+	Uri       string `json:"uri"`
+	IndexedAt string `json:"indexedAt"`
+}
+
+// state is what bsky remembers between runs. It is stored per profile.
+type state struct {
+	Feeds map[string]*feedMarker `json:"feeds,omitempty"`
+
+	// Searches are the terms of the saved searches by name.
+	Searches map[string]string `json:"searches,omitempty"`
+
+	// Streams are the cursors of the streams by URL, saved with --resume.
+	Streams map[string]int64 `json:"streams,omitempty"`
+
+	// Bot is the posts acted on and the cooldowns of bsky bot.
+	Bot *botState `json:"bot,omitempty"`
+}
+
+func stateFile(cCtx *cli.Context) string {
+	cfg := cCtx.App.Metadata["config"].(*config)
+	return filepath.Join(cfg.dir, cfg.prefix+"state.json")
+}
+
+func loadState(cCtx *cli.Context) (*state, error) {
+	var st state
+	b, err := os.ReadFile(stateFile(cCtx))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &st, nil
+		}
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+	return &st, nil
+}
+
+// updateState applies update to the state on disk. The state is loaded again
+// just before writing, so that runs of other commands are not lost.
+func updateState(cCtx *cli.Context, update func(st *state)) error {
+	st, err := loadState(cCtx)
+	if err != nil {
+		return err
+	}
+	update(st)
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	fn := stateFile(cCtx)
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("cannot write state file: %w", err)
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		return fmt.Errorf("cannot write state file: %w", err)
+	}
+	return nil
+}
+
+func loadFeedMarker(cCtx *cli.Context, key string) (*feedMarker, error) {
+	st, err := loadState(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	return st.Feeds[key], nil
+}
+
+func saveFeedMarker(cCtx *cli.Context, key string, marker *feedMarker) error {
+	return updateState(cCtx, func(st *state) {
+		if st.Feeds == nil {
+			st.Feeds = map[string]*feedMarker{}
+		}
+		st.Feeds[key] = marker
+	})
+}
@@ -0,0 +1,1018 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/repomgr"
+	cliutil "github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+	cid "github.com/ipfs/go-cid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// defaultJetstreamHost is the public Jetstream instance used by --jetstream.
+const defaultJetstreamHost = "wss://jetstream2.us-east.bsky.network"
+
+const (
+	// streamCheckpointInterval is how often the cursor is saved with --resume.
+	streamCheckpointInterval = 10 * time.Second
+
+	minStreamBackoff = time.Second
+	maxStreamBackoff = time.Minute
+)
+
+// The kinds of the events of the stream, named after the ones of Jetstream.
+// The #handle and #tombstone events of the firehose are superseded by
+// #identity and #account, and are not sent by the relays anymore.
+const (
+	streamKindCommit   = "commit"
+	streamKindIdentity = "identity"
+	streamKindAccount  = "account"
+	streamKindSync     = "sync"
+)
+
+// streamEvent is an event in the stream. It is written as JSON with --json,
+// in the same shape for the firehose and Jetstream. Seq is the sequence
+// number of the firehose, or the time in microseconds of Jetstream.
+//
+// Commits are split to the record operations with Op, Path, Rcid and Rec.
+// The other kinds tell the changes of the accounts: identity with the new
+// Handle, account with Active and Status (like deactivated or deleted), and
+// sync with the Rev which the repository was reset to.
+type streamEvent struct {
+	Kind   string            `json:"kind"`
+	Op     repomgr.EventKind `json:"op,omitempty"`
+	Seq    int64             `json:"seq"`
+	Path   string            `json:"path,omitempty"`
+	Did    string            `json:"did"`
+	Rcid   *cid.Cid          `json:"rcid,omitempty"`
+	Rec    any               `json:"rec,omitempty"`
+	Handle *string           `json:"handle,omitempty"`
+	Active *bool             `json:"active,omitempty"`
+	Status *string           `json:"status,omitempty"`
+	Rev    string            `json:"rev,omitempty"`
+	Time   string            `json:"time,omitempty"`
+}
+
+func streamHost(host, cursor string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = "wss"
+	u.Path = "/xrpc/com.atproto.sync.subscribeRepos"
+	if cursor != "" {
+		q := u.Query()
+		q.Set("cursor", cursor)
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// withCursor returns the URL of the stream starting after cursor. The stream
+// starts from the live events when cursor is 0.
+func withCursor(host string, cursor int64) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if cursor > 0 {
+		q.Set("cursor", strconv.FormatInt(cursor, 10))
+	} else {
+		q.Del("cursor")
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// streamBackoff returns how long to wait before the attempt-th reconnection.
+func streamBackoff(attempt int) time.Duration {
+	d := minStreamBackoff
+	for range attempt {
+		d *= 2
+		if d >= maxStreamBackoff {
+			return maxStreamBackoff
+		}
+	}
+	return d
+}
+
+// streamCheckpoint saves the cursor of the stream to the state file, so that
+// the stream is resumed after the last event processed.
+type streamCheckpoint struct {
+	cCtx  *cli.Context
+	key   string
+	mu    sync.Mutex
+	saved int64
+}
+
+func loadStreamCheckpoint(cCtx *cli.Context, key string) (*streamCheckpoint, error) {
+	st, err := loadState(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamCheckpoint{cCtx: cCtx, key: key, saved: st.Streams[key]}, nil
+}
+
+func (c *streamCheckpoint) save(cursor int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cursor == c.saved {
+		return nil
+	}
+	err := updateState(c.cCtx, func(st *state) {
+		if st.Streams == nil {
+			st.Streams = map[string]int64{}
+		}
+		st.Streams[c.key] = cursor
+	})
+	if err != nil {
+		return err
+	}
+	c.saved = cursor
+	return nil
+}
+
+// run saves the cursor periodically until ctx is done. The cursor is not
+// saved when cursor fails.
+func (c *streamCheckpoint) run(ctx context.Context, cursor func() (int64, error)) {
+	t := time.NewTicker(streamCheckpointInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := cursor()
+			if err == nil {
+				err = c.save(n)
+			}
+			if err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+// jetstreamHost returns the URL of the subscribe endpoint of Jetstream. The
+// collections and the DIDs are filtered by the server.
+func jetstreamHost(host string, collections, dids []string, compress bool) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid jetstream host: %q", host)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/subscribe"
+	}
+	q := u.Query()
+	for _, c := range collections {
+		q.Add("wantedCollections", c)
+	}
+	for _, did := range dids {
+		q.Add("wantedDids", did)
+	}
+	if compress {
+		q.Set("compress", "true")
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// streamFilter selects the events of the stream. The filters of the records
+// of posts like langs drop the events of the other records.
+type streamFilter struct {
+	collections []string
+	dids        map[string]bool
+	ops         []string
+	langs       []string
+	hasMedia    bool
+	mentions    []string
+	grep        *regexp.Regexp
+	where       *template.Template
+}
+
+func newStreamFilter(cCtx *cli.Context) (*streamFilter, error) {
+	f := &streamFilter{
+		collections: cCtx.StringSlice("collection"),
+		ops:         cCtx.StringSlice("op"),
+		langs:       cCtx.StringSlice("lang"),
+		hasMedia:    cCtx.Bool("has-media"),
+		mentions:    cCtx.StringSlice("mentions"),
+	}
+	for _, op := range f.ops {
+		switch repomgr.EventKind(op) {
+		case repomgr.EvtKindCreateRecord, repomgr.EvtKindUpdateRecord, repomgr.EvtKindDeleteRecord:
+		default:
+			return nil, fmt.Errorf("invalid op: %q (must be create, update or delete)", op)
+		}
+	}
+	dids, err := streamDids(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	if len(dids) > 0 {
+		f.dids = map[string]bool{}
+		for _, did := range dids {
+			f.dids[did] = true
+		}
+	}
+	if pattern := cCtx.String("pattern"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.grep = re
+	}
+	if where := cCtx.String("where"); where != "" {
+		f.where, err = parseStreamWhere(where)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// parseStreamWhere parses the expression of --where. It is a pipeline of Go
+// templates, which is true when it is true with if.
+func parseStreamWhere(where string) (*template.Template, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).Funcs(streamFilterFuncs).Parse("{{if " + where + "}}true{{end}}")
+	if err != nil {
+		return nil, fmt.Errorf("invalid --where: %w", err)
+	}
+	return tmpl.Option("missingkey=zero"), nil
+}
+
+// streamDids returns the DIDs of --did and the lines of --did-file.
+func streamDids(cCtx *cli.Context) ([]string, error) {
+	dids := cCtx.StringSlice("did")
+	if fn := cCtx.String("did-file"); fn != "" {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read DIDs: %w", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				dids = append(dids, line)
+			}
+		}
+	}
+	return dids, nil
+}
+
+// streamFilterFuncs are the functions for the expressions of --where in
+// addition to templateFuncs.
+var streamFilterFuncs = template.FuncMap{
+	"contains":  func(s, substr any) bool { return strings.Contains(fmt.Sprint(s), fmt.Sprint(substr)) },
+	"hasPrefix": func(s, prefix any) bool { return strings.HasPrefix(fmt.Sprint(s), fmt.Sprint(prefix)) },
+	"match": func(pattern string, s any) (bool, error) {
+		return regexp.MatchString(pattern, fmt.Sprint(s))
+	},
+}
+
+// matchCollection reports whether the collection of path matches pattern.
+// A pattern ending with ".*" matches the collections under it like
+// wantedCollections of Jetstream.
+func matchCollection(pattern, path string) bool {
+	collection, _, _ := strings.Cut(path, "/")
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(collection, prefix)
+	}
+	return collection == pattern
+}
+
+func hasPostMedia(embed *bsky.FeedPost_Embed) bool {
+	if embed == nil {
+		return false
+	}
+	if embed.EmbedImages != nil || embed.EmbedVideo != nil || embed.EmbedGallery != nil {
+		return true
+	}
+	if m := embed.EmbedRecordWithMedia; m != nil && m.Media != nil {
+		return m.Media.EmbedImages != nil || m.Media.EmbedVideo != nil || m.Media.EmbedGallery != nil
+	}
+	return false
+}
+
+func mentionsDid(post *bsky.FeedPost, did string) bool {
+	for _, facet := range post.Facets {
+		for _, feature := range facet.Features {
+			if m := feature.RichtextFacet_Mention; m != nil && m.Did == did {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *streamFilter) matches(ev *streamEvent) bool {
+	if f.dids != nil && !f.dids[ev.Did] {
+		return false
+	}
+	// the changes of the accounts are not records, and pass the filters of
+	// the records not to miss them for the repositories selected
+	if ev.Kind != streamKindCommit {
+		return f.matchesWhere(ev)
+	}
+	if len(f.collections) > 0 && !slices.ContainsFunc(f.collections, func(c string) bool {
+		return matchCollection(c, ev.Path)
+	}) {
+		return false
+	}
+	if len(f.ops) > 0 && !slices.Contains(f.ops, string(ev.Op)) {
+		return false
+	}
+
+	if len(f.langs) > 0 || f.hasMedia || len(f.mentions) > 0 || f.grep != nil {
+		post, ok := ev.Rec.(*bsky.FeedPost)
+		if !ok {
+			return false
+		}
+		if len(f.langs) > 0 && !slices.ContainsFunc(f.langs, func(want string) bool {
+			return slices.ContainsFunc(post.Langs, func(lang string) bool {
+				return strings.EqualFold(lang, want) || strings.HasPrefix(strings.ToLower(lang), strings.ToLower(want)+"-")
+			})
+		}) {
+			return false
+		}
+		if f.hasMedia && !hasPostMedia(post.Embed) {
+			return false
+		}
+		if len(f.mentions) > 0 && !slices.ContainsFunc(f.mentions, func(did string) bool {
+			return mentionsDid(post, did)
+		}) {
+			return false
+		}
+		if f.grep != nil && !f.grep.MatchString(post.Text) {
+			return false
+		}
+	}
+	return f.matchesWhere(ev)
+}
+
+func (f *streamFilter) matchesWhere(ev *streamEvent) bool {
+	if f.where == nil {
+		return true
+	}
+	// the expression sees the event as written with --json
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return false
+	}
+	var data map[string]any
+	if err := json.Unmarshal(b, &data); err != nil {
+		return false
+	}
+	var buf strings.Builder
+	if err := f.where.Execute(&buf, data); err != nil {
+		return false
+	}
+	return buf.String() == "true"
+}
+
+// streamHandler prints the events of the stream, and replies to the posts
+// with --reply. The client and the profile resolver are shared by all the
+// events, and are nil with --json and without --reply.
+type streamHandler struct {
+	cCtx     *cli.Context
+	reply    string
+	enc      *json.Encoder
+	client   *sessionClient
+	profiles *profileResolver
+}
+
+func newStreamHandler(cCtx *cli.Context, client *sessionClient) *streamHandler {
+	h := &streamHandler{
+		cCtx:   cCtx,
+		reply:  cCtx.String("reply"),
+		enc:    json.NewEncoder(os.Stdout),
+		client: client,
+	}
+	if client != nil && !cCtx.Bool("json") {
+		h.profiles = newProfileResolver(func(ctx context.Context, dids []string) ([]*bsky.ActorDefs_ProfileViewDetailed, error) {
+			var profiles []*bsky.ActorDefs_ProfileViewDetailed
+			err := client.do(func(xrpcc *xrpc.Client) (err error) {
+				profiles, err = getProfiles(ctx, xrpcc, dids)
+				return err
+			})
+			return profiles, err
+		}, profileCacheSize, profileQueueSize)
+	}
+	return h
+}
+
+// start starts the lookups of the profiles until ctx is done.
+func (h *streamHandler) start(ctx context.Context) {
+	if h.profiles != nil {
+		h.profiles.start(ctx, profileWorkers, profileInterval)
+	}
+}
+
+func (h *streamHandler) handle(ev *streamEvent) error {
+	orig, isPost := ev.Rec.(*bsky.FeedPost)
+
+	if ev.Kind == streamKindIdentity && h.profiles != nil {
+		h.profiles.forget(ev.Did)
+	}
+
+	if h.cCtx.Bool("json") {
+		h.enc.Encode(ev)
+	} else if ev.Kind != streamKindCommit {
+		printAccountEvent(ev)
+	} else if isPost {
+		post := bsky.FeedDefs_PostView{
+			Author: &bsky.ActorDefs_ProfileViewBasic{Did: ev.Did, Handle: ev.Did},
+			Record: &lexutil.LexiconTypeDecoder{Val: orig},
+		}
+		// the reader does not wait for the lookup, and the posts of the
+		// authors not looked up yet are printed with the DIDs
+		if author := h.profiles.resolve(ev.Did, 0); author != nil {
+			post.Author = author
+		}
+		printPost(newRenderer(h.cCtx), &post)
+	}
+	if isPost && h.reply != "" && ev.Op == repomgr.EvtKindCreateRecord {
+		err := h.client.do(func(xrpcc *xrpc.Client) error {
+			// the own posts are not replied to, not to reply to the replies
+			// forever
+			if ev.Did == xrpcc.Auth.Did {
+				return nil
+			}
+			ref, err := postRef(ev)
+			if err != nil {
+				return err
+			}
+			_, err = createReply(xrpcc, ref, orig, h.reply)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("cannot reply: %w", err)
+		}
+	}
+	return nil
+}
+
+// printAccountEvent prints the change of the account in a line.
+func printAccountEvent(ev *streamEvent) {
+	color.Set(color.FgHiRed)
+	fmt.Print(ev.Kind)
+	color.Set(color.Reset)
+	fmt.Print(" ", ev.Did)
+	switch ev.Kind {
+	case streamKindIdentity:
+		if ev.Handle != nil {
+			fmt.Print(" ", *ev.Handle)
+		}
+	case streamKindAccount:
+		switch {
+		case boolp(ev.Active):
+			fmt.Print(" active")
+		case ev.Status != nil:
+			fmt.Print(" ", *ev.Status)
+		default:
+			fmt.Print(" inactive")
+		}
+	case streamKindSync:
+		fmt.Print(" rev ", ev.Rev)
+	}
+	fmt.Println()
+}
+
+// streamReader reads the events of the firehose or Jetstream, and passes
+// the record operations to handle. cursor is the seq of the last event
+// processed, so that the stream is resumed after it. The commits of the
+// firehose are verified with verifier when it is not nil. dir resolves the
+// PDSes of the repos for the commits too big to be sent in the firehose.
+type streamReader struct {
+	handle   func(*streamEvent) error
+	verifier *commitVerifier
+	dir      identity.Directory
+	client   *http.Client
+	cursor   atomic.Int64
+}
+
+// run reads the stream from host until ctx is done. It reconnects with
+// backoff from the cursor when the connection drops, and gives up only when
+// the first connection fails.
+func (s *streamReader) run(ctx context.Context, host string, jetstream, compress bool) error {
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		u, err := withCursor(host, s.cursor.Load())
+		if err != nil {
+			return err
+		}
+		last := s.cursor.Load()
+		con, _, err := websocket.DefaultDialer.DialContext(ctx, u, http.Header{})
+		if err == nil {
+			stopClose := context.AfterFunc(ctx, func() {
+				con.Close()
+			})
+			if jetstream {
+				err = s.readJetstream(ctx, con, compress)
+			} else {
+				err = s.readFirehose(ctx, con)
+			}
+			stopClose()
+			con.Close()
+		} else if attempt == 0 {
+			return fmt.Errorf("dial failure: %w", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if s.cursor.Load() != last {
+			attempt = 0
+		}
+		d := streamBackoff(attempt)
+		log.Printf("%v (reconnecting in %v)", err, d)
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+		}
+	}
+	return nil
+}
+
+// readFirehose reads the commits of com.atproto.sync.subscribeRepos. The
+// records are read from the CAR blocks of the commits. Broken commits are
+// logged and skipped, not to stop the stream at them forever.
+func (s *streamReader) readFirehose(ctx context.Context, con *websocket.Conn) error {
+	rsc := &events.RepoStreamCallbacks{
+		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			defer s.cursor.Store(evt.Seq)
+			if evt.TooBig {
+				if err := s.readTooBig(ctx, evt); err != nil {
+					log.Printf("cannot fetch too big commit (seq: %d, repo: %s): %v", evt.Seq, evt.Repo, err)
+				}
+				return nil
+			}
+			if s.verifier != nil {
+				if err := s.verifier.verify(ctx, evt); err != nil {
+					s.verifier.reject(evt, err)
+					return nil
+				}
+			}
+			r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
+			if err != nil {
+				log.Printf("reading repo from car (seq: %d, len: %d): %v", evt.Seq, len(evt.Blocks), err)
+				return nil
+			}
+			s.handleOps(evt, func(path string) (cid.Cid, cbg.CBORMarshaler, error) {
+				return r.GetRecord(ctx, path)
+			})
+			return nil
+		},
+		RepoSync: func(evt *comatproto.SyncSubscribeRepos_Sync) error {
+			defer s.cursor.Store(evt.Seq)
+			s.handleAccount(&streamEvent{Kind: streamKindSync, Seq: evt.Seq, Did: evt.Did, Rev: evt.Rev, Time: evt.Time})
+			return nil
+		},
+		RepoIdentity: func(evt *comatproto.SyncSubscribeRepos_Identity) error {
+			defer s.cursor.Store(evt.Seq)
+			if s.dir != nil {
+				if did, err := syntax.ParseDID(evt.Did); err == nil {
+					s.dir.Purge(ctx, did.AtIdentifier())
+				}
+			}
+			s.handleAccount(&streamEvent{Kind: streamKindIdentity, Seq: evt.Seq, Did: evt.Did, Handle: evt.Handle, Time: evt.Time})
+			return nil
+		},
+		RepoAccount: func(evt *comatproto.SyncSubscribeRepos_Account) error {
+			defer s.cursor.Store(evt.Seq)
+			s.handleAccount(&streamEvent{Kind: streamKindAccount, Seq: evt.Seq, Did: evt.Did, Active: &evt.Active, Status: evt.Status, Time: evt.Time})
+			return nil
+		},
+		RepoInfo: func(evt *comatproto.SyncSubscribeRepos_Info) error {
+			if evt.Name == "OutdatedCursor" {
+				log.Printf("cursor %d is older than the events kept by the relay, so some events are missed: %s", s.cursor.Load(), stringp(evt.Message))
+				return nil
+			}
+			log.Printf("info from the relay: %s: %s", evt.Name, stringp(evt.Message))
+			return nil
+		},
+		Error: func(evt *events.ErrorFrame) error {
+			if evt.Error == "FutureCursor" {
+				// the relay does not know the cursor, so start from the live events
+				log.Printf("cursor %d is not known to the relay: %s", s.cursor.Load(), evt.Message)
+				s.cursor.Store(0)
+			}
+			return fmt.Errorf("error from the relay: %s: %s", evt.Error, evt.Message)
+		},
+	}
+
+	err := events.HandleRepoStream(ctx, con, sequential.NewScheduler("stream", rsc.EventHandler), slog.Default())
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// handleOps passes the record operations of the commit to handle. The
+// records are read with get, and are checked against the CIDs of the ops.
+func (s *streamReader) handleOps(evt *comatproto.SyncSubscribeRepos_Commit, get func(path string) (cid.Cid, cbg.CBORMarshaler, error)) {
+	for _, op := range evt.Ops {
+		ek := repomgr.EventKind(op.Action)
+		switch ek {
+		case repomgr.EvtKindCreateRecord, repomgr.EvtKindUpdateRecord:
+			if op.Cid == nil {
+				log.Printf("no cid of %s within seq %d for %s", op.Path, evt.Seq, evt.Repo)
+				continue
+			}
+			rc, rec, err := get(op.Path)
+			if err != nil {
+				e := fmt.Errorf("getting record %s (%s) within seq %d for %s: %w", op.Path, *op.Cid, evt.Seq, evt.Repo, err)
+				log.Print(e)
+				continue
+			}
+
+			if lexutil.LexLink(rc) != *op.Cid {
+				log.Printf("mismatch in record and op cid: %s != %s", rc, *op.Cid)
+				continue
+			}
+
+			if err := s.handle(&streamEvent{Kind: streamKindCommit, Op: ek, Seq: evt.Seq, Path: op.Path, Did: evt.Repo, Rcid: &rc, Rec: rec, Rev: evt.Rev, Time: evt.Time}); err != nil {
+				log.Printf("event consumer callback (%s): %s", ek, err)
+				continue
+			}
+
+		case repomgr.EvtKindDeleteRecord:
+			if err := s.handle(&streamEvent{Kind: streamKindCommit, Op: ek, Seq: evt.Seq, Path: op.Path, Did: evt.Repo, Rev: evt.Rev, Time: evt.Time}); err != nil {
+				log.Printf("event consumer callback (%s): %s", ek, err)
+				continue
+			}
+		}
+	}
+}
+
+func (s *streamReader) handleAccount(ev *streamEvent) {
+	if err := s.handle(ev); err != nil {
+		log.Printf("event consumer callback (%s): %s", ev.Kind, err)
+	}
+}
+
+// readTooBig reads a commit whose blocks are too big to be sent in the
+// firehose from the PDS of the repo. The records of the ops are fetched with
+// com.atproto.sync.getRecord. When the ops are not listed either, the whole
+// repo is fetched with com.atproto.sync.getRepo, and all the records are
+// passed as updates; the deleted records are not known then.
+func (s *streamReader) readTooBig(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	if s.dir == nil {
+		return fmt.Errorf("no directory to find the PDS")
+	}
+	did, err := syntax.ParseDID(evt.Repo)
+	if err != nil {
+		return err
+	}
+	ident, err := s.dir.LookupDID(ctx, did)
+	if err != nil {
+		return err
+	}
+	if ident.PDSEndpoint() == "" {
+		return fmt.Errorf("no PDS in the DID document")
+	}
+	xrpcc := &xrpc.Client{Client: s.client, Host: ident.PDSEndpoint()}
+
+	if len(evt.Ops) == 0 {
+		b, err := comatproto.SyncGetRepo(ctx, xrpcc, evt.Repo, "")
+		if err != nil {
+			return fmt.Errorf("cannot get repo: %w", err)
+		}
+		r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		return r.ForEach(ctx, "", func(path string, _ cid.Cid) error {
+			rc, rec, err := r.GetRecord(ctx, path)
+			if err != nil {
+				log.Printf("getting record %s within seq %d for %s: %v", path, evt.Seq, evt.Repo, err)
+				return nil
+			}
+			if err := s.handle(&streamEvent{Kind: streamKindCommit, Op: repomgr.EvtKindUpdateRecord, Seq: evt.Seq, Path: path, Did: evt.Repo, Rcid: &rc, Rec: rec, Rev: evt.Rev, Time: evt.Time}); err != nil {
+				log.Printf("event consumer callback (%s): %s", repomgr.EvtKindUpdateRecord, err)
+			}
+			return nil
+		})
+	}
+
+	// the records may have been changed after the commit, and then the
+	// mismatches of the CIDs are logged and the later commits follow
+	s.handleOps(evt, func(path string) (cid.Cid, cbg.CBORMarshaler, error) {
+		collection, rkey, ok := strings.Cut(path, "/")
+		if !ok {
+			return cid.Undef, nil, fmt.Errorf("invalid path")
+		}
+		b, err := comatproto.SyncGetRecord(ctx, xrpcc, collection, evt.Repo, rkey)
+		if err != nil {
+			return cid.Undef, nil, err
+		}
+		r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(b))
+		if err != nil {
+			return cid.Undef, nil, err
+		}
+		return r.GetRecord(ctx, path)
+	})
+	return nil
+}
+
+// jetstreamEvent is an event of Jetstream.
+type jetstreamEvent struct {
+	Did      string                                  `json:"did"`
+	TimeUS   int64                                   `json:"time_us"`
+	Kind     string                                  `json:"kind"`
+	Commit   *jetstreamCommit                        `json:"commit,omitempty"`
+	Identity *comatproto.SyncSubscribeRepos_Identity `json:"identity,omitempty"`
+	Account  *comatproto.SyncSubscribeRepos_Account  `json:"account,omitempty"`
+}
+
+type jetstreamCommit struct {
+	Rev        string          `json:"rev"`
+	Operation  string          `json:"operation"`
+	Collection string          `json:"collection"`
+	RKey       string          `json:"rkey"`
+	Record     json.RawMessage `json:"record,omitempty"`
+	CID        string          `json:"cid,omitempty"`
+}
+
+// streamEvent returns the event of the stream for the event of Jetstream.
+// It returns nil for unknown kinds of events. Records are decoded to the
+// lexicon types like the firehose, and records of unknown types are kept as
+// JSON.
+func (ev *jetstreamEvent) streamEvent() (*streamEvent, error) {
+	switch {
+	case ev.Kind == streamKindIdentity && ev.Identity != nil:
+		return &streamEvent{Kind: streamKindIdentity, Seq: ev.TimeUS, Did: ev.Did, Handle: ev.Identity.Handle, Time: ev.Identity.Time}, nil
+	case ev.Kind == streamKindAccount && ev.Account != nil:
+		return &streamEvent{Kind: streamKindAccount, Seq: ev.TimeUS, Did: ev.Did, Active: &ev.Account.Active, Status: ev.Account.Status, Time: ev.Account.Time}, nil
+	case ev.Kind != streamKindCommit || ev.Commit == nil:
+		return nil, nil
+	}
+	se := &streamEvent{
+		Kind: streamKindCommit,
+		Op:   repomgr.EventKind(ev.Commit.Operation),
+		Seq:  ev.TimeUS,
+		Path: ev.Commit.Collection + "/" + ev.Commit.RKey,
+		Did:  ev.Did,
+		Rev:  ev.Commit.Rev,
+	}
+	if ev.Commit.CID != "" {
+		c, err := cid.Decode(ev.Commit.CID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cid of %s in %s: %w", se.Path, se.Did, err)
+		}
+		se.Rcid = &c
+	}
+	if len(ev.Commit.Record) > 0 {
+		if rec, err := lexutil.JsonDecodeValue(ev.Commit.Record); err == nil {
+			se.Rec = rec
+		} else {
+			se.Rec = ev.Commit.Record
+		}
+	}
+	return se, nil
+}
+
+// readJetstream reads the events of Jetstream. With compressed, the
+// messages are compressed with zstd and the dictionary of Jetstream.
+func (s *streamReader) readJetstream(ctx context.Context, con *websocket.Conn, compressed bool) error {
+	var dec *zstd.Decoder
+	if compressed {
+		var err error
+		dec, err = zstd.NewReader(nil, zstd.WithDecoderDicts(models.ZSTDDictionary))
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+	}
+
+	for {
+		typ, msg, err := con.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("cannot read jetstream: %w", err)
+		}
+		if dec != nil && typ == websocket.BinaryMessage {
+			msg, err = dec.DecodeAll(msg, nil)
+			if err != nil {
+				log.Printf("cannot decompress jetstream event: %v", err)
+				continue
+			}
+		}
+		var ev jetstreamEvent
+		if err := json.Unmarshal(msg, &ev); err != nil {
+			log.Printf("cannot decode jetstream event: %v", err)
+			continue
+		}
+		se, err := ev.streamEvent()
+		if err != nil {
+			log.Print(err)
+		} else if se != nil {
+			if err := s.handle(se); err != nil {
+				log.Printf("event consumer callback (%s): %s", cmp.Or(string(se.Op), se.Kind), err)
+			}
+		}
+		s.cursor.Store(ev.TimeUS)
+	}
+}
+
+// selectStreamHost returns the URL of the stream from the argument, or from
+// the config. The collections and the DIDs are given to Jetstream to filter
+// them on the server.
+func selectStreamHost(cCtx *cli.Context, jetstream bool, collections, dids []string) (string, error) {
+	switch {
+	case jetstream:
+		host := defaultJetstreamHost
+		if cCtx.Args().Present() {
+			host = cCtx.Args().First()
+		}
+		return jetstreamHost(host, collections, dids, cCtx.Bool("compress"))
+	case cCtx.Args().Present():
+		return cCtx.Args().First(), nil
+	default:
+		cfg := cCtx.App.Metadata["config"].(*config)
+		host := cfg.Bgs
+		if host == "" {
+			host = cfg.Host
+		}
+		return streamHost(host, "")
+	}
+}
+
+func doStream(cCtx *cli.Context) error {
+	jetstream := cCtx.Bool("jetstream")
+	if !jetstream && cCtx.Bool("compress") {
+		return fmt.Errorf("--compress is available only with --jetstream")
+	}
+	if jetstream && cCtx.Bool("verify") {
+		return fmt.Errorf("--verify is not available with --jetstream, which has no signatures")
+	}
+	if !cCtx.Bool("verify") && cCtx.IsSet("quarantine") {
+		return fmt.Errorf("--quarantine is available only with --verify")
+	}
+	if (cCtx.IsSet("out") || cCtx.IsSet("exec")) && cCtx.String("reply") != "" {
+		return fmt.Errorf("--reply is not available with --out or --exec")
+	}
+
+	filter, err := newStreamFilter(cCtx)
+	if err != nil {
+		return err
+	}
+
+	var cursor int64
+	if s := cCtx.String("cursor"); s != "" {
+		var err error
+		cursor, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cursor: %q", s)
+		}
+	}
+
+	dids, err := streamDids(cCtx)
+	if err != nil {
+		return err
+	}
+	host, err := selectStreamHost(cCtx, jetstream, cCtx.StringSlice("collection"), dids)
+	if err != nil {
+		return err
+	}
+
+	sink, err := newStreamSink(cCtx)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := &streamReader{}
+	cursorFunc := func() (int64, error) {
+		return s.cursor.Load(), nil
+	}
+	var out *streamOutput
+	if sink != nil {
+		// the events go to the sinks instead of stdout. When a sink fails,
+		// the stream stops there, not to skip the events with --resume.
+		out = &streamOutput{sink: sink}
+		s.handle = func(ev *streamEvent) error {
+			if err := out.write(ev, filter.matches(ev)); err != nil {
+				stop()
+				return err
+			}
+			return nil
+		}
+		cursorFunc = out.flush
+		defer func() {
+			if err := sink.close(); err != nil {
+				log.Print(err)
+			}
+		}()
+	} else {
+		var client *sessionClient
+		if !cCtx.Bool("json") || cCtx.String("reply") != "" {
+			xrpcc, err := makeXRPCC(cCtx)
+			if err != nil {
+				return fmt.Errorf("cannot create client: %w", err)
+			}
+			client = newSessionClient(cCtx, xrpcc)
+		}
+		h := newStreamHandler(cCtx, client)
+		h.start(ctx)
+		s.handle = func(ev *streamEvent) error {
+			if !filter.matches(ev) {
+				return nil
+			}
+			return h.handle(ev)
+		}
+	}
+	if !jetstream {
+		s.dir = identity.DefaultDirectory()
+		s.client = cliutil.NewHttpClient()
+	}
+	if cCtx.Bool("verify") {
+		var quarantine io.Writer
+		if name := cCtx.String("quarantine"); name != "" {
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return fmt.Errorf("cannot open quarantine file: %w", err)
+			}
+			defer f.Close()
+			quarantine = f
+		}
+		s.verifier = newCommitVerifier(s.dir, quarantine)
+	}
+
+	if cCtx.Bool("resume") {
+		cp, err := loadStreamCheckpoint(cCtx, host)
+		if err != nil {
+			return err
+		}
+		if !cCtx.IsSet("cursor") {
+			cursor = cp.saved
+		}
+		go cp.run(ctx, cursorFunc)
+		defer func() {
+			n, err := cursorFunc()
+			if err == nil {
+				err = cp.save(n)
+			}
+			if err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	s.cursor.Store(cursor)
+	if out != nil {
+		out.cursor.Store(cursor)
+	}
+
+	if err := s.run(ctx, host, jetstream, cCtx.Bool("compress")); err != nil {
+		return err
+	}
+	if out != nil {
+		return out.failed()
+	}
+	return nil
+}
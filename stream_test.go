@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestStreamHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		cursor string
+		want   string
+	}{
+		{
+			name: "without cursor",
+			host: "https://bsky.network",
+			want: "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos",
+		},
+		{
+			name:   "with cursor",
+			host:   "https://bsky.network",
+			cursor: "123",
+			want:   "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos?cursor=123",
+		},
+		{
+			name:   "preserve existing query",
+			host:   "https://bsky.network?foo=bar",
+			cursor: "123",
+			want:   "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos?cursor=123&foo=bar",
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := streamHost(tt.host, tt.cursor)
+		if err != nil {
+			t.Fatalf("%s: streamHost returned error: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: want %q but got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestJetstreamHost(t *testing.T) {
+	got, err := jetstreamHost("https://jetstream.example.com", []string{"app.bsky.feed.post", "app.bsky.graph.*"}, []string{"did:plc:xxx"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "wss://jetstream.example.com/subscribe?compress=true&wantedCollections=app.bsky.feed.post&wantedCollections=app.bsky.graph.%2A&wantedDids=did%3Aplc%3Axxx"
+	if got != want {
+		t.Fatalf("want %q but got %q", want, got)
+	}
+	if _, err := jetstreamHost("jetstream.example.com", nil, nil, false); err == nil {
+		t.Fatal("a host without scheme should be an error")
+	}
+}
+
+var jetstreamEvents = []string{
+	`{"did":"did:plc:alice","time_us":1725911162329308,"kind":"commit","commit":{"rev":"3l3qo2vutsw2b","operation":"create","collection":"app.bsky.feed.post","rkey":"3l3qo2vuowo2b","record":{"$type":"app.bsky.feed.post","createdAt":"2024-09-09T19:46:02.102Z","langs":["en"],"text":"hello jetstream"},"cid":"bafyreidc6sydkkbchcyg62v77wbhzvb2mvytlmsychqgwf2xojjtirmzj4"}}`,
+	`{"did":"did:plc:bob","time_us":1725911162329309,"kind":"identity","identity":{"did":"did:plc:bob","handle":"bob.test","seq":1,"time":"2024-09-09T19:46:02Z"}}`,
+	`{"did":"did:plc:bob","time_us":1725911162329310,"kind":"commit","commit":{"rev":"3l3qo2vutsw2c","operation":"delete","collection":"app.bsky.feed.like","rkey":"3l3qo2vuowo2c"}}`,
+	`{"did":"did:plc:carol","time_us":1725911162329311,"kind":"commit","commit":{"rev":"3l3qo2vutsw2d","operation":"create","collection":"com.example.unknown","rkey":"1","record":{"$type":"com.example.unknown","foo":"bar"},"cid":"bafyreidc6sydkkbchcyg62v77wbhzvb2mvytlmsychqgwf2xojjtirmzj4"}}`,
+}
+
+// newFakeJetstream returns a websocket server which sends the events and
+// closes, compressed with zstd when the client asks.
+func newFakeJetstream(t *testing.T, requests chan<- string) *httptest.Server {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderDict(models.ZSTDDictionary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upgrader websocket.Upgrader
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.String()
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		for _, ev := range jetstreamEvents {
+			if r.URL.Query().Get("compress") == "true" {
+				con.WriteMessage(websocket.BinaryMessage, enc.EncodeAll([]byte(ev), nil))
+			} else {
+				con.WriteMessage(websocket.TextMessage, []byte(ev))
+			}
+		}
+		con.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestReadJetstream(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		requests := make(chan string, 1)
+		ts := newFakeJetstream(t, requests)
+		host, err := jetstreamHost(ts.URL, []string{"app.bsky.feed.post"}, nil, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		con, _, err := websocket.DefaultDialer.Dial(host, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := <-requests; !strings.Contains(r, "wantedCollections=app.bsky.feed.post") {
+			t.Fatalf("want the filter sent but got %q", r)
+		}
+
+		var got []*streamEvent
+		s := &streamReader{handle: func(ev *streamEvent) error {
+			got = append(got, ev)
+			return nil
+		}}
+		err = s.readJetstream(context.Background(), con, compress)
+		con.Close()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+			t.Fatalf("want the normal closure but got %v", err)
+		}
+
+		if len(got) != 4 {
+			t.Fatalf("want 3 commits and an identity but got %d (compress=%v)", len(got), compress)
+		}
+		post, ok := got[0].Rec.(*bsky.FeedPost)
+		if !ok || post.Text != "hello jetstream" {
+			t.Fatalf("want the post decoded but got %#v", got[0].Rec)
+		}
+		if got[0].Kind != "commit" || got[0].Op != "create" || got[0].Seq != 1725911162329308 || got[0].Path != "app.bsky.feed.post/3l3qo2vuowo2b" || got[0].Did != "did:plc:alice" || got[0].Rcid == nil {
+			t.Fatalf("unexpected event: %+v", got[0])
+		}
+		if got[1].Kind != "identity" || got[1].Did != "did:plc:bob" || stringp(got[1].Handle) != "bob.test" {
+			t.Fatalf("unexpected identity event: %+v", got[1])
+		}
+		if got[2].Op != "delete" || got[2].Rec != nil || got[2].Rcid != nil {
+			t.Fatalf("unexpected delete event: %+v", got[2])
+		}
+		if _, ok := got[3].Rec.(json.RawMessage); !ok {
+			t.Fatalf("want the unknown record as JSON but got %#v", got[3].Rec)
+		}
+		if c := s.cursor.Load(); c != 1725911162329311 {
+			t.Fatalf("want the time of the last event as the cursor but got %d", c)
+		}
+	}
+}
+
+func TestWithCursor(t *testing.T) {
+	got, err := withCursor("wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos?cursor=1", 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos?cursor=123"; got != want {
+		t.Fatalf("want %q but got %q", want, got)
+	}
+	got, err = withCursor(got, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos"; got != want {
+		t.Fatalf("want %q but got %q", want, got)
+	}
+}
+
+func TestStreamBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := streamBackoff(i); got != w {
+			t.Fatalf("want %v but got %v at %d", w, got, i)
+		}
+	}
+}
+
+// firehoseFrame encodes a frame of com.atproto.sync.subscribeRepos.
+func firehoseFrame(t *testing.T, header events.EventHeader, body cbg.CBORMarshaler) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := header.MarshalCBOR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.MarshalCBOR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newFakeFirehose returns a websocket server which sends the frames and
+// keeps the connection until the client closes it.
+func newFakeFirehose(t *testing.T, frames ...[]byte) *httptest.Server {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		for _, f := range frames {
+			con.WriteMessage(websocket.BinaryMessage, f)
+		}
+		con.ReadMessage()
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestReadFirehose(t *testing.T) {
+	msg := "cursor is too old"
+	status, handle := "deactivated", "alice.test"
+	ts := newFakeFirehose(t,
+		firehoseFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#info"}, &comatproto.SyncSubscribeRepos_Info{Name: "OutdatedCursor", Message: &msg}),
+		firehoseFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#account"}, &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:bob", Seq: 41, Status: &status, Time: "2026-01-01T00:00:00Z"}),
+		firehoseFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#identity"}, &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:alice", Seq: 42, Handle: &handle, Time: "2026-01-01T00:00:00Z"}),
+	)
+	con, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var got []*streamEvent
+	s := &streamReader{handle: func(ev *streamEvent) error {
+		got = append(got, ev)
+		return nil
+	}}
+	s.cursor.Store(1)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.readFirehose(ctx, con)
+	}()
+	for s.cursor.Load() != 42 {
+		select {
+		case err := <-done:
+			t.Fatalf("the stream should go on after OutdatedCursor: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("want no error when canceled but got %v", err)
+	}
+	if len(got) != 2 || got[0].Kind != "account" || boolp(got[0].Active) || stringp(got[0].Status) != "deactivated" || got[0].Seq != 41 {
+		t.Fatalf("want the account deactivated but got %+v", got)
+	}
+	if got[1].Kind != "identity" || got[1].Did != "did:plc:alice" || stringp(got[1].Handle) != "alice.test" {
+		t.Fatalf("want the handle of the identity but got %+v", got[1])
+	}
+}
+
+func TestReadTooBig(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := "did:plc:alice"
+	commit := signedCommit(t, key, did, 7)
+	var paths []string
+	pds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		w.Write(commit.Blocks)
+	}))
+	defer pds.Close()
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:      syntax.DID(did),
+		Services: map[string]identity.ServiceEndpoint{"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds.URL}},
+	})
+
+	var got []*streamEvent
+	s := &streamReader{
+		handle: func(ev *streamEvent) error {
+			got = append(got, ev)
+			return nil
+		},
+		dir:    dir,
+		client: pds.Client(),
+	}
+	tooBig := *commit
+	tooBig.TooBig = true
+	tooBig.Blocks = nil
+	if err := s.readTooBig(context.Background(), &tooBig); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || !strings.HasPrefix(paths[0], "/xrpc/com.atproto.sync.getRecord?") || !strings.Contains(paths[0], "collection=app.bsky.feed.post") {
+		t.Fatalf("want the record fetched from the PDS but got %q", paths)
+	}
+	if len(got) != 1 || got[0].Op != "create" || got[0].Seq != 7 || got[0].Path != tooBig.Ops[0].Path {
+		t.Fatalf("want the record of the op but got %+v", got)
+	}
+	if post, ok := got[0].Rec.(*bsky.FeedPost); !ok || post.Text != "hello" {
+		t.Fatalf("want the post decoded but got %#v", got[0].Rec)
+	}
+
+	tooBig.Ops = nil
+	got, paths = nil, nil
+	if err := s.readTooBig(context.Background(), &tooBig); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || !strings.HasPrefix(paths[0], "/xrpc/com.atproto.sync.getRepo?") {
+		t.Fatalf("want the repo fetched without the ops but got %q", paths)
+	}
+	if len(got) != 1 || got[0].Op != "update" || got[0].Path != commit.Ops[0].Path {
+		t.Fatalf("want the records of the repo as updates but got %+v", got)
+	}
+}
+
+func TestReadFirehoseFutureCursor(t *testing.T) {
+	ts := newFakeFirehose(t,
+		firehoseFrame(t, events.EventHeader{Op: events.EvtKindErrorFrame}, &events.ErrorFrame{Error: "FutureCursor", Message: "Cursor in the future."}),
+	)
+	con, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	s := &streamReader{handle: func(*streamEvent) error { return nil }}
+	s.cursor.Store(999)
+	err = s.readFirehose(context.Background(), con)
+	if err == nil || !strings.Contains(err.Error(), "FutureCursor") {
+		t.Fatalf("want FutureCursor but got %v", err)
+	}
+	if c := s.cursor.Load(); c != 0 {
+		t.Fatalf("want the cursor reset to resume from the live events but got %d", c)
+	}
+}
+
+func TestStreamFilter(t *testing.T) {
+	post := &streamEvent{
+		Kind: "commit",
+		Op:   "create",
+		Path: "app.bsky.feed.post/1",
+		Did:  "did:plc:alice",
+		Rec: &bsky.FeedPost{
+			Text:  "hello @bob.test",
+			Langs: []string{"en-US"},
+			Facets: []*bsky.RichtextFacet{{
+				Features: []*bsky.RichtextFacet_Features_Elem{{RichtextFacet_Mention: &bsky.RichtextFacet_Mention{Did: "did:plc:bob"}}},
+			}},
+			Embed: &bsky.FeedPost_Embed{EmbedImages: &bsky.EmbedImages{}},
+		},
+	}
+	like := &streamEvent{
+		Kind: "commit",
+		Op:   "create",
+		Path: "app.bsky.feed.like/2",
+		Did:  "did:plc:bob",
+		Rec:  &bsky.FeedLike{Subject: &comatproto.RepoStrongRef{Uri: "at://did:plc:alice/app.bsky.feed.post/1"}},
+	}
+	del := &streamEvent{Kind: "commit", Op: "delete", Path: "app.bsky.graph.follow/3", Did: "did:plc:carol"}
+	active := false
+	account := &streamEvent{Kind: "account", Did: "did:plc:carol", Active: &active, Status: &[]string{"deactivated"}[0]}
+
+	where := func(expr string) *template.Template {
+		tmpl, err := parseStreamWhere(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+	tests := []struct {
+		name   string
+		filter *streamFilter
+		want   []bool
+	}{
+		{"none", &streamFilter{}, []bool{true, true, true, true}},
+		{"collection", &streamFilter{collections: []string{"app.bsky.feed.*"}}, []bool{true, true, false, true}},
+		{"exact collection", &streamFilter{collections: []string{"app.bsky.feed.like"}}, []bool{false, true, false, true}},
+		{"did", &streamFilter{dids: map[string]bool{"did:plc:carol": true}}, []bool{false, false, true, true}},
+		{"op", &streamFilter{ops: []string{"delete"}}, []bool{false, false, true, true}},
+		{"lang", &streamFilter{langs: []string{"en"}}, []bool{true, false, false, true}},
+		{"other lang", &streamFilter{langs: []string{"ja"}}, []bool{false, false, false, true}},
+		{"media", &streamFilter{hasMedia: true}, []bool{true, false, false, true}},
+		{"mentions", &streamFilter{mentions: []string{"did:plc:bob"}}, []bool{true, false, false, true}},
+		{"where text", &streamFilter{where: where(`contains .rec.text "hello"`)}, []bool{true, false, false, false}},
+		{"where kind", &streamFilter{where: where(`ne .kind "commit"`)}, []bool{false, false, false, true}},
+		{"where subject", &streamFilter{where: where(`and (eq .op "create") (hasPrefix .rec.subject.uri "at://did:plc:alice/")`)}, []bool{false, true, false, false}},
+	}
+	for _, test := range tests {
+		for i, ev := range []*streamEvent{post, like, del, account} {
+			if got := test.filter.matches(ev); got != test.want[i] {
+				t.Errorf("%s: want %v but got %v for %s", test.name, test.want[i], got, ev.Path)
+			}
+		}
+	}
+
+	if _, err := parseStreamWhere(`}}`); err == nil {
+		t.Fatal("a broken expression should be an error")
+	}
+}
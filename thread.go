@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// threadNode is a post in a thread tree. Post is nil when the post is
+// blocked, not found or deleted.
+type threadNode struct {
+	Uri      string                  `json:"uri"`
+	Post     *bsky.FeedDefs_PostView `json:"post,omitempty"`
+	Blocked  bool                    `json:"blocked,omitempty"`
+	NotFound bool                    `json:"notFound,omitempty"`
+	Deleted  bool                    `json:"deleted,omitempty"`
+	Focus    bool                    `json:"focus,omitempty"`
+	Replies  []*threadNode           `json:"replies,omitempty"`
+}
+
+func newThreadNode(t *bsky.FeedDefs_ThreadViewPost) *threadNode {
+	node := &threadNode{Uri: t.Post.Uri, Post: t.Post}
+	for _, r := range t.Replies {
+		switch {
+		case r.FeedDefs_ThreadViewPost != nil:
+			node.Replies = append(node.Replies, newThreadNode(r.FeedDefs_ThreadViewPost))
+		case r.FeedDefs_BlockedPost != nil:
+			node.Replies = append(node.Replies, &threadNode{Uri: r.FeedDefs_BlockedPost.Uri, Blocked: true})
+		case r.FeedDefs_NotFoundPost != nil:
+			node.Replies = append(node.Replies, &threadNode{Uri: r.FeedDefs_NotFoundPost.Uri, NotFound: true})
+		}
+	}
+	return node
+}
+
+// replyParentUri returns the URI of the post which node replies to.
+func replyParentUri(node *threadNode) string {
+	if node.Post == nil || node.Post.Record == nil {
+		return ""
+	}
+	if rec, ok := node.Post.Record.Val.(*bsky.FeedPost); ok && rec.Reply != nil && rec.Reply.Parent != nil {
+		return rec.Reply.Parent.Uri
+	}
+	return ""
+}
+
+// newThreadTree makes a tree from the result of getPostThread. The tree
+// starts at the topmost parent fetched, and the requested post is marked as
+// Focus. A parent which is missing though it was replied to is marked as
+// Deleted.
+func newThreadTree(thread *bsky.FeedGetPostThread_Output_Thread, uri string, parentHeight int64) *threadNode {
+	switch {
+	case thread.FeedDefs_BlockedPost != nil:
+		return &threadNode{Uri: thread.FeedDefs_BlockedPost.Uri, Blocked: true, Focus: true}
+	case thread.FeedDefs_ThreadViewPost == nil:
+		return &threadNode{Uri: uri, NotFound: true, Focus: true}
+	}
+
+	top := newThreadNode(thread.FeedDefs_ThreadViewPost)
+	top.Focus = true
+	parent := thread.FeedDefs_ThreadViewPost.Parent
+	for height := int64(0); ; height++ {
+		var node *threadNode
+		var next *bsky.FeedDefs_ThreadViewPost_Parent
+		switch {
+		case parent == nil:
+			ref := replyParentUri(top)
+			if ref == "" || height >= parentHeight {
+				return top
+			}
+			node = &threadNode{Uri: ref, Deleted: true}
+		case parent.FeedDefs_ThreadViewPost != nil:
+			node = &threadNode{Uri: parent.FeedDefs_ThreadViewPost.Post.Uri, Post: parent.FeedDefs_ThreadViewPost.Post}
+			next = parent.FeedDefs_ThreadViewPost.Parent
+		case parent.FeedDefs_BlockedPost != nil:
+			node = &threadNode{Uri: parent.FeedDefs_BlockedPost.Uri, Blocked: true}
+		case parent.FeedDefs_NotFoundPost != nil:
+			node = &threadNode{Uri: parent.FeedDefs_NotFoundPost.Uri, Deleted: true}
+		default:
+			return top
+		}
+		node.Replies = []*threadNode{top}
+		top = node
+		if node.Post == nil {
+			return top
+		}
+		parent = next
+	}
+}
+
+func threadNodeTime(n *threadNode) time.Time {
+	if n.Post == nil || n.Post.Record == nil {
+		return time.Time{}
+	}
+	return postTime(n.Post)
+}
+
+// sortThread sorts replies in the tree by "likes" (most liked first) or by
+// "time" (oldest first). Replies which are not visible come last.
+func sortThread(n *threadNode, by string) {
+	sort.SliceStable(n.Replies, func(i, j int) bool {
+		a, b := n.Replies[i], n.Replies[j]
+		if (a.Post == nil) != (b.Post == nil) {
+			return a.Post != nil
+		}
+		if a.Post == nil {
+			return false
+		}
+		if by == "likes" && int64p(a.Post.LikeCount) != int64p(b.Post.LikeCount) {
+			return int64p(a.Post.LikeCount) > int64p(b.Post.LikeCount)
+		}
+		return threadNodeTime(a).Before(threadNodeTime(b))
+	})
+	for _, r := range n.Replies {
+		sortThread(r, by)
+	}
+}
+
+// printThread prints the tree. Parents are printed flat, and replies of the
+// requested post are printed as an indented tree.
+func printThread(r *renderer, root *threadNode) {
+	n := root
+	for !n.Focus && len(n.Replies) == 1 {
+		printThreadPost(r, n, "", "")
+		n = n.Replies[0]
+	}
+	printThreadNode(r, n, "", "")
+}
+
+func printThreadNode(r *renderer, n *threadNode, first, rest string) {
+	printThreadPost(r, n, first, rest)
+	for i, reply := range n.Replies {
+		if i == len(n.Replies)-1 {
+			printThreadNode(r, reply, rest+"└─ ", rest+"   ")
+		} else {
+			printThreadNode(r, reply, rest+"├─ ", rest+"│  ")
+		}
+	}
+}
+
+func printThreadPost(r *renderer, n *threadNode, first, rest string) {
+	var lines []string
+	if n.Post == nil {
+		lines = r.postLines(newExportPost(n), r.width-visibleWidth(rest))
+		lines = append(lines, " - "+r.span(span{text: n.Uri, url: webURL(n.Uri, ""), attrs: []color.Attribute{color.FgBlue}}))
+	} else {
+		lines = r.post(n.Post, r.width-visibleWidth(rest))
+	}
+	if n.Focus {
+		lines[0] = "▶ " + lines[0]
+	}
+	for i, l := range lines {
+		if i == 0 {
+			r.println(first + l)
+		} else {
+			r.println(rest + l)
+		}
+	}
+	r.println(strings.TrimRight(rest, " "))
+}
+
+func doThread(cCtx *cli.Context) error {
+	if !cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	by := cCtx.String("sort")
+	if by != "likes" && by != "time" {
+		return fmt.Errorf("invalid sort: %q (must be likes or time)", by)
+	}
+	format := cCtx.String("export")
+	if format != "" && format != "md" && format != "html" {
+		return fmt.Errorf("invalid export format: %q (must be md or html)", format)
+	}
+	output := cCtx.String("o")
+	if cCtx.Bool("download-images") && (format == "" || output == "") {
+		return fmt.Errorf("--download-images requires --export and -o")
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	arg := atURI(cCtx.Args().First())
+
+	n := cCtx.Int64("n")
+	resp, err := bsky.FeedGetPostThread(context.TODO(), xrpcc, cCtx.Int64("depth"), n, arg)
+	if err != nil {
+		return fmt.Errorf("cannot get post thread: %w", err)
+	}
+
+	root := newThreadTree(resp.Thread, arg, n)
+	sortThread(root, by)
+
+	if cCtx.Bool("json") {
+		json.NewEncoder(os.Stdout).Encode(root)
+		return nil
+	}
+
+	if format == "" {
+		printThread(newRenderer(cCtx), root)
+		return nil
+	}
+
+	x := &threadExporter{format: format}
+	if cCtx.Bool("download-images") {
+		// images are saved in the directory next to the output like foo_files
+		x.imageRef = strings.TrimSuffix(filepath.Base(output), filepath.Ext(output)) + "_files"
+		x.imageDir = filepath.Join(filepath.Dir(output), x.imageRef)
+		x.images = map[string]string{}
+		if err := os.MkdirAll(x.imageDir, 0755); err != nil {
+			return fmt.Errorf("cannot create image directory: %w", err)
+		}
+	}
+	if output == "" {
+		return x.export(os.Stdout, root)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("cannot create output file: %w", err)
+	}
+	if err := x.export(f, root); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write output file: %w", err)
+	}
+	return f.Close()
+}
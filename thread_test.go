@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+func testThreadPost(uri, parent, createdAt string, likes int64) *bsky.FeedDefs_PostView {
+	rec := &bsky.FeedPost{Text: uri, CreatedAt: createdAt}
+	if parent != "" {
+		rec.Reply = &bsky.FeedPost_ReplyRef{Parent: &comatproto.RepoStrongRef{Uri: parent}}
+	}
+	return &bsky.FeedDefs_PostView{
+		Uri:       uri,
+		Author:    &bsky.ActorDefs_ProfileViewBasic{Handle: "mattn.jp"},
+		Record:    &lexutil.LexiconTypeDecoder{Val: rec},
+		LikeCount: &likes,
+	}
+}
+
+func TestNewThreadTree(t *testing.T) {
+	thread := &bsky.FeedGetPostThread_Output_Thread{
+		FeedDefs_ThreadViewPost: &bsky.FeedDefs_ThreadViewPost{
+			Post: testThreadPost("at://3", "at://2", "2025-01-03T00:00:00Z", 0),
+			Parent: &bsky.FeedDefs_ThreadViewPost_Parent{
+				FeedDefs_ThreadViewPost: &bsky.FeedDefs_ThreadViewPost{
+					Post: testThreadPost("at://2", "at://1", "2025-01-02T00:00:00Z", 0),
+				},
+			},
+			Replies: []*bsky.FeedDefs_ThreadViewPost_Replies_Elem{
+				{FeedDefs_BlockedPost: &bsky.FeedDefs_BlockedPost{Uri: "at://blocked"}},
+				{FeedDefs_ThreadViewPost: &bsky.FeedDefs_ThreadViewPost{
+					Post: testThreadPost("at://4", "at://3", "2025-01-04T00:00:00Z", 1),
+				}},
+				{FeedDefs_ThreadViewPost: &bsky.FeedDefs_ThreadViewPost{
+					Post: testThreadPost("at://5", "at://3", "2025-01-05T00:00:00Z", 5),
+				}},
+			},
+		},
+	}
+
+	root := newThreadTree(thread, "at://3", 10)
+	if root.Uri != "at://1" || !root.Deleted {
+		t.Fatalf("want deleted at://1 on the top but got %+v", root)
+	}
+	if len(root.Replies) != 1 || root.Replies[0].Uri != "at://2" {
+		t.Fatal("want at://2 under at://1")
+	}
+	focus := root.Replies[0].Replies[0]
+	if focus.Uri != "at://3" || !focus.Focus {
+		t.Fatalf("want focused at://3 but got %+v", focus)
+	}
+
+	sortThread(root, "time")
+	if got := []string{focus.Replies[0].Uri, focus.Replies[1].Uri, focus.Replies[2].Uri}; got[0] != "at://4" || got[1] != "at://5" || got[2] != "at://blocked" {
+		t.Fatalf("unexpected order by time: %v", got)
+	}
+	sortThread(root, "likes")
+	if got := []string{focus.Replies[0].Uri, focus.Replies[1].Uri, focus.Replies[2].Uri}; got[0] != "at://5" || got[1] != "at://4" || got[2] != "at://blocked" {
+		t.Fatalf("unexpected order by likes: %v", got)
+	}
+
+	if root := newThreadTree(thread, "at://3", 1); root.Uri != "at://2" {
+		t.Fatalf("want at://2 on the top with parent height 1 but got %s", root.Uri)
+	}
+	notFound := newThreadTree(&bsky.FeedGetPostThread_Output_Thread{
+		FeedDefs_NotFoundPost: &bsky.FeedDefs_NotFoundPost{Uri: "at://x", NotFound: true},
+	}, "at://x", 10)
+	if !notFound.NotFound || !notFound.Focus {
+		t.Fatalf("want not found node but got %+v", notFound)
+	}
+}
@@ -4,83 +4,51 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
-	"os/signal"
 	"path/filepath"
-	"regexp"
-	"sort"
+	"slices"
 	"strings"
-	"syscall"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
-	"github.com/bluesky-social/indigo/events"
-	"github.com/bluesky-social/indigo/events/schedulers/sequential"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
-	"github.com/bluesky-social/indigo/repo"
-	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/bluesky-social/indigo/xrpc"
 	"github.com/fatih/color"
-	cid "github.com/ipfs/go-cid"
 	"golang.org/x/net/html/charset"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/gorilla/websocket"
 	encoding "github.com/mattn/go-encoding"
 	"github.com/urfave/cli/v2"
 )
 
-func doThread(cCtx *cli.Context) error {
-	if !cCtx.Args().Present() {
-		return cli.ShowSubcommandHelp(cCtx)
-	}
-
-	xrpcc, err := makeXRPCC(cCtx)
-	if err != nil {
-		return fmt.Errorf("cannot create client: %w", err)
-	}
+// authorFeedFilters are the filters of app.bsky.feed.getAuthorFeed.
+var authorFeedFilters = []string{
+	"posts_with_replies",
+	"posts_no_replies",
+	"posts_with_media",
+	"posts_and_author_threads",
+	"posts_with_video",
+}
 
-	arg := cCtx.Args().First()
-	if !strings.HasPrefix(arg, "at://did:plc:") {
-		arg = "at://did:plc:" + arg
+func doTimeline(cCtx *cli.Context) error {
+	if cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
 	}
 
-	n := cCtx.Int64("n")
-	resp, err := bsky.FeedGetPostThread(context.TODO(), xrpcc, 0, n, arg)
+	filter, err := newFeedFilter(cCtx)
 	if err != nil {
-		return fmt.Errorf("cannot get post thread: %w", err)
-	}
-
-	replies := resp.Thread.FeedDefs_ThreadViewPost.Replies
-	if cCtx.Bool("json") {
-		json.NewEncoder(os.Stdout).Encode(resp.Thread.FeedDefs_ThreadViewPost)
-		for _, p := range replies {
-			json.NewEncoder(os.Stdout).Encode(p)
-		}
-		return nil
-	}
-
-	for i := 0; i < len(replies)/2; i++ {
-		replies[i], replies[len(replies)-i-1] = replies[len(replies)-i-1], replies[i]
+		return err
 	}
-	printPost(resp.Thread.FeedDefs_ThreadViewPost.Post)
-	for _, r := range replies {
-		printPost(r.FeedDefs_ThreadViewPost.Post)
+	if cCtx.String("handle") == "" && (cCtx.IsSet("filter") || cCtx.Bool("pins")) {
+		return fmt.Errorf("--filter and --pins are available only with --handle")
 	}
-	return nil
-}
-
-func doTimeline(cCtx *cli.Context) error {
-	if cCtx.Args().Present() {
-		return cli.ShowSubcommandHelp(cCtx)
+	if s := cCtx.String("filter"); s != "" && !slices.Contains(authorFeedFilters, s) {
+		return fmt.Errorf("invalid filter: %q (must be one of %s)", s, strings.Join(authorFeedFilters, ", "))
 	}
 
 	xrpcc, err := makeXRPCC(cCtx)
@@ -88,73 +56,61 @@ func doTimeline(cCtx *cli.Context) error {
 		return fmt.Errorf("cannot create client: %w", err)
 	}
 
-	var feed []*bsky.FeedDefs_FeedViewPost
-
 	n := cCtx.Int64("n")
 	handle := cCtx.String("handle")
+	if handle == "self" {
+		handle = xrpcc.Auth.Did
+	}
+	authorFilter := cCtx.String("filter")
+	pins := cCtx.Bool("pins")
 
-	var cursor string
-
-	for {
+	fetch := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
 		if handle != "" {
-			if handle == "self" {
-				handle = xrpcc.Auth.Did
-			}
-			resp, err := bsky.FeedGetAuthorFeed(context.TODO(), xrpcc, handle, cursor, "", false, n)
+			resp, err := bsky.FeedGetAuthorFeed(context.TODO(), xrpcc, handle, cursor, authorFilter, pins, n)
 			if err != nil {
-				return fmt.Errorf("cannot get author feed: %w", err)
-			}
-			feed = append(feed, resp.Feed...)
-			if resp.Cursor != nil {
-				cursor = *resp.Cursor
-			} else {
-				cursor = ""
-			}
-		} else {
-			resp, err := bsky.FeedGetTimeline(context.TODO(), xrpcc, "reverse-chronological", cursor, n)
-			if err != nil {
-				return fmt.Errorf("cannot get timeline: %w", err)
-			}
-			feed = append(feed, resp.Feed...)
-			if resp.Cursor != nil {
-				cursor = *resp.Cursor
-			} else {
-				cursor = ""
+				return nil, nil, fmt.Errorf("cannot get author feed: %w", err)
 			}
+			return resp.Feed, resp.Cursor, nil
 		}
-		if cursor == "" || int64(len(feed)) > n {
-			break
+		resp, err := bsky.FeedGetTimeline(context.TODO(), xrpcc, "reverse-chronological", cursor, n)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get timeline: %w", err)
 		}
+		return resp.Feed, resp.Cursor, nil
 	}
 
-	sort.Slice(feed, func(i, j int) bool {
-		ri := timep(feed[i].Post.Record.Val.(*bsky.FeedPost).CreatedAt)
-		rj := timep(feed[j].Post.Record.Val.(*bsky.FeedPost).CreatedAt)
-		return ri.Before(rj)
-	})
-	if int64(len(feed)) > n {
-		feed = feed[len(feed)-int(n):]
-	}
-	if cCtx.Bool("json") {
-		for _, p := range feed {
-			json.NewEncoder(os.Stdout).Encode(p)
-		}
-	} else {
-		for _, p := range feed {
-			//if p.Reason != nil {
-			//continue
-			//}
-			printPost(p.Post)
+	if cCtx.Bool("new") || cCtx.Bool("f") {
+		key := "timeline"
+		if handle != "" {
+			key = "author:" + handle
+			if authorFilter != "" {
+				key += "?filter=" + authorFilter
+			}
 		}
+		return showFeed(cCtx, xrpcc, key, filter, true, fetch)
 	}
 
-	return nil
+	feed, err := collectFeed(n, filter, fetch)
+	if err != nil {
+		return err
+	}
+
+	return printFeedOnce(cCtx, sortFeed(feed, n))
 }
 
 func doDelete(cCtx *cli.Context) error {
 	if !cCtx.Args().Present() {
+		if hasDeleteFilter(cCtx) {
+			return doBulkDelete(cCtx)
+		}
+		if hasDeleteOption(cCtx) {
+			return fmt.Errorf("specify --before, --after or --match, or --all to delete every record")
+		}
 		return cli.ShowSubcommandHelp(cCtx)
 	}
+	if hasDeleteOption(cCtx) {
+		return fmt.Errorf("cannot use filters with uris")
+	}
 
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
@@ -162,11 +118,9 @@ func doDelete(cCtx *cli.Context) error {
 	}
 
 	for _, arg := range cCtx.Args().Slice() {
-		if !strings.HasPrefix(arg, "at://did:plc:") {
-			arg = "at://did:plc:" + arg
-		}
+		arg = atURI(arg)
 		parts := strings.Split(arg, "/")
-		if len(parts) < 3 {
+		if len(parts) < 5 {
 			return fmt.Errorf("invalid post uri: %q", arg)
 		}
 		rkey := parts[len(parts)-1]
@@ -274,7 +228,8 @@ func addLink(xrpcc *xrpc.Client, post *bsky.FeedPost, link string) {
 
 func doPost(cCtx *cli.Context) error {
 	stdin := cCtx.Bool("stdin")
-	if !stdin && !cCtx.Args().Present() {
+	edit := cCtx.Bool("e")
+	if !stdin && !edit && !cCtx.Args().Present() {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 	text := strings.Join(cCtx.Args().Slice(), " ")
@@ -285,7 +240,7 @@ func doPost(cCtx *cli.Context) error {
 		}
 		text = string(b)
 	}
-	if strings.TrimSpace(text) == "" {
+	if !edit && strings.TrimSpace(text) == "" {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
@@ -294,67 +249,46 @@ func doPost(cCtx *cli.Context) error {
 		return fmt.Errorf("cannot create client: %w", err)
 	}
 
-	// reply
-	var reply *bsky.FeedPost_ReplyRef
-	replyTo := cCtx.String("r")
-	if replyTo != "" {
-		parts := strings.Split(replyTo, "/")
-		if len(parts) < 3 {
-			return fmt.Errorf("invalid post uri: %q", replyTo)
-		}
-		rkey := parts[len(parts)-1]
-		collection := parts[len(parts)-2]
-		did := parts[2]
-
-		resp, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", collection, did, rkey)
-		if err != nil {
-			return fmt.Errorf("cannot get record: %w", err)
-		}
-		orig := resp.Value.Val.(*bsky.FeedPost)
-		reply = &bsky.FeedPost_ReplyRef{
-			Root:   &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri},
-			Parent: &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri},
-		}
-		if orig.Reply != nil && orig.Reply.Root != nil {
-			reply.Root = &comatproto.RepoStrongRef{Cid: orig.Reply.Root.Cid, Uri: orig.Reply.Root.Uri}
-		} else {
-			reply.Root = &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri}
-		}
-	}
-
-	post := &bsky.FeedPost{
+	d := &draft{
 		Text:      text,
-		CreatedAt: time.Now().Local().Format(time.RFC3339),
-		Reply:     reply,
+		Reply:     cCtx.String("r"),
+		Quote:     cCtx.String("q"),
+		Images:    cCtx.StringSlice("image"),
+		ImageAlts: cCtx.StringSlice("image-alt"),
+		Video:     cCtx.String("video"),
+		VideoAlt:  cCtx.String("video-alt"),
 	}
 
-	// quote
-	quoteTo := cCtx.String("q")
-	if quoteTo != "" {
-		parts := strings.Split(quoteTo, "/")
-		if len(parts) < 3 {
-			return fmt.Errorf("invalid post uri: %q", quoteTo)
+	if edit {
+		if err := editDraft(xrpcc, d, true); err != nil {
+			return err
 		}
-		rkey := parts[len(parts)-1]
-		collection := parts[len(parts)-2]
-		did := parts[2]
-
-		resp, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", collection, did, rkey)
-		if err != nil {
-			return fmt.Errorf("cannot get record: %w", err)
+		if strings.TrimSpace(d.Text) == "" {
+			return fmt.Errorf("empty post, aborted")
 		}
+	}
 
-		if post.Embed == nil {
-			post.Embed = &bsky.FeedPost_Embed{}
-		}
-		post.Embed.EmbedRecord = &bsky.EmbedRecord{
-			//LexiconTypeID: "app.bsky.feed.post",
-			Record: &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri},
+	resp, err := createPost(xrpcc, d)
+	if err != nil {
+		if edit {
+			// keep the text written in the editor
+			if name, err2 := saveDraft(cCtx, "", d); err2 == nil {
+				fmt.Fprintf(os.Stderr, "saved as draft %s\n", name)
+			}
 		}
+		return err
 	}
+	fmt.Println(resp.Uri)
+
+	return nil
+}
+
+// makeFacets returns the link, mention and tag facets found in text.
+func makeFacets(xrpcc *xrpc.Client, text string) []*bsky.RichtextFacet {
+	var facets []*bsky.RichtextFacet
 
 	for _, entry := range extractLinksBytes(text) {
-		post.Facets = append(post.Facets, &bsky.RichtextFacet{
+		facets = append(facets, &bsky.RichtextFacet{
 			Features: []*bsky.RichtextFacet_Features_Elem{
 				{
 					RichtextFacet_Link: &bsky.RichtextFacet_Link{
@@ -367,8 +301,6 @@ func doPost(cCtx *cli.Context) error {
 				ByteEnd:   entry.end,
 			},
 		})
-
-		addLink(xrpcc, post, entry.text)
 	}
 
 	for _, entry := range extractMentionsBytes(text) {
@@ -376,7 +308,7 @@ func doPost(cCtx *cli.Context) error {
 		if err != nil {
 			continue
 		}
-		post.Facets = append(post.Facets, &bsky.RichtextFacet{
+		facets = append(facets, &bsky.RichtextFacet{
 			Features: []*bsky.RichtextFacet_Features_Elem{
 				{
 					RichtextFacet_Mention: &bsky.RichtextFacet_Mention{
@@ -392,7 +324,7 @@ func doPost(cCtx *cli.Context) error {
 	}
 
 	for _, entry := range extractTagsBytes(text) {
-		post.Facets = append(post.Facets, &bsky.RichtextFacet{
+		facets = append(facets, &bsky.RichtextFacet{
 			Features: []*bsky.RichtextFacet_Features_Elem{
 				{
 					RichtextFacet_Tag: &bsky.RichtextFacet_Tag{
@@ -407,23 +339,92 @@ func doPost(cCtx *cli.Context) error {
 		})
 	}
 
+	return facets
+}
+
+// createPost validates the text of d and creates a post record from it.
+func createPost(xrpcc *xrpc.Client, d *draft) (*comatproto.RepoCreateRecord_Output, error) {
+	if err := validatePostText(d.Text); err != nil {
+		return nil, err
+	}
+
+	// reply
+	var reply *bsky.FeedPost_ReplyRef
+	if d.Reply != "" {
+		parts := strings.Split(d.Reply, "/")
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("invalid post uri: %q", d.Reply)
+		}
+		rkey := parts[len(parts)-1]
+		collection := parts[len(parts)-2]
+		did := parts[2]
+
+		resp, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", collection, did, rkey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get record: %w", err)
+		}
+		orig := resp.Value.Val.(*bsky.FeedPost)
+		reply = &bsky.FeedPost_ReplyRef{
+			Root:   &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri},
+			Parent: &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri},
+		}
+		if orig.Reply != nil && orig.Reply.Root != nil {
+			reply.Root = &comatproto.RepoStrongRef{Cid: orig.Reply.Root.Cid, Uri: orig.Reply.Root.Uri}
+		} else {
+			reply.Root = &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri}
+		}
+	}
+
+	post := &bsky.FeedPost{
+		Text:      d.Text,
+		CreatedAt: time.Now().Local().Format(time.RFC3339),
+		Reply:     reply,
+	}
+
+	// quote
+	if d.Quote != "" {
+		parts := strings.Split(d.Quote, "/")
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("invalid post uri: %q", d.Quote)
+		}
+		rkey := parts[len(parts)-1]
+		collection := parts[len(parts)-2]
+		did := parts[2]
+
+		resp, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", collection, did, rkey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get record: %w", err)
+		}
+
+		if post.Embed == nil {
+			post.Embed = &bsky.FeedPost_Embed{}
+		}
+		post.Embed.EmbedRecord = &bsky.EmbedRecord{
+			//LexiconTypeID: "app.bsky.feed.post",
+			Record: &comatproto.RepoStrongRef{Cid: *resp.Cid, Uri: resp.Uri},
+		}
+	}
+
+	post.Facets = makeFacets(xrpcc, d.Text)
+	for _, entry := range extractLinksBytes(d.Text) {
+		addLink(xrpcc, post, entry.text)
+	}
+
 	// embeded images
-	imageFn := cCtx.StringSlice("image")
-	imageAltFn := cCtx.StringSlice("image-alt")
-	if len(imageFn) > 0 {
+	if len(d.Images) > 0 {
 		var images []*bsky.EmbedImages_Image
-		for i, fn := range imageFn {
+		for i, fn := range d.Images {
 			b, err := os.ReadFile(fn)
 			if err != nil {
-				return fmt.Errorf("cannot read image file: %w", err)
+				return nil, fmt.Errorf("cannot read image file: %w", err)
 			}
 			resp, err := comatproto.RepoUploadBlob(context.TODO(), xrpcc, bytes.NewReader(b))
 			if err != nil {
-				return fmt.Errorf("cannot upload image file: %w", err)
+				return nil, fmt.Errorf("cannot upload image file: %w", err)
 			}
 			var alt string
-			if i < len(imageAltFn) {
-				alt = imageAltFn[i]
+			if i < len(d.ImageAlts) {
+				alt = d.ImageAlts[i]
 			} else {
 				alt = filepath.Base(fn)
 			}
@@ -445,22 +446,20 @@ func doPost(cCtx *cli.Context) error {
 	}
 
 	// embeded videos
-	videoFn := cCtx.String("video")
-	videoAltFn := cCtx.String("video-alt")
-	if videoFn != "" {
-		b, err := os.ReadFile(videoFn)
+	if d.Video != "" {
+		b, err := os.ReadFile(d.Video)
 		if err != nil {
-			return fmt.Errorf("cannot read video file: %w", err)
+			return nil, fmt.Errorf("cannot read video file: %w", err)
 		}
 		resp, err := comatproto.RepoUploadBlob(context.TODO(), xrpcc, bytes.NewReader(b))
 		if err != nil {
-			return fmt.Errorf("cannot upload video file: %w", err)
+			return nil, fmt.Errorf("cannot upload video file: %w", err)
 		}
 		var alt string
-		if videoAltFn != "" {
-			alt = videoAltFn
+		if d.VideoAlt != "" {
+			alt = d.VideoAlt
 		} else {
-			alt = filepath.Base(videoFn)
+			alt = filepath.Base(d.Video)
 		}
 		if post.Embed == nil {
 			post.Embed = &bsky.FeedPost_Embed{}
@@ -484,9 +483,107 @@ func doPost(cCtx *cli.Context) error {
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create post: %w", err)
+		return nil, fmt.Errorf("failed to create post: %w", err)
+	}
+	return resp, nil
+}
+
+// isInvalidSwap reports whether err is the error returned when the swap CID
+// of a write does not match the current record.
+func isInvalidSwap(err error) bool {
+	var xrpcErr *xrpc.Error
+	if !errors.As(err, &xrpcErr) {
+		return false
+	}
+	var xe *xrpc.XRPCError
+	return errors.As(xrpcErr.Wrapped, &xe) && xe.ErrStr == "InvalidSwap"
+}
+
+func doEdit(cCtx *cli.Context) error {
+	if !cCtx.Args().Present() {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	arg := atURI(cCtx.Args().First())
+	parts := strings.Split(arg, "/")
+	if len(parts) < 5 {
+		return fmt.Errorf("invalid post uri: %q", arg)
+	}
+	rkey := parts[len(parts)-1]
+	collection := parts[len(parts)-2]
+	did := parts[2]
+	if collection != "app.bsky.feed.post" {
+		return fmt.Errorf("not a post: %q", arg)
+	}
+	if did != xrpcc.Auth.Did && did != xrpcc.Auth.Handle {
+		return fmt.Errorf("cannot edit a post of another user: %q", arg)
+	}
+
+	resp, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", collection, did, rkey)
+	if err != nil {
+		return fmt.Errorf("cannot get record: %w", err)
+	}
+	if resp.Cid == nil {
+		return fmt.Errorf("cannot get CID of the record: %q", arg)
+	}
+	post, ok := resp.Value.Val.(*bsky.FeedPost)
+	if !ok {
+		return fmt.Errorf("not a post: %q", arg)
 	}
-	fmt.Println(resp.Uri)
+
+	var text string
+	if cCtx.Bool("stdin") {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		text = string(b)
+	} else if cCtx.Args().Len() > 1 {
+		text = strings.Join(cCtx.Args().Slice()[1:], " ")
+	} else {
+		d := &draft{Text: post.Text}
+		if post.Reply != nil && post.Reply.Parent != nil {
+			d.Reply = post.Reply.Parent.Uri
+		}
+		if err := editDraft(xrpcc, d, true); err != nil {
+			return err
+		}
+		text = d.Text
+	}
+	if err := validatePostText(text); err != nil {
+		return err
+	}
+	if text == post.Text {
+		return fmt.Errorf("post is not changed")
+	}
+
+	post.Text = text
+	post.Facets = makeFacets(xrpcc, text)
+	if !cCtx.Bool("keep-created-at") {
+		post.CreatedAt = time.Now().Local().Format(time.RFC3339)
+	}
+
+	putResp, err := comatproto.RepoPutRecord(context.TODO(), xrpcc, &comatproto.RepoPutRecord_Input{
+		Repo:       xrpcc.Auth.Did,
+		Collection: collection,
+		Rkey:       rkey,
+		Record: &lexutil.LexiconTypeDecoder{
+			Val: post,
+		},
+		SwapRecord: resp.Cid,
+	})
+	if err != nil {
+		if isInvalidSwap(err) {
+			return fmt.Errorf("the post was changed after it was fetched (cid %s), so it was not overwritten. run edit again to start from the current post", *resp.Cid)
+		}
+		return fmt.Errorf("cannot update post: %w", err)
+	}
+	fmt.Println(putResp.Uri)
 
 	return nil
 }
@@ -518,31 +615,44 @@ func doVote(cCtx *cli.Context) error {
 			return fmt.Errorf("getting record: %w", err)
 		}
 
-		voteResp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
-			Collection: "app.bsky.feed.like",
-			Repo:       xrpcc.Auth.Did,
-			Record: &lexutil.LexiconTypeDecoder{
-				Val: &bsky.FeedLike{
-					CreatedAt: time.Now().Format("2006-01-02T15:04:05.000Z"),
-					Subject:   &comatproto.RepoStrongRef{Uri: resp.Uri, Cid: *resp.Cid},
-				},
-			},
-		})
-
+		uri, err := createLike(xrpcc, &comatproto.RepoStrongRef{Uri: resp.Uri, Cid: *resp.Cid})
 		if err != nil {
-			return fmt.Errorf("cannot create vote: %w", err)
+			return err
 		}
-		fmt.Println(voteResp.Uri)
+		fmt.Println(uri)
 	}
 
 	return nil
 }
 
+// createLike likes the post of ref and returns the URI of the like record.
+func createLike(xrpcc *xrpc.Client, ref *comatproto.RepoStrongRef) (string, error) {
+	resp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.feed.like",
+		Repo:       xrpcc.Auth.Did,
+		Record: &lexutil.LexiconTypeDecoder{
+			Val: &bsky.FeedLike{
+				CreatedAt: time.Now().Format("2006-01-02T15:04:05.000Z"),
+				Subject:   ref,
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot create vote: %w", err)
+	}
+	return resp.Uri, nil
+}
+
 func doVotes(cCtx *cli.Context) error {
 	if !cCtx.Args().Present() {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -570,11 +680,13 @@ func doVotes(cCtx *cli.Context) error {
 		return fmt.Errorf("getting votes: %w", err)
 	}
 
-	if cCtx.Bool("json") {
+	if out != nil {
 		for _, v := range votes.Likes {
-			json.NewEncoder(os.Stdout).Encode(v)
+			if err := out.writeAs(v, v.Actor); err != nil {
+				return err
+			}
 		}
-		return nil
+		return out.close()
 	}
 
 	for _, v := range votes.Likes {
@@ -616,34 +728,45 @@ func doRepost(cCtx *cli.Context) error {
 			return fmt.Errorf("getting record: %w", err)
 		}
 
-		repost := &bsky.FeedRepost{
-			CreatedAt: time.Now().Local().Format(time.RFC3339),
-			Subject: &comatproto.RepoStrongRef{
-				Uri: resp.Uri,
-				Cid: *resp.Cid,
-			},
-		}
-		repostResp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
-			Collection: "app.bsky.feed.repost",
-			Repo:       xrpcc.Auth.Did,
-			Record: &lexutil.LexiconTypeDecoder{
-				Val: repost,
-			},
-		})
+		uri, err := createRepost(xrpcc, &comatproto.RepoStrongRef{Uri: resp.Uri, Cid: *resp.Cid})
 		if err != nil {
-			return fmt.Errorf("cannot create repost: %w", err)
+			return err
 		}
-		fmt.Println(repostResp.Uri)
+		fmt.Println(uri)
 	}
 
 	return nil
 }
 
+// createRepost reposts the post of ref and returns the URI of the repost
+// record.
+func createRepost(xrpcc *xrpc.Client, ref *comatproto.RepoStrongRef) (string, error) {
+	resp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.feed.repost",
+		Repo:       xrpcc.Auth.Did,
+		Record: &lexutil.LexiconTypeDecoder{
+			Val: &bsky.FeedRepost{
+				CreatedAt: time.Now().Local().Format(time.RFC3339),
+				Subject:   ref,
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot create repost: %w", err)
+	}
+	return resp.Uri, nil
+}
+
 func doReposts(cCtx *cli.Context) error {
 	if !cCtx.Args().Present() {
 		return cli.ShowSubcommandHelp(cCtx)
 	}
 
+	out, err := newOutput(cCtx)
+	if err != nil {
+		return err
+	}
+
 	xrpcc, err := makeXRPCC(cCtx)
 	if err != nil {
 		return fmt.Errorf("cannot create client: %w", err)
@@ -671,11 +794,13 @@ func doReposts(cCtx *cli.Context) error {
 		return fmt.Errorf("getting reposts: %w", err)
 	}
 
-	if cCtx.Bool("json") {
+	if out != nil {
 		for _, r := range reposts.RepostedBy {
-			json.NewEncoder(os.Stdout).Encode(r)
+			if err := out.write(r); err != nil {
+				return err
+			}
 		}
-		return nil
+		return out.close()
 	}
 
 	for _, r := range reposts.RepostedBy {
@@ -688,202 +813,3 @@ func doReposts(cCtx *cli.Context) error {
 
 	return nil
 }
-
-func streamHost(host, cursor string) (string, error) {
-	u, err := url.Parse(host)
-	if err != nil {
-		return "", err
-	}
-	u.Scheme = "wss"
-	u.Path = "/xrpc/com.atproto.sync.subscribeRepos"
-	if cursor != "" {
-		q := u.Query()
-		q.Set("cursor", cursor)
-		u.RawQuery = q.Encode()
-	}
-	return u.String(), nil
-}
-
-func doStream(cCtx *cli.Context) error {
-	var host string
-	if cCtx.Args().Present() {
-		host = cCtx.Args().First()
-	} else {
-		cfg := cCtx.App.Metadata["config"].(*config)
-		host = cfg.Bgs
-		if host == "" {
-			host = cfg.Host
-		}
-		var err error
-		host, err = streamHost(host, cCtx.String("cursor"))
-		if err != nil {
-			return err
-		}
-	}
-	pattern := cCtx.String("pattern")
-	reply := cCtx.String("reply")
-
-	var re *regexp.Regexp
-	if pattern != "" {
-		var err error
-		re, err = regexp.Compile(pattern)
-		if err != nil {
-			return err
-		}
-	}
-
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGINT)
-
-	con, _, err := websocket.DefaultDialer.Dial(host, http.Header{})
-	if err != nil {
-		return fmt.Errorf("dial failure: %w", err)
-	}
-
-	defer func() {
-		_ = con.Close()
-	}()
-
-	ctx, cancel := context.WithCancel(context.Background())
-
-	go func() {
-		<-ch
-		cancel()
-		con.Close()
-	}()
-
-	enc := json.NewEncoder(os.Stdout)
-
-	cb := func(op repomgr.EventKind, seq int64, path string, did string, rcid *cid.Cid, rec any) error {
-		type Rec struct {
-			Op   repomgr.EventKind `json:"op"`
-			Seq  int64             `json:"seq"`
-			Path string            `json:"path"`
-			Did  string            `json:"did"`
-			Rcid *cid.Cid          `json:"rcid"`
-			Rec  any               `json:"rec"`
-		}
-
-		orig, isPost := rec.(*bsky.FeedPost)
-
-		if re != nil {
-			if !isPost || !re.MatchString(orig.Text) {
-				return nil
-			}
-		}
-		if cCtx.Bool("json") {
-			enc.Encode(Rec{
-				Op:   op,
-				Seq:  seq,
-				Path: path,
-				Did:  did,
-				Rcid: rcid,
-				Rec:  rec,
-			})
-		} else if isPost {
-			xrpcc, err := makeXRPCC(cCtx)
-			if err != nil {
-				return fmt.Errorf("cannot create client: %w", err)
-			}
-			var post bsky.FeedDefs_PostView
-			if author, err := bsky.ActorGetProfile(context.TODO(), xrpcc, did); err == nil {
-				post.Author = &bsky.ActorDefs_ProfileViewBasic{
-					Avatar:      author.Avatar,
-					Did:         author.Did,
-					DisplayName: author.DisplayName,
-					Handle:      author.Handle,
-					Labels:      author.Labels,
-					Viewer:      author.Viewer,
-				}
-				post.Record = &lexutil.LexiconTypeDecoder{
-					Val: orig,
-				}
-				printPost(&post)
-			}
-		}
-		if orig != nil && reply != "" {
-			xrpcc, err := makeXRPCC(cCtx)
-			if err != nil {
-				return fmt.Errorf("cannot create client: %w", err)
-			}
-			parts := strings.Split(path, "/")
-			getResp, err := comatproto.RepoGetRecord(context.TODO(), xrpcc, "", parts[0], did, parts[1])
-			if err != nil {
-				return fmt.Errorf("cannot get record: %w", err)
-			}
-
-			orig := getResp.Value.Val.(*bsky.FeedPost)
-			replyTo := &bsky.FeedPost_ReplyRef{
-				Root:   &comatproto.RepoStrongRef{Cid: *getResp.Cid, Uri: getResp.Uri},
-				Parent: &comatproto.RepoStrongRef{Cid: *getResp.Cid, Uri: getResp.Uri},
-			}
-			if orig.Reply != nil && orig.Reply.Root != nil {
-				replyTo.Root = &comatproto.RepoStrongRef{Cid: orig.Reply.Root.Cid, Uri: orig.Reply.Root.Uri}
-			} else {
-				replyTo.Root = &comatproto.RepoStrongRef{Cid: *getResp.Cid, Uri: getResp.Uri}
-			}
-			post := &bsky.FeedPost{
-				Text:      reply,
-				CreatedAt: time.Now().Local().Format(time.RFC3339),
-				Reply:     replyTo,
-			}
-
-			resp, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
-				Collection: "app.bsky.feed.post",
-				Repo:       xrpcc.Auth.Did,
-				Record: &lexutil.LexiconTypeDecoder{
-					Val: post,
-				},
-			})
-			if err != nil {
-				log.Println(err, resp.Uri)
-			}
-		}
-		return nil
-	}
-
-	rsc := &events.RepoStreamCallbacks{
-		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
-			if evt.TooBig {
-				log.Printf("skipping too big events for now: %d", evt.Seq)
-				return nil
-			}
-			r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
-			if err != nil {
-				return fmt.Errorf("reading repo from car (seq: %d, len: %d): %w", evt.Seq, len(evt.Blocks), err)
-			}
-
-			for _, op := range evt.Ops {
-				ek := repomgr.EventKind(op.Action)
-				switch ek {
-				case repomgr.EvtKindCreateRecord, repomgr.EvtKindUpdateRecord:
-					rc, rec, err := r.GetRecord(ctx, op.Path)
-					if err != nil {
-						e := fmt.Errorf("getting record %s (%s) within seq %d for %s: %w", op.Path, *op.Cid, evt.Seq, evt.Repo, err)
-						log.Print(e)
-						continue
-					}
-
-					if lexutil.LexLink(rc) != *op.Cid {
-						// TODO: do we even error here?
-						return fmt.Errorf("mismatch in record and op cid: %s != %s", rc, *op.Cid)
-					}
-
-					if err := cb(ek, evt.Seq, op.Path, evt.Repo, &rc, rec); err != nil {
-						log.Printf("event consumer callback (%s): %s", ek, err)
-						continue
-					}
-
-				case repomgr.EvtKindDeleteRecord:
-					if err := cb(ek, evt.Seq, op.Path, evt.Repo, nil, nil); err != nil {
-						log.Printf("event consumer callback (%s): %s", ek, err)
-						continue
-					}
-				}
-			}
-			return nil
-		},
-	}
-
-	return events.HandleRepoStream(ctx, con, sequential.NewScheduler("stream", rsc.EventHandler), slog.Default())
-}
@@ -1,40 +1,23 @@
 package main
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"testing"
 
-func TestStreamHost(t *testing.T) {
-	tests := []struct {
-		name   string
-		host   string
-		cursor string
-		want   string
-	}{
-		{
-			name: "without cursor",
-			host: "https://bsky.network",
-			want: "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos",
-		},
-		{
-			name:   "with cursor",
-			host:   "https://bsky.network",
-			cursor: "123",
-			want:   "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos?cursor=123",
-		},
-		{
-			name:   "preserve existing query",
-			host:   "https://bsky.network?foo=bar",
-			cursor: "123",
-			want:   "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos?cursor=123&foo=bar",
-		},
-	}
+	"github.com/bluesky-social/indigo/xrpc"
+)
 
-	for _, tt := range tests {
-		got, err := streamHost(tt.host, tt.cursor)
-		if err != nil {
-			t.Fatalf("%s: streamHost returned error: %v", tt.name, err)
-		}
-		if got != tt.want {
-			t.Fatalf("%s: want %q but got %q", tt.name, tt.want, got)
-		}
+func TestIsInvalidSwap(t *testing.T) {
+	err := &xrpc.Error{StatusCode: 400, Wrapped: &xrpc.XRPCError{ErrStr: "InvalidSwap", Message: "Record was at bafyrei"}}
+	if !isInvalidSwap(fmt.Errorf("put: %w", err)) {
+		t.Fatal("should be an invalid swap")
+	}
+	err = &xrpc.Error{StatusCode: 400, Wrapped: &xrpc.XRPCError{ErrStr: "InvalidRequest"}}
+	if isInvalidSwap(err) {
+		t.Fatal("should not be an invalid swap")
+	}
+	if isInvalidSwap(errors.New("InvalidSwap")) {
+		t.Fatal("should not be an invalid swap")
 	}
 }
@@ -0,0 +1,965 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/api/chat"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/uniseg"
+	"github.com/urfave/cli/v2"
+)
+
+// maxMessageGraphemes is the limit of the text of a chat message.
+const maxMessageGraphemes = 1000
+
+// tuiItem is a row in a tab of the TUI. One of post, notif, convo and
+// message is set, or note for a post which cannot be shown in a thread.
+type tuiItem struct {
+	post    *bsky.FeedDefs_PostView
+	reason  string
+	depth   int
+	focus   bool
+	note    string
+	notif   *bsky.NotificationListNotifications_Notification
+	convo   *chat.ConvoDefs_ConvoView
+	message *chat.ConvoDefs_MessageView
+}
+
+// tuiTab is a tab of the TUI. load fetches the items after cursor, and
+// returns the cursor of the next page.
+type tuiTab struct {
+	name     string
+	load     func(cursor string) ([]*tuiItem, string, error)
+	items    []*tuiItem
+	cursor   string
+	loaded   bool
+	sel, top int
+	closable bool
+	convo    *chat.ConvoDefs_ConvoView
+}
+
+// tuiCompose is the text being written in the compose pane.
+type tuiCompose struct {
+	title string
+	text  string
+	pos   int
+	reply string
+	quote string
+	convo string
+}
+
+func (c *tuiCompose) limit() int {
+	if c.convo != "" {
+		return maxMessageGraphemes
+	}
+	return maxPostGraphemes
+}
+
+type tui struct {
+	screen  tcell.Screen
+	xrpcc   *xrpc.Client
+	r       *renderer
+	tabs    []*tuiTab
+	cur     int
+	compose *tuiCompose
+	status  string
+
+	// chat returns the client for the chat API, which is created on first
+	// use. refresh refreshes the session when the token is expired.
+	chat    func() (*xrpc.Client, error)
+	chatc   *xrpc.Client
+	refresh func() error
+}
+
+func newTUI(screen tcell.Screen, xrpcc *xrpc.Client, chatClient func() (*xrpc.Client, error)) *tui {
+	t := &tui{
+		screen: screen,
+		xrpcc:  xrpcc,
+		r:      &renderer{},
+		chat:   chatClient,
+	}
+	t.tabs = []*tuiTab{
+		{name: "Timeline", load: t.loadTimeline},
+		{name: "Notifications", load: t.loadNotifications},
+		{name: "Chat", load: t.loadConvos},
+	}
+	return t
+}
+
+// addFeedTabs adds the pinned feeds before the chat tab.
+func (t *tui) addFeedTabs() error {
+	feeds, err := getSavedFeeds(t.xrpcc)
+	if err != nil {
+		return err
+	}
+	var tabs []*tuiTab
+	for _, f := range feeds {
+		if !f.Pinned {
+			continue
+		}
+		name := f.Name
+		if name == "" {
+			name = f.Uri[strings.LastIndex(f.Uri, "/")+1:]
+		}
+		typ, uri := f.Type, f.Uri
+		tabs = append(tabs, &tuiTab{name: name, load: func(cursor string) ([]*tuiItem, string, error) {
+			return t.loadFeed(typ, uri, cursor)
+		}})
+	}
+	chatTab := t.tabs[len(t.tabs)-1]
+	t.tabs = append(append(t.tabs[:len(t.tabs)-1], tabs...), chatTab)
+	return nil
+}
+
+// call calls fn, and calls it again after refreshing the session when the
+// token is expired.
+func (t *tui) call(fn func() error) error {
+	err := fn()
+	if isExpiredToken(err) && t.refresh != nil {
+		if err := t.refresh(); err != nil {
+			return fmt.Errorf("cannot refresh session: %w", err)
+		}
+		t.chatc = nil
+		err = fn()
+	}
+	return err
+}
+
+func (t *tui) chatClient() (*xrpc.Client, error) {
+	if t.chatc == nil {
+		c, err := t.chat()
+		if err != nil {
+			return nil, fmt.Errorf("cannot create chat client: %w", err)
+		}
+		t.chatc = c
+	}
+	return t.chatc, nil
+}
+
+func feedItems(feed []*bsky.FeedDefs_FeedViewPost) []*tuiItem {
+	var items []*tuiItem
+	for _, p := range feed {
+		it := &tuiItem{post: p.Post}
+		switch {
+		case p.Reason != nil && p.Reason.FeedDefs_ReasonRepost != nil && p.Reason.FeedDefs_ReasonRepost.By != nil:
+			it.reason = "⚡ reposted by " + p.Reason.FeedDefs_ReasonRepost.By.Handle
+		case isPinned(p):
+			it.reason = "📌 pinned"
+		case p.Reply != nil && p.Reply.Parent != nil && p.Reply.Parent.FeedDefs_PostView != nil:
+			it.reason = "↩️ reply to " + p.Reply.Parent.FeedDefs_PostView.Author.Handle
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+func (t *tui) loadTimeline(cursor string) ([]*tuiItem, string, error) {
+	resp, err := bsky.FeedGetTimeline(context.TODO(), t.xrpcc, "reverse-chronological", cursor, 50)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get timeline: %w", err)
+	}
+	return feedItems(resp.Feed), stringp(resp.Cursor), nil
+}
+
+func (t *tui) loadFeed(typ, uri, cursor string) ([]*tuiItem, string, error) {
+	if typ == "list" {
+		resp, err := bsky.FeedGetListFeed(context.TODO(), t.xrpcc, cursor, 50, uri)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot get list feed: %w", err)
+		}
+		return feedItems(resp.Feed), stringp(resp.Cursor), nil
+	}
+	resp, err := bsky.FeedGetFeed(context.TODO(), t.xrpcc, cursor, uri, 50)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get feed: %w", err)
+	}
+	return feedItems(resp.Feed), stringp(resp.Cursor), nil
+}
+
+func (t *tui) loadNotifications(cursor string) ([]*tuiItem, string, error) {
+	resp, err := bsky.NotificationListNotifications(context.TODO(), t.xrpcc, cursor, 50, false, nil, "")
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get notifications: %w", err)
+	}
+	var items []*tuiItem
+	for _, n := range resp.Notifications {
+		items = append(items, &tuiItem{notif: n})
+	}
+	if cursor == "" {
+		bsky.NotificationUpdateSeen(context.TODO(), t.xrpcc, &bsky.NotificationUpdateSeen_Input{
+			SeenAt: time.Now().Local().Format(time.RFC3339),
+		})
+	}
+	return items, stringp(resp.Cursor), nil
+}
+
+func (t *tui) loadConvos(cursor string) ([]*tuiItem, string, error) {
+	c, err := t.chatClient()
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := chat.ConvoListConvos(context.TODO(), c, cursor, "", 50, "", "", "")
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot list conversations: %w", err)
+	}
+	var items []*tuiItem
+	for _, convo := range resp.Convos {
+		items = append(items, &tuiItem{convo: convo})
+	}
+	return items, stringp(resp.Cursor), nil
+}
+
+func (t *tui) loadMessages(convoId, cursor string) ([]*tuiItem, string, error) {
+	c, err := t.chatClient()
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := chat.ConvoGetMessages(context.TODO(), c, convoId, cursor, 50)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get messages: %w", err)
+	}
+	var items []*tuiItem
+	for _, m := range resp.Messages {
+		if m.ConvoDefs_MessageView != nil {
+			items = append(items, &tuiItem{message: m.ConvoDefs_MessageView})
+		}
+	}
+	return items, stringp(resp.Cursor), nil
+}
+
+func (t *tui) loadThread(uri string) ([]*tuiItem, string, error) {
+	resp, err := bsky.FeedGetPostThread(context.TODO(), t.xrpcc, 6, 10, uri)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get post thread: %w", err)
+	}
+	root := newThreadTree(resp.Thread, uri, 10)
+	sortThread(root, "time")
+
+	var items []*tuiItem
+	var walk func(n *threadNode, depth int)
+	walk = func(n *threadNode, depth int) {
+		it := &tuiItem{post: n.Post, depth: depth, focus: n.Focus}
+		if n.Post == nil {
+			it.note = newExportPost(n).Note
+		}
+		items = append(items, it)
+		for _, r := range n.Replies {
+			if n.Focus || depth > 0 {
+				walk(r, depth+1)
+			} else {
+				walk(r, depth)
+			}
+		}
+	}
+	walk(root, 0)
+	return items, "", nil
+}
+
+func (t *tui) tab() *tuiTab {
+	return t.tabs[t.cur]
+}
+
+// reload loads the first page of the current tab.
+func (t *tui) reload() {
+	tab := t.tab()
+	t.status = "loading..."
+	t.draw()
+	var items []*tuiItem
+	var cursor string
+	err := t.call(func() (err error) {
+		items, cursor, err = tab.load("")
+		return err
+	})
+	tab.loaded = true
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	tab.items, tab.cursor, tab.sel, tab.top = items, cursor, 0, 0
+	for i, it := range items {
+		if it.focus {
+			tab.sel = i
+		}
+	}
+	t.status = ""
+}
+
+// loadMore loads the next page of the current tab.
+func (t *tui) loadMore() {
+	tab := t.tab()
+	if tab.cursor == "" {
+		return
+	}
+	var items []*tuiItem
+	var cursor string
+	err := t.call(func() (err error) {
+		items, cursor, err = tab.load(tab.cursor)
+		return err
+	})
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	tab.items = append(tab.items, items...)
+	tab.cursor = cursor
+}
+
+func (t *tui) switchTab(i int) {
+	t.cur = (i + len(t.tabs)) % len(t.tabs)
+	if !t.tab().loaded {
+		t.reload()
+	}
+}
+
+func (t *tui) openTab(tab *tuiTab) {
+	tab.closable = true
+	t.tabs = append(t.tabs, tab)
+	t.switchTab(len(t.tabs) - 1)
+}
+
+func (t *tui) closeTab() {
+	if !t.tab().closable {
+		return
+	}
+	t.tabs = append(t.tabs[:t.cur], t.tabs[t.cur+1:]...)
+	t.switchTab(t.cur - 1)
+}
+
+func (t *tui) move(d int) {
+	tab := t.tab()
+	tab.sel = max(min(tab.sel+d, len(tab.items)-1), 0)
+	if tab.sel == len(tab.items)-1 {
+		t.loadMore()
+	}
+}
+
+func (t *tui) selected() *tuiItem {
+	tab := t.tab()
+	if tab.sel < 0 || tab.sel >= len(tab.items) {
+		return nil
+	}
+	return tab.items[tab.sel]
+}
+
+// selectedPost returns the reference and the handle of the selected post.
+// Notifications of replies, mentions and quotes are posts too.
+func (t *tui) selectedPost() (*comatproto.RepoStrongRef, string) {
+	it := t.selected()
+	switch {
+	case it == nil:
+	case it.post != nil:
+		return &comatproto.RepoStrongRef{Uri: it.post.Uri, Cid: it.post.Cid}, it.post.Author.Handle
+	case it.notif != nil:
+		switch it.notif.Reason {
+		case "reply", "mention", "quote":
+			return &comatproto.RepoStrongRef{Uri: it.notif.Uri, Cid: it.notif.Cid}, it.notif.Author.Handle
+		}
+	}
+	return nil, ""
+}
+
+func (t *tui) like() {
+	ref, _ := t.selectedPost()
+	if ref == nil {
+		return
+	}
+	it := t.selected()
+	if it.post != nil && it.post.Viewer != nil && it.post.Viewer.Like != nil {
+		t.status = "already liked"
+		return
+	}
+	var uri string
+	err := t.call(func() (err error) {
+		uri, err = createLike(t.xrpcc, ref)
+		return err
+	})
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	if it.post != nil {
+		if it.post.Viewer == nil {
+			it.post.Viewer = &bsky.FeedDefs_ViewerState{}
+		}
+		it.post.Viewer.Like = &uri
+		n := int64p(it.post.LikeCount) + 1
+		it.post.LikeCount = &n
+	}
+	t.status = "liked"
+}
+
+func (t *tui) repost() {
+	ref, _ := t.selectedPost()
+	if ref == nil {
+		return
+	}
+	it := t.selected()
+	if it.post != nil && it.post.Viewer != nil && it.post.Viewer.Repost != nil {
+		t.status = "already reposted"
+		return
+	}
+	var uri string
+	err := t.call(func() (err error) {
+		uri, err = createRepost(t.xrpcc, ref)
+		return err
+	})
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	if it.post != nil {
+		if it.post.Viewer == nil {
+			it.post.Viewer = &bsky.FeedDefs_ViewerState{}
+		}
+		it.post.Viewer.Repost = &uri
+		n := int64p(it.post.RepostCount) + 1
+		it.post.RepostCount = &n
+	}
+	t.status = "reposted"
+}
+
+// open opens the thread of the selected post, or the selected conversation.
+func (t *tui) open() {
+	it := t.selected()
+	if it == nil {
+		return
+	}
+	if it.convo != nil {
+		convo := it.convo
+		var members []string
+		for _, m := range convo.Members {
+			if m.Did != t.xrpcc.Auth.Did {
+				members = append(members, m.Handle)
+			}
+		}
+		t.openTab(&tuiTab{name: strings.Join(members, ","), convo: convo, load: func(cursor string) ([]*tuiItem, string, error) {
+			return t.loadMessages(convo.Id, cursor)
+		}})
+		return
+	}
+	uri := ""
+	if ref, _ := t.selectedPost(); ref != nil {
+		uri = ref.Uri
+	} else if it.notif != nil && it.notif.ReasonSubject != nil {
+		uri = *it.notif.ReasonSubject
+	}
+	if uri == "" || !strings.Contains(uri, "/app.bsky.feed.post/") {
+		return
+	}
+	t.openTab(&tuiTab{name: "Thread", load: func(string) ([]*tuiItem, string, error) {
+		return t.loadThread(uri)
+	}})
+}
+
+func (t *tui) startCompose(kind string) {
+	if convo := t.tab().convo; convo != nil {
+		t.compose = &tuiCompose{title: "Message to " + t.tab().name, convo: convo.Id}
+		return
+	}
+	if kind == "post" {
+		t.compose = &tuiCompose{title: "New post"}
+		return
+	}
+	ref, handle := t.selectedPost()
+	if ref == nil {
+		return
+	}
+	if kind == "reply" {
+		t.compose = &tuiCompose{title: "Reply to @" + handle, reply: ref.Uri}
+	} else {
+		t.compose = &tuiCompose{title: "Quote @" + handle, quote: ref.Uri}
+	}
+}
+
+// send posts the text in the compose pane, or sends it as a message.
+func (t *tui) send() {
+	c := t.compose
+	text := strings.TrimSpace(c.text)
+	if c.convo != "" {
+		if text == "" {
+			t.status = "message is empty"
+			return
+		}
+		if n := countGraphemes(text); n > maxMessageGraphemes {
+			t.status = fmt.Sprintf("message is too long: %d graphemes (max %d)", n, maxMessageGraphemes)
+			return
+		}
+		err := t.call(func() error {
+			cc, err := t.chatClient()
+			if err != nil {
+				return err
+			}
+			_, err = sendChatMessage(cc, c.convo, text, makeFacets(t.xrpcc, text))
+			return err
+		})
+		if err != nil {
+			t.status = err.Error()
+			return
+		}
+		t.compose = nil
+		t.reload()
+		t.status = "sent"
+		return
+	}
+
+	var resp *comatproto.RepoCreateRecord_Output
+	err := t.call(func() (err error) {
+		resp, err = createPost(t.xrpcc, &draft{Text: text, Reply: c.reply, Quote: c.quote})
+		return err
+	})
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.compose = nil
+	t.status = "posted " + resp.Uri
+}
+
+// handleComposeKey edits the text in the compose pane. The cursor moves by
+// graphemes.
+func (t *tui) handleComposeKey(ev *tcell.EventKey) {
+	c := t.compose
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		t.compose = nil
+		t.status = "canceled"
+	case tcell.KeyCtrlS:
+		t.send()
+	case tcell.KeyEnter:
+		c.insert("\n")
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if start := prevGrapheme(c.text, c.pos); start < c.pos {
+			c.text = c.text[:start] + c.text[c.pos:]
+			c.pos = start
+		}
+	case tcell.KeyDelete:
+		if end := nextGrapheme(c.text, c.pos); end > c.pos {
+			c.text = c.text[:c.pos] + c.text[end:]
+		}
+	case tcell.KeyLeft:
+		c.pos = prevGrapheme(c.text, c.pos)
+	case tcell.KeyRight:
+		c.pos = nextGrapheme(c.text, c.pos)
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		c.pos = strings.LastIndex(c.text[:c.pos], "\n") + 1
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		if i := strings.Index(c.text[c.pos:], "\n"); i >= 0 {
+			c.pos += i
+		} else {
+			c.pos = len(c.text)
+		}
+	case tcell.KeyCtrlU:
+		c.text, c.pos = "", 0
+	case tcell.KeyRune:
+		c.insert(string(ev.Rune()))
+	}
+}
+
+func (c *tuiCompose) insert(s string) {
+	c.text = c.text[:c.pos] + s + c.text[c.pos:]
+	c.pos += len(s)
+}
+
+// prevGrapheme returns the start of the grapheme before pos.
+func prevGrapheme(s string, pos int) int {
+	start := 0
+	g := uniseg.NewGraphemes(s[:pos])
+	for g.Next() {
+		start, _ = g.Positions()
+	}
+	return start
+}
+
+// nextGrapheme returns the end of the grapheme at pos.
+func nextGrapheme(s string, pos int) int {
+	if pos >= len(s) {
+		return len(s)
+	}
+	cluster, _, _, _ := uniseg.FirstGraphemeClusterInString(s[pos:], -1)
+	return pos + len(cluster)
+}
+
+// handleKey handles a key and returns true to quit.
+func (t *tui) handleKey(ev *tcell.EventKey) bool {
+	if t.compose != nil {
+		t.handleComposeKey(ev)
+		return false
+	}
+	t.status = ""
+	switch ev.Key() {
+	case tcell.KeyCtrlC:
+		return true
+	case tcell.KeyTab, tcell.KeyRight:
+		t.switchTab(t.cur + 1)
+	case tcell.KeyBacktab, tcell.KeyLeft:
+		t.switchTab(t.cur - 1)
+	case tcell.KeyDown:
+		t.move(1)
+	case tcell.KeyUp:
+		t.move(-1)
+	case tcell.KeyPgDn:
+		t.move(10)
+	case tcell.KeyPgUp:
+		t.move(-10)
+	case tcell.KeyHome:
+		t.move(-len(t.tab().items))
+	case tcell.KeyEnd:
+		t.move(len(t.tab().items))
+	case tcell.KeyEnter:
+		t.open()
+	case tcell.KeyEscape:
+		t.closeTab()
+	case tcell.KeyCtrlR:
+		t.reload()
+	case tcell.KeyRune:
+		switch r := ev.Rune(); r {
+		case 'q':
+			if !t.tab().closable {
+				return true
+			}
+			t.closeTab()
+		case 'j':
+			t.move(1)
+		case 'k':
+			t.move(-1)
+		case 'g':
+			t.move(-len(t.tab().items))
+		case 'G':
+			t.move(len(t.tab().items))
+		case 'u':
+			t.reload()
+		case 'l':
+			t.like()
+		case 't':
+			t.repost()
+		case 'r':
+			t.startCompose("reply")
+		case 'Q':
+			t.startCompose("quote")
+		case 'n':
+			t.startCompose("post")
+		default:
+			if r >= '1' && r <= '9' && int(r-'1') < len(t.tabs) {
+				t.switchTab(int(r - '1'))
+			}
+		}
+	}
+	return false
+}
+
+// tuiLine is a line on the screen.
+type tuiLine struct {
+	text  string
+	style tcell.Style
+}
+
+var (
+	tuiStyleHandle = tcell.StyleDefault.Foreground(tcell.ColorRed).Bold(true)
+	tuiStyleDim    = tcell.StyleDefault.Dim(true)
+	tuiStyleLink   = tcell.StyleDefault.Foreground(tcell.ColorBlue)
+	tuiStyleCursor = tcell.StyleDefault.Foreground(tcell.ColorAqua)
+	tuiStyleBar    = tcell.StyleDefault.Reverse(true)
+	tuiStyleError  = tcell.StyleDefault.Foreground(tcell.ColorRed)
+)
+
+var notificationReasons = map[string]string{
+	"like":    "👍 liked by",
+	"repost":  "⚡ reposted by",
+	"follow":  "👤 followed by",
+	"mention": "@ mentioned by",
+	"reply":   "↩️ replied by",
+	"quote":   "💬 quoted by",
+}
+
+// itemLines renders it in width.
+func (t *tui) itemLines(it *tuiItem, width int) []tuiLine {
+	var lines []tuiLine
+	add := func(style tcell.Style, ss ...string) {
+		for _, s := range ss {
+			lines = append(lines, tuiLine{text: s, style: style})
+		}
+	}
+	indent := strings.Repeat("  ", it.depth)
+	width -= len(indent)
+
+	switch {
+	case it.note != "":
+		add(tuiStyleDim, it.note)
+	case it.post != nil:
+		if it.reason != "" {
+			add(tuiStyleDim, it.reason)
+		}
+		e := newExportPost(&threadNode{Uri: it.post.Uri, Post: it.post})
+		for i, l := range t.r.postLines(e, width) {
+			if i == 0 {
+				if it.focus {
+					l = "▶ " + l
+				}
+				add(tuiStyleHandle, l)
+			} else {
+				add(tcell.StyleDefault, l)
+			}
+		}
+		liked, reposted := " ", " "
+		if v := it.post.Viewer; v != nil {
+			if v.Like != nil {
+				liked = "*"
+			}
+			if v.Repost != nil {
+				reposted = "*"
+			}
+		}
+		add(tuiStyleDim, fmt.Sprintf("👍%s%d  ⚡%s%d  ↩️ %d",
+			liked, int64p(it.post.LikeCount), reposted, int64p(it.post.RepostCount), int64p(it.post.ReplyCount)))
+	case it.notif != nil:
+		n := it.notif
+		reason, ok := notificationReasons[n.Reason]
+		if !ok {
+			reason = n.Reason + " by"
+		}
+		t0, _ := parseTime(n.IndexedAt)
+		header := reason + " " + n.Author.Handle
+		if rt := relativeTime(t0, t.r.now); rt != "" {
+			header += " · " + rt
+		}
+		style := tcell.StyleDefault
+		if !n.IsRead {
+			style = style.Bold(true)
+		}
+		add(style, header)
+		if n.Record != nil {
+			if rec, ok := n.Record.Val.(*bsky.FeedPost); ok {
+				add(tcell.StyleDefault, t.r.wrap([]span{{text: rec.Text}}, width)...)
+			}
+		}
+	case it.convo != nil:
+		c := it.convo
+		var members []string
+		for _, m := range c.Members {
+			if m.Did != t.xrpcc.Auth.Did {
+				members = append(members, m.Handle)
+			}
+		}
+		header := strings.Join(members, ", ")
+		if c.UnreadCount > 0 {
+			header += fmt.Sprintf(" (%d unread)", c.UnreadCount)
+		}
+		add(tuiStyleHandle, header)
+		if c.LastMessage != nil && c.LastMessage.ConvoDefs_MessageView != nil {
+			add(tuiStyleDim, templateTrunc(width-3, tableCell(c.LastMessage.ConvoDefs_MessageView.Text)))
+		}
+	case it.message != nil:
+		m := it.message
+		sender := m.Sender.Did
+		if convo := t.tab().convo; convo != nil {
+			for _, member := range convo.Members {
+				if member.Did == sender {
+					sender = member.Handle
+				}
+			}
+		}
+		t0, _ := parseTime(m.SentAt)
+		add(tuiStyleHandle, sender+" · "+relativeTime(t0, t.r.now))
+		add(tcell.StyleDefault, t.r.wrap([]span{{text: m.Text}}, width)...)
+	}
+	add(tcell.StyleDefault, "")
+
+	if indent != "" {
+		for i := range lines {
+			lines[i].text = indent + lines[i].text
+		}
+	}
+	return lines
+}
+
+// drawList draws the items of the current tab from y0 to y1, keeping the
+// selected item visible.
+func (t *tui) drawList(y0, y1, width int) {
+	tab := t.tab()
+	height := y1 - y0
+	if len(tab.items) == 0 || height <= 0 {
+		if tab.loaded && height > 0 {
+			t.screen.PutStrStyled(2, y0, "(empty)", tuiStyleDim)
+		}
+		return
+	}
+
+	rendered := map[int][]tuiLine{}
+	lines := func(i int) []tuiLine {
+		if l, ok := rendered[i]; ok {
+			return l
+		}
+		rendered[i] = t.itemLines(tab.items[i], width-2)
+		return rendered[i]
+	}
+	tab.top = min(tab.top, tab.sel)
+	for tab.top < tab.sel {
+		h := 0
+		for i := tab.top; i <= tab.sel; i++ {
+			h += len(lines(i))
+		}
+		if h <= height {
+			break
+		}
+		tab.top++
+	}
+
+	y := y0
+	for i := tab.top; i < len(tab.items) && y < y1; i++ {
+		for _, l := range lines(i) {
+			if y >= y1 {
+				break
+			}
+			if i == tab.sel && l.text != "" {
+				t.screen.PutStrStyled(0, y, "▌", tuiStyleCursor)
+			}
+			t.screen.PutStrStyled(2, y, l.text, l.style)
+			y++
+		}
+	}
+}
+
+// composeLines lays out the text of the compose pane in width, and returns
+// the lines and the position of the cursor.
+func composeLines(text string, pos, width int) ([]string, int, int) {
+	lines := []string{""}
+	x, cx, cy := 0, 0, 0
+	g := uniseg.NewGraphemes(text)
+	for g.Next() {
+		start, _ := g.Positions()
+		if start == pos {
+			cx, cy = x, len(lines)-1
+		}
+		s := g.Str()
+		if s == "\n" || s == "\r\n" {
+			lines = append(lines, "")
+			x = 0
+			continue
+		}
+		w := g.Width()
+		if x+w > width {
+			lines = append(lines, "")
+			x = 0
+			if start == pos {
+				cx, cy = 0, len(lines)-1
+			}
+		}
+		lines[len(lines)-1] += s
+		x += w
+	}
+	if pos >= len(text) {
+		cx, cy = x, len(lines)-1
+		if cx >= width {
+			lines = append(lines, "")
+			cx, cy = 0, len(lines)-1
+		}
+	}
+	return lines, cx, cy
+}
+
+var tuiHelp = map[bool]string{
+	false: "j/k:move tab:switch enter:open l:like t:repost r:reply Q:quote n:post u:reload q:quit",
+	true:  "j/k:move n:message u:reload esc:close q:quit",
+}
+
+func (t *tui) draw() {
+	t.r.now = time.Now()
+	t.screen.Clear()
+	t.screen.HideCursor()
+	w, h := t.screen.Size()
+
+	x := 0
+	for i, tab := range t.tabs {
+		label := fmt.Sprintf(" %d:%s ", i+1, tab.name)
+		style := tcell.StyleDefault
+		if i == t.cur {
+			style = tuiStyleBar
+		}
+		t.screen.PutStrStyled(x, 0, label, style)
+		x += uniseg.StringWidth(label)
+	}
+
+	bottom := h - 1
+	if c := t.compose; c != nil {
+		lines, cx, cy := composeLines(c.text, c.pos, w-2)
+		rows := max(min(len(lines), h/2), 3)
+		top := bottom - rows - 2
+		bottom = top
+		t.screen.PutStrStyled(0, top, strings.Repeat("─", w), tuiStyleDim)
+		t.screen.PutStrStyled(1, top, " "+c.title+" ", tuiStyleLink)
+		count := countGraphemes(strings.TrimSpace(c.text))
+		counter := fmt.Sprintf(" %d/%d ", count, c.limit())
+		style := tuiStyleDim
+		if count > c.limit() {
+			style = tuiStyleError
+		}
+		t.screen.PutStrStyled(w-uniseg.StringWidth(counter)-1, top, counter, style)
+		// scroll the text to show the cursor
+		skip := max(cy-rows+1, 0)
+		for i := 0; i < rows && skip+i < len(lines); i++ {
+			t.screen.PutStrStyled(1, top+1+i, lines[skip+i], tcell.StyleDefault)
+		}
+		t.screen.PutStrStyled(1, top+rows+1, "ctrl-s:send esc:cancel", tuiStyleDim)
+		t.screen.ShowCursor(1+cx, top+1+cy-skip)
+	}
+
+	t.drawList(1, bottom, w)
+
+	if t.status != "" {
+		t.screen.PutStrStyled(0, h-1, t.status, tuiStyleBar)
+	} else {
+		t.screen.PutStrStyled(0, h-1, tuiHelp[t.tab().convo != nil], tuiStyleDim)
+	}
+	t.screen.Show()
+}
+
+func (t *tui) run() error {
+	for {
+		t.draw()
+		switch ev := t.screen.PollEvent().(type) {
+		case nil:
+			return nil
+		case *tcell.EventResize:
+			t.screen.Sync()
+		case *tcell.EventKey:
+			if t.handleKey(ev) {
+				return nil
+			}
+		}
+	}
+}
+
+func doTUI(cCtx *cli.Context) error {
+	xrpcc, err := makeXRPCC(cCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create client: %w", err)
+	}
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return fmt.Errorf("cannot create screen: %w", err)
+	}
+	if err := screen.Init(); err != nil {
+		return fmt.Errorf("cannot initialize screen: %w", err)
+	}
+	defer screen.Fini()
+
+	t := newTUI(screen, xrpcc, func() (*xrpc.Client, error) {
+		return makeChatXRPCC(cCtx)
+	})
+	t.refresh = func() error {
+		return refreshSession(cCtx, xrpcc)
+	}
+	feedErr := t.addFeedTabs()
+	t.reload()
+	if feedErr != nil {
+		t.status = feedErr.Error()
+	}
+	return t.run()
+}
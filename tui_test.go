@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/gdamore/tcell/v2"
+)
+
+// mockXRPC is an XRPC server which returns the canned responses by NSID, and
+// records the bodies of the procedures and the queries of the queries called.
+type mockXRPC struct {
+	mu        sync.Mutex
+	responses map[string]string
+	calls     map[string][]string
+}
+
+func (m *mockXRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nsid := strings.TrimPrefix(r.URL.Path, "/xrpc/")
+	b, _ := io.ReadAll(r.Body)
+	if r.Method == http.MethodGet {
+		b = []byte(r.URL.RawQuery)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[nsid] = append(m.calls[nsid], string(b))
+	resp, ok := m.responses[nsid]
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		io.WriteString(w, `{"error":"NotImplemented","message":"`+nsid+`"}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, resp)
+}
+
+func newMockTUI(t *testing.T, responses map[string]string) (*tui, *mockXRPC, tcell.SimulationScreen) {
+	t.Helper()
+	m := &mockXRPC{responses: responses, calls: map[string][]string{}}
+	ts := httptest.NewServer(m)
+	t.Cleanup(ts.Close)
+
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(screen.Fini)
+	screen.SetSize(80, 24)
+
+	xrpcc := &xrpc.Client{
+		Client: ts.Client(),
+		Host:   ts.URL,
+		Auth:   &xrpc.AuthInfo{Did: "did:plc:me", Handle: "me.test", AccessJwt: "jwt"},
+	}
+	tu := newTUI(screen, xrpcc, func() (*xrpc.Client, error) {
+		return xrpcc, nil
+	})
+	return tu, m, screen
+}
+
+func screenText(screen tcell.SimulationScreen) string {
+	cells, w, _ := screen.GetContents()
+	var sb strings.Builder
+	for i, c := range cells {
+		if i > 0 && i%w == 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(string(c.Runes))
+	}
+	return sb.String()
+}
+
+func key(k tcell.Key) *tcell.EventKey {
+	return tcell.NewEventKey(k, 0, tcell.ModNone)
+}
+
+func keyRune(r rune) *tcell.EventKey {
+	return tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)
+}
+
+const mockTimeline = `{"feed":[
+{"post":{"uri":"at://did:plc:alice/app.bsky.feed.post/1","cid":"cid1","indexedAt":"2026-01-01T00:00:00Z",
+ "author":{"did":"did:plc:alice","handle":"alice.test"},
+ "record":{"$type":"app.bsky.feed.post","text":"hello from alice","createdAt":"2026-01-01T00:00:00Z"}}},
+{"post":{"uri":"at://did:plc:bob/app.bsky.feed.post/2","cid":"cid2","indexedAt":"2026-01-01T00:00:00Z",
+ "author":{"did":"did:plc:bob","handle":"bob.test"},"likeCount":1,
+ "record":{"$type":"app.bsky.feed.post","text":"hello from bob","createdAt":"2026-01-01T00:00:00Z"}},
+ "reason":{"$type":"app.bsky.feed.defs#reasonRepost","by":{"did":"did:plc:carol","handle":"carol.test"},"indexedAt":"2026-01-01T00:00:00Z"}}
+]}`
+
+func TestTUITimeline(t *testing.T) {
+	tu, m, screen := newMockTUI(t, map[string]string{
+		"app.bsky.feed.getTimeline":        mockTimeline,
+		"com.atproto.repo.createRecord":    `{"uri":"at://did:plc:me/app.bsky.feed.like/3","cid":"cid3"}`,
+		"app.bsky.notification.updateSeen": `{}`,
+	})
+	tu.reload()
+	tu.draw()
+	text := screenText(screen)
+	for _, want := range []string{"1:Timeline", "2:Notifications", "3:Chat", "alice.test", "hello from bob", "reposted by carol.test"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("want %q on the screen but got\n%s", want, text)
+		}
+	}
+
+	tu.handleKey(keyRune('j'))
+	tu.handleKey(keyRune('l'))
+	if tu.status != "liked" {
+		t.Fatalf("want liked but got %q", tu.status)
+	}
+	calls := m.calls["com.atproto.repo.createRecord"]
+	if len(calls) != 1 || !strings.Contains(calls[0], `"app.bsky.feed.like"`) || !strings.Contains(calls[0], "at://did:plc:bob/app.bsky.feed.post/2") {
+		t.Fatalf("want a like of bob's post but got %q", calls)
+	}
+	if got := int64p(tu.selected().post.LikeCount); got != 2 {
+		t.Fatalf("want 2 likes but got %d", got)
+	}
+	tu.handleKey(keyRune('l'))
+	if len(m.calls["com.atproto.repo.createRecord"]) != 1 {
+		t.Fatal("should not like twice")
+	}
+
+	if tu.handleKey(keyRune('q')) != true {
+		t.Fatal("q should quit")
+	}
+}
+
+func TestTUICompose(t *testing.T) {
+	tu, m, screen := newMockTUI(t, map[string]string{
+		"app.bsky.feed.getTimeline": mockTimeline,
+		"com.atproto.repo.getRecord": `{"uri":"at://did:plc:alice/app.bsky.feed.post/1","cid":"cid1",
+			"value":{"$type":"app.bsky.feed.post","text":"hello from alice","createdAt":"2026-01-01T00:00:00Z"}}`,
+		"com.atproto.repo.createRecord": `{"uri":"at://did:plc:me/app.bsky.feed.post/4","cid":"cid4"}`,
+	})
+	tu.reload()
+	tu.handleKey(keyRune('r'))
+	if tu.compose == nil || tu.compose.reply != "at://did:plc:alice/app.bsky.feed.post/1" {
+		t.Fatalf("want reply to alice but got %+v", tu.compose)
+	}
+	for _, r := range "hi 👍🏽" {
+		tu.handleKey(keyRune(r))
+	}
+	tu.draw()
+	if text := screenText(screen); !strings.Contains(text, "Reply to @alice.test") || !strings.Contains(text, " 4/300 ") {
+		t.Fatalf("want the compose pane with 4 graphemes but got\n%s", text)
+	}
+	tu.handleKey(key(tcell.KeyBackspace2))
+	if tu.compose.text != "hi " {
+		t.Fatalf("want the emoji deleted as a grapheme but got %q", tu.compose.text)
+	}
+	tu.handleKey(key(tcell.KeyLeft))
+	tu.handleKey(keyRune('!'))
+	if tu.compose.text != "hi! " {
+		t.Fatalf("want %q but got %q", "hi! ", tu.compose.text)
+	}
+
+	tu.handleKey(key(tcell.KeyCtrlS))
+	if tu.compose != nil {
+		t.Fatalf("want posted but got %q", tu.status)
+	}
+	calls := m.calls["com.atproto.repo.createRecord"]
+	if len(calls) != 1 {
+		t.Fatalf("want a post but got %q", calls)
+	}
+	var input struct {
+		Record struct {
+			Text  string `json:"text"`
+			Reply struct {
+				Parent struct {
+					Uri string `json:"uri"`
+				} `json:"parent"`
+			} `json:"reply"`
+		} `json:"record"`
+	}
+	if err := json.Unmarshal([]byte(calls[0]), &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.Record.Text != "hi!" || input.Record.Reply.Parent.Uri != "at://did:plc:alice/app.bsky.feed.post/1" {
+		t.Fatalf("want a reply to alice but got %s", calls[0])
+	}
+}
+
+func TestTUIChat(t *testing.T) {
+	tu, m, screen := newMockTUI(t, map[string]string{
+		"chat.bsky.convo.listConvos": `{"convos":[{"id":"c1","rev":"1","unreadCount":2,"muted":false,
+			"members":[{"did":"did:plc:me","handle":"me.test"},{"did":"did:plc:dave","handle":"dave.test"}]}]}`,
+		"chat.bsky.convo.getMessages": `{"messages":[{"$type":"chat.bsky.convo.defs#messageView","id":"m1","rev":"1",
+			"text":"are you there?","sentAt":"2026-01-01T00:00:00Z","sender":{"did":"did:plc:dave"}}]}`,
+		"chat.bsky.convo.sendMessage": `{"id":"m2","rev":"2","text":"yes","sentAt":"2026-01-01T00:01:00Z","sender":{"did":"did:plc:me"}}`,
+	})
+	tu.handleKey(keyRune('3'))
+	tu.draw()
+	if text := screenText(screen); !strings.Contains(text, "dave.test (2 unread)") {
+		t.Fatalf("want the conversation but got\n%s", text)
+	}
+
+	tu.handleKey(key(tcell.KeyEnter))
+	tu.draw()
+	if text := screenText(screen); !strings.Contains(text, "4:dave.test") || !strings.Contains(text, "are you there?") {
+		t.Fatalf("want the messages but got\n%s", text)
+	}
+
+	tu.handleKey(keyRune('n'))
+	for _, r := range "yes" {
+		tu.handleKey(keyRune(r))
+	}
+	tu.handleKey(key(tcell.KeyCtrlS))
+	calls := m.calls["chat.bsky.convo.sendMessage"]
+	if len(calls) != 1 || !strings.Contains(calls[0], `"convoId":"c1"`) || !strings.Contains(calls[0], `"text":"yes"`) {
+		t.Fatalf("want a message sent but got %q (%s)", calls, tu.status)
+	}
+
+	tu.handleKey(key(tcell.KeyEscape))
+	if len(tu.tabs) != 3 || tu.tab().name != "Chat" {
+		t.Fatalf("want the conversation closed but got %q", tu.tab().name)
+	}
+}
+
+func TestComposeLines(t *testing.T) {
+	lines, x, y := composeLines("abcdef\ngh", 3, 4)
+	if strings.Join(lines, "|") != "abcd|ef|gh" || x != 3 || y != 0 {
+		t.Fatalf("got %q %d,%d", lines, x, y)
+	}
+	_, x, y = composeLines("abcdef\ngh", 9, 4)
+	if x != 2 || y != 2 {
+		t.Fatalf("want cursor at 2,2 but got %d,%d", x, y)
+	}
+}
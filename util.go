@@ -14,10 +14,13 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	xdraw "golang.org/x/image/draw"
@@ -27,75 +30,14 @@ import (
 	"github.com/bluesky-social/indigo/api/bsky"
 	cliutil "github.com/bluesky-social/indigo/util/cliutil"
 	"github.com/bluesky-social/indigo/xrpc"
-	"github.com/fatih/color"
 	cidDecode "github.com/ipfs/go-cid"
 
 	"github.com/urfave/cli/v2"
 )
 
-func printPost(p *bsky.FeedDefs_PostView) {
-	rec := p.Record.Val.(*bsky.FeedPost)
-	color.Set(color.FgHiRed)
-	fmt.Print(p.Author.Handle)
-	color.Set(color.Reset)
-	fmt.Printf(" [%s]", stringp(p.Author.DisplayName))
-	fmt.Printf(" (%s)\n", timep(rec.CreatedAt).Format(time.RFC3339))
-	if rec.Entities != nil {
-		sort.Slice(rec.Entities, func(i, j int) bool {
-			return rec.Entities[i].Index.Start < rec.Entities[j].Index.Start
-		})
-		rs := []rune(rec.Text)
-		off := int64(0)
-		for _, e := range rec.Entities {
-			if e.Index.Start < 0 {
-				e.Index.Start = 0
-			}
-			if e.Index.End > int64(len(rs)) {
-				e.Index.End = int64(len(rs))
-			}
-			fmt.Print(string(rs[off:e.Index.Start]))
-			if e.Type == "mention" {
-				color.Set(color.Bold)
-			} else {
-				color.Set(color.Underline)
-			}
-			fmt.Print(string(rs[e.Index.Start:e.Index.End]))
-			color.Set(color.Reset)
-			off = e.Index.End
-		}
-		if off < int64(len(rs)) {
-			fmt.Print(string(rs[off:]))
-		}
-		fmt.Println()
-		//for _, e := range rec.Entities {
-		//	fmt.Printf(" {%s}\n", e.Value)
-		//}
-	} else {
-		fmt.Println(rec.Text)
-	}
-	if p.Embed != nil {
-		if p.Embed.EmbedImages_View != nil {
-			for _, i := range p.Embed.EmbedImages_View.Images {
-				fmt.Println(" {" + i.Fullsize + "}")
-			}
-		}
-	}
-	fmt.Printf(" 👍(%d)⚡(%d)↩️ (%d)\n",
-		int64p(p.LikeCount),
-		int64p(p.RepostCount),
-		int64p(p.ReplyCount),
-	)
-	if rec.Reply != nil && rec.Reply.Parent != nil {
-		fmt.Print(" > ")
-		color.Set(color.FgBlue)
-		fmt.Println(rec.Reply.Parent.Uri)
-		color.Set(color.Reset)
-	}
-	fmt.Print(" - ")
-	color.Set(color.FgBlue)
-	fmt.Println(p.Uri)
-	color.Set(color.Reset)
-	fmt.Println()
+func printPost(r *renderer, p *bsky.FeedDefs_PostView) {
+	r.println(r.post(p, r.width)...)
+	r.println("")
 }
 
 // maxBlobSize is the maximum size of a blob embedded in a post record.
@@ -137,16 +79,63 @@ var formats = []string{
 	"2006-01-02T15:04:05.000Z",
 	"2006-01-02T15:04:05.000000Z",
 	"2006-01-02T15:04:05-07:00",
+	time.RFC3339Nano,
 }
 
-func timep(s string) time.Time {
+// parseTime parses s in one of formats. Unlike timep, it does not panic on
+// unknown formats, for records written by other clients.
+func parseTime(s string) (time.Time, error) {
 	for _, f := range formats {
 		t, err := time.Parse(f, s)
 		if err == nil {
-			return t.Local()
+			return t.Local(), nil
 		}
 	}
-	panic(s)
+	return time.Time{}, fmt.Errorf("cannot parse time: %q", s)
+}
+
+func timep(s string) time.Time {
+	t, err := parseTime(s)
+	if err != nil {
+		panic(s)
+	}
+	return t
+}
+
+// atURI returns s as an AT URI. s may be a full URI, a path starting with a
+// DID, or a path of a did:plc repo without the method.
+func atURI(s string) string {
+	switch {
+	case strings.HasPrefix(s, "at://"):
+		return s
+	case strings.HasPrefix(s, "did:"):
+		return "at://" + s
+	default:
+		return "at://did:plc:" + s
+	}
+}
+
+// webURL returns the URL on bsky.app for the AT URI. The handle is used in
+// the URL instead of the DID when it is given.
+func webURL(uri, handle string) string {
+	parts := strings.Split(strings.TrimPrefix(uri, "at://"), "/")
+	actor := parts[0]
+	if handle != "" && handle != "handle.invalid" {
+		actor = handle
+	}
+	u := "https://bsky.app/profile/" + actor
+	if len(parts) < 3 {
+		return u
+	}
+	switch parts[1] {
+	case "app.bsky.feed.post":
+		return u + "/post/" + parts[2]
+	case "app.bsky.feed.generator":
+		return u + "/feed/" + parts[2]
+	case "app.bsky.graph.list":
+		return u + "/lists/" + parts[2]
+	}
+	return u
 }
 
 func int64p(i *int64) int64 {
@@ -163,6 +152,46 @@ func stringp(s *string) string {
 	return *s
 }
 
+func boolp(b *bool) bool {
+	return b != nil && *b
+}
+
+// shellCommand returns the command to run the command line with the shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/c", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// refreshSession gets new tokens with the refresh token of xrpcc, and
+// writes them to the auth file.
+func refreshSession(cCtx *cli.Context, xrpcc *xrpc.Client) error {
+	cfg := cCtx.App.Metadata["config"].(*config)
+
+	// the refresh token is sent on a copy, not to leave it in xrpcc as the
+	// access token when the refresh fails
+	rc := *xrpcc
+	auth := *xrpcc.Auth
+	auth.AccessJwt = auth.RefreshJwt
+	rc.Auth = &auth
+	refresh, err := comatproto.ServerRefreshSession(context.TODO(), &rc)
+	if err != nil {
+		return err
+	}
+	xrpcc.Auth.Did = refresh.Did
+	xrpcc.Auth.AccessJwt = refresh.AccessJwt
+	xrpcc.Auth.RefreshJwt = refresh.RefreshJwt
+
+	b, err := json.Marshal(xrpcc.Auth)
+	if err == nil {
+		if err := os.WriteFile(filepath.Join(cfg.dir, cfg.prefix+cfg.Handle+".auth"), b, 0600); err != nil {
+			return fmt.Errorf("cannot write auth file: %w", err)
+		}
+	}
+	return nil
+}
+
 func makeXRPCC(cCtx *cli.Context) (*xrpc.Client, error) {
 	cfg := cCtx.App.Metadata["config"].(*config)
 
@@ -175,22 +204,7 @@ func makeXRPCC(cCtx *cli.Context) (*xrpc.Client, error) {
 	auth, err := cliutil.ReadAuth(filepath.Join(cfg.dir, cfg.prefix+cfg.Handle+".auth"))
 	if err == nil {
 		xrpcc.Auth = auth
-		xrpcc.Auth.AccessJwt = xrpcc.Auth.RefreshJwt
-		refresh, err2 := comatproto.ServerRefreshSession(context.TODO(), xrpcc)
-		if err2 != nil {
-			err = err2
-		} else {
-			xrpcc.Auth.Did = refresh.Did
-			xrpcc.Auth.AccessJwt = refresh.AccessJwt
-			xrpcc.Auth.RefreshJwt = refresh.RefreshJwt
-
-			b, err := json.Marshal(xrpcc.Auth)
-			if err == nil {
-				if err := os.WriteFile(filepath.Join(cfg.dir, cfg.prefix+cfg.Handle+".auth"), b, 0600); err != nil {
-					return nil, fmt.Errorf("cannot write auth file: %w", err)
-				}
-			}
-		}
+		err = refreshSession(cCtx, xrpcc)
 	}
 	if err != nil {
 		input := &comatproto.ServerCreateSession_Input{
@@ -263,3 +277,48 @@ func ParseCid(cidUrl *string) (cidDecode.Cid, string, error) {
 
 	return decodedCid, "image/" + imageType, nil
 }
+
+// sessionClient shares an XRPC client between goroutines. The session is
+// refreshed once when the access token has expired, and the call is retried
+// with the new client.
+type sessionClient struct {
+	cCtx   *cli.Context
+	mu     sync.Mutex
+	client atomic.Pointer[xrpc.Client]
+}
+
+func newSessionClient(cCtx *cli.Context, xrpcc *xrpc.Client) *sessionClient {
+	s := &sessionClient{cCtx: cCtx}
+	s.client.Store(xrpcc)
+	return s
+}
+
+func (s *sessionClient) do(call func(*xrpc.Client) error) error {
+	xrpcc := s.client.Load()
+	err := call(xrpcc)
+	if err == nil || !isExpiredToken(err) {
+		return err
+	}
+	if err := s.refresh(xrpcc); err != nil {
+		return err
+	}
+	return call(s.client.Load())
+}
+
+// refresh replaces old with a copy with the new tokens, not to change the
+// tokens under the calls in flight.
+func (s *sessionClient) refresh(old *xrpc.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client.Load() != old {
+		return nil
+	}
+	xrpcc := *old
+	auth := *old.Auth
+	xrpcc.Auth = &auth
+	if err := refreshSession(s.cCtx, &xrpcc); err != nil {
+		return err
+	}
+	s.client.Store(&xrpcc)
+	return nil
+}
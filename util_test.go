@@ -5,8 +5,12 @@ import (
 	"image"
 	"image/png"
 	"math/rand"
+	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/urfave/cli/v2"
 )
 
 func TestTimep(t *testing.T) {
@@ -90,3 +94,66 @@ func TestCompressImage(t *testing.T) {
 		t.Fatal("broken image should be an error")
 	}
 }
+
+func TestAtURI(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "at://did:web:example.com/app.bsky.feed.post/abc", want: "at://did:web:example.com/app.bsky.feed.post/abc"},
+		{input: "did:web:example.com/app.bsky.feed.post/abc", want: "at://did:web:example.com/app.bsky.feed.post/abc"},
+		{input: "xxx/app.bsky.feed.post/abc", want: "at://did:plc:xxx/app.bsky.feed.post/abc"},
+	}
+	for _, test := range tests {
+		if got := atURI(test.input); got != test.want {
+			t.Fatalf("want %q but got %q", test.want, got)
+		}
+	}
+}
+
+func TestWebURL(t *testing.T) {
+	tests := []struct {
+		uri    string
+		handle string
+		want   string
+	}{
+		{uri: "at://did:plc:xxx/app.bsky.feed.post/abc", handle: "mattn.jp", want: "https://bsky.app/profile/mattn.jp/post/abc"},
+		{uri: "at://did:plc:xxx/app.bsky.feed.post/abc", handle: "handle.invalid", want: "https://bsky.app/profile/did:plc:xxx/post/abc"},
+		{uri: "at://did:plc:xxx/app.bsky.graph.list/abc", want: "https://bsky.app/profile/did:plc:xxx/lists/abc"},
+		{uri: "at://did:plc:xxx", want: "https://bsky.app/profile/did:plc:xxx"},
+	}
+	for _, test := range tests {
+		if got := webURL(test.uri, test.handle); got != test.want {
+			t.Fatalf("want %q but got %q", test.want, got)
+		}
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	m := &mockXRPC{responses: map[string]string{}, calls: map[string][]string{}}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	app := cli.NewApp()
+	app.Metadata = map[string]any{"config": &config{dir: t.TempDir(), Handle: "me.test"}}
+	cCtx := cli.NewContext(app, nil, nil)
+	xrpcc := &xrpc.Client{
+		Client: ts.Client(),
+		Host:   ts.URL,
+		Auth:   &xrpc.AuthInfo{Did: "did:plc:me", Handle: "me.test", AccessJwt: "access", RefreshJwt: "refresh"},
+	}
+
+	if err := refreshSession(cCtx, xrpcc); err == nil {
+		t.Fatal("the refresh should fail")
+	}
+	if xrpcc.Auth.AccessJwt != "access" || xrpcc.Auth.RefreshJwt != "refresh" {
+		t.Fatalf("the tokens should be kept when the refresh fails: %+v", xrpcc.Auth)
+	}
+
+	m.responses["com.atproto.server.refreshSession"] = `{"did":"did:plc:me","handle":"me.test","accessJwt":"access2","refreshJwt":"refresh2"}`
+	if err := refreshSession(cCtx, xrpcc); err != nil {
+		t.Fatal(err)
+	}
+	if xrpcc.Auth.AccessJwt != "access2" || xrpcc.Auth.RefreshJwt != "refresh2" {
+		t.Fatalf("the tokens should be replaced: %+v", xrpcc.Auth)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	atrepo "github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// commitVerifier verifies the commits of the firehose with --verify. The
+// signatures are verified with the signing keys in the DID documents of the
+// repos, which are cached by dir. The ops are checked against the MST of the
+// commit, by inverting them and comparing the root with the previous one.
+type commitVerifier struct {
+	dir identity.Directory
+
+	mu         sync.Mutex
+	quarantine io.Writer
+}
+
+// quarantinedCommit is a line of the quarantine file. The whole commit is
+// kept to examine or to process it again later.
+type quarantinedCommit struct {
+	Error  string                                `json:"error"`
+	Commit *comatproto.SyncSubscribeRepos_Commit `json:"commit"`
+}
+
+func newCommitVerifier(dir identity.Directory, quarantine io.Writer) *commitVerifier {
+	return &commitVerifier{dir: dir, quarantine: quarantine}
+}
+
+func (v *commitVerifier) verify(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	if _, err := atrepo.VerifyCommitMessage(ctx, evt); err != nil {
+		return fmt.Errorf("ops do not match the commit: %w", err)
+	}
+	err := atrepo.VerifyCommitSignature(ctx, v.dir, evt)
+	if err == nil {
+		return nil
+	}
+	// the key may have been rotated after it was cached
+	did, perr := syntax.ParseDID(evt.Repo)
+	if perr != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	v.dir.Purge(ctx, did.AtIdentifier())
+	if err := atrepo.VerifyCommitSignature(ctx, v.dir, evt); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// reject reports the invalid commit, and writes it to the quarantine file.
+func (v *commitVerifier) reject(evt *comatproto.SyncSubscribeRepos_Commit, err error) {
+	log.Printf("invalid commit (seq: %d, repo: %s): %v", evt.Seq, evt.Repo, err)
+	if v.quarantine == nil {
+		return
+	}
+	b, jerr := json.Marshal(&quarantinedCommit{Error: err.Error(), Commit: evt})
+	if jerr != nil {
+		log.Printf("cannot quarantine the commit: %v", jerr)
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, err := v.quarantine.Write(append(b, '\n')); err != nil {
+		log.Printf("cannot quarantine the commit: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	atrepo "github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// signedCommit returns a firehose commit which creates a post in a new repo
+// of did, signed with key.
+func signedCommit(t *testing.T, key atcrypto.PrivateKey, did string, seq int64) *comatproto.SyncSubscribeRepos_Commit {
+	t.Helper()
+	ctx := context.Background()
+	bs := atrepo.NewTinyBlockstore()
+	r := repo.NewRepo(ctx, did, bs)
+	rcid, rkey, err := r.CreateRecord(ctx, "app.bsky.feed.post", &bsky.FeedPost{Text: "hello", CreatedAt: "2026-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, rev, err := r.Commit(ctx, func(_ context.Context, _ string, b []byte) ([]byte, error) {
+		return key.HashAndSign(b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a repo with a record has the commit, the root of the MST and the record
+	blk, err := bs.Get(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commit atrepo.Commit
+	if err := commit.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []cid.Cid{root, commit.Data, rcid} {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := carutil.LdWrite(&buf, c.Bytes(), blk.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	link := lexutil.LexLink(rcid)
+	return &comatproto.SyncSubscribeRepos_Commit{
+		Repo:   did,
+		Rev:    rev,
+		Seq:    seq,
+		Time:   "2026-01-01T00:00:00Z",
+		Blocks: buf.Bytes(),
+		Commit: lexutil.LexLink(root),
+		Ops:    []*comatproto.SyncSubscribeRepos_RepoOp{{Action: "create", Path: "app.bsky.feed.post/" + rkey, Cid: &link}},
+	}
+}
+
+func newTestDirectory(t *testing.T, did string, key atcrypto.PrivateKey) *identity.MockDirectory {
+	t.Helper()
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:    syntax.DID(did),
+		Handle: "alice.test",
+		Keys: map[string]identity.VerificationMethod{
+			"atproto": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+	})
+	return dir
+}
+
+func TestCommitVerifier(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := "did:plc:alice"
+	var quarantine bytes.Buffer
+	v := newCommitVerifier(newTestDirectory(t, did, key), &quarantine)
+	ctx := context.Background()
+
+	if err := v.verify(ctx, signedCommit(t, key, did, 1)); err != nil {
+		t.Fatalf("want the commit verified but got %v", err)
+	}
+
+	forged := signedCommit(t, other, did, 2)
+	err = v.verify(ctx, forged)
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("want the forged signature rejected but got %v", err)
+	}
+	v.reject(forged, err)
+
+	tampered := signedCommit(t, key, did, 3)
+	tampered.Ops[0].Path = "app.bsky.feed.post/other"
+	err = v.verify(ctx, tampered)
+	if err == nil || !strings.Contains(err.Error(), "ops do not match") {
+		t.Fatalf("want the ops not in the MST rejected but got %v", err)
+	}
+
+	var q quarantinedCommit
+	if err := json.Unmarshal(quarantine.Bytes(), &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Commit.Seq != 2 || !strings.Contains(q.Error, "invalid signature") || len(q.Commit.Blocks) == 0 {
+		t.Fatalf("want the forged commit quarantined but got %+v", q)
+	}
+}
+
+func TestReadFirehoseVerify(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := "did:plc:alice"
+	ts := newFakeFirehose(t,
+		firehoseFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#commit"}, signedCommit(t, other, did, 1)),
+		firehoseFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#commit"}, signedCommit(t, key, did, 2)),
+	)
+	con, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	var quarantine bytes.Buffer
+	handled := make(chan *streamEvent, 2)
+	s := &streamReader{
+		handle: func(ev *streamEvent) error {
+			handled <- ev
+			return nil
+		},
+		verifier: newCommitVerifier(newTestDirectory(t, did, key), &quarantine),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.readFirehose(ctx, con)
+
+	var ev *streamEvent
+	select {
+	case ev = <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the valid commit handled")
+	}
+	if ev.Seq != 2 || ev.Did != did {
+		t.Fatalf("want only the valid commit handled but got %+v", ev)
+	}
+	if !strings.Contains(quarantine.String(), `"seq":1`) {
+		t.Fatalf("want the invalid commit quarantined but got %q", quarantine.String())
+	}
+}